@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	gohtml "html"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/pkg/errors"
+)
+
+const ACTIVITY_JSON = "application/activity+json"
+
+// Notes have no title, so one is made from the start of their text
+const NOTE_TITLE_LENGTH = 80
+
+// ActivityPub actors (fediverse accounts) are read from their public outbox,
+// as an ap+https url of the actor, or ap: and the account's address:
+//
+//	ap+https://mastodon.social/users/Gargron
+//	ap:Gargron@mastodon.social
+//
+// Their notes and articles become posts, boosts are left out.
+func isActivityPub(u *url.URL) bool {
+	return u.Scheme == "ap" || strings.HasPrefix(u.Scheme, "ap+")
+}
+
+type apActor struct {
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferredUsername"`
+	Outbox            string `json:"outbox"`
+}
+
+// An outbox collection, or a page of one
+type apCollection struct {
+	// The first page, a url or the page itself
+	First        json.RawMessage `json:"first"`
+	OrderedItems []*apActivity   `json:"orderedItems"`
+}
+
+type apActivity struct {
+	Type      string          `json:"type"`
+	Published string          `json:"published"`
+	Object    json.RawMessage `json:"object"`
+}
+
+type apObject struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Name      string          `json:"name"`
+	Summary   string          `json:"summary"`
+	Content   string          `json:"content"`
+	URL       json.RawMessage `json:"url"`
+	Published string          `json:"published"`
+	Updated   string          `json:"updated"`
+}
+
+func fetchActivityPubFeed(ctx context.Context, feedUrl *url.URL, fc *FeedConfig) (*gofeed.Feed, error) {
+	// Servers only answer with ActivityStreams when asked for it
+	apConfig := *fc
+	apConfig.Header = http.Header{}
+	for name, values := range fc.Header {
+		apConfig.Header[name] = values
+	}
+	apConfig.Header.Set("Accept", ACTIVITY_JSON)
+
+	actorUrl, err := activityPubActorUrl(ctx, feedUrl, &apConfig)
+	if err != nil {
+		return nil, err
+	}
+	actor := &apActor{}
+	if err := fetchActivityJson(ctx, actorUrl, &apConfig, actor); err != nil {
+		return nil, errors.Wrapf(err, "Failed reading actor %q", actorUrl)
+	}
+	if actor.Outbox == "" {
+		return nil, fmt.Errorf("Actor %q has no outbox", actorUrl)
+	}
+	outboxUrl, err := actorUrl.Parse(actor.Outbox)
+	if err != nil {
+		return nil, err
+	}
+	outbox := &apCollection{}
+	if err := fetchActivityJson(ctx, outboxUrl, &apConfig, outbox); err != nil {
+		return nil, errors.Wrapf(err, "Failed reading outbox %q", outboxUrl)
+	}
+
+	// Items are on the first page, which is linked or embedded
+	page := outbox
+	var first string
+	if err := json.Unmarshal(outbox.First, &first); err == nil {
+		firstUrl, err := outboxUrl.Parse(first)
+		if err != nil {
+			return nil, err
+		}
+		page = &apCollection{}
+		if err := fetchActivityJson(ctx, firstUrl, &apConfig, page); err != nil {
+			return nil, errors.Wrapf(err, "Failed reading outbox page %q", firstUrl)
+		}
+	} else if len(outbox.First) > 0 {
+		page = &apCollection{}
+		if err := json.Unmarshal(outbox.First, page); err != nil {
+			return nil, err
+		}
+	}
+
+	title := actor.Name
+	if title == "" {
+		title = actor.PreferredUsername
+	}
+	feed := &gofeed.Feed{Title: title, Link: actorUrl.String(), Items: []*gofeed.Item{}}
+	for _, a := range page.OrderedItems {
+		if a.Type != "Create" {
+			continue
+		}
+		obj := &apObject{}
+		if err := json.Unmarshal(a.Object, obj); err != nil || (obj.Type != "Note" && obj.Type != "Article") {
+			continue
+		}
+		published := obj.Published
+		if published == "" {
+			published = a.Published
+		}
+		t, err := time.Parse(time.RFC3339, published)
+		if err != nil {
+			continue
+		}
+		item := &gofeed.Item{
+			Title:           obj.Name,
+			Link:            apObjectLink(obj),
+			GUID:            obj.ID,
+			Content:         obj.Content,
+			Description:     obj.Summary,
+			PublishedParsed: &t,
+		}
+		if updated, err := time.Parse(time.RFC3339, obj.Updated); err == nil {
+			item.UpdatedParsed = &updated
+		}
+		if item.Title == "" {
+			item.Title = noteTitle(obj.Content)
+		}
+		feed.Items = append(feed.Items, item)
+	}
+	return feed, nil
+}
+
+// The actor's url, looking up ap:user@host addresses with WebFinger
+func activityPubActorUrl(ctx context.Context, feedUrl *url.URL, fc *FeedConfig) (*url.URL, error) {
+	if feedUrl.Scheme != "ap" {
+		actor := *feedUrl
+		actor.Scheme = strings.TrimPrefix(feedUrl.Scheme, "ap+")
+		return &actor, nil
+	}
+
+	account := strings.TrimPrefix(feedUrl.Opaque, "@")
+	parts := strings.Split(account, "@")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("Expected an account like ap:user@host, got %q", feedUrl.String())
+	}
+	webfinger := &url.URL{
+		Scheme:   "https",
+		Host:     parts[1],
+		Path:     "/.well-known/webfinger",
+		RawQuery: url.Values{"resource": {"acct:" + account}}.Encode(),
+	}
+	result := struct {
+		Links []struct {
+			Rel  string `json:"rel"`
+			Type string `json:"type"`
+			Href string `json:"href"`
+		} `json:"links"`
+	}{}
+	if err := fetchActivityJson(ctx, webfinger, fc, &result); err != nil {
+		return nil, errors.Wrapf(err, "Failed looking up %q", account)
+	}
+	for _, l := range result.Links {
+		if l.Rel == "self" && (l.Type == ACTIVITY_JSON || strings.Contains(l.Type, "activitystreams")) {
+			return url.Parse(l.Href)
+		}
+	}
+	return nil, fmt.Errorf("%q has no ActivityPub actor", account)
+}
+
+func fetchActivityJson(ctx context.Context, u *url.URL, fc *FeedConfig, v interface{}) error {
+	contents, err := fetchUrl(ctx, u, fc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(contents, v)
+}
+
+// An object's url is a string, a Link, or a list of either. Falls back to its
+// id.
+func apObjectLink(obj *apObject) string {
+	var s string
+	if err := json.Unmarshal(obj.URL, &s); err == nil && s != "" {
+		return s
+	}
+	type link struct {
+		Href      string `json:"href"`
+		MediaType string `json:"mediaType"`
+	}
+	var l link
+	if err := json.Unmarshal(obj.URL, &l); err == nil && l.Href != "" {
+		return l.Href
+	}
+	var list []json.RawMessage
+	if err := json.Unmarshal(obj.URL, &list); err == nil {
+		for _, raw := range list {
+			if err := json.Unmarshal(raw, &s); err == nil && s != "" {
+				return s
+			}
+			if err := json.Unmarshal(raw, &l); err == nil && l.Href != "" && (l.MediaType == "" || l.MediaType == "text/html") {
+				return l.Href
+			}
+		}
+	}
+	return obj.ID
+}
+
+// A title for an untitled post from the start of its html content
+func noteTitle(content string) string {
+	text := strings.Join(strings.Fields(gohtml.UnescapeString(tagRegex.ReplaceAllString(content, " "))), " ")
+	if len([]rune(text)) <= NOTE_TITLE_LENGTH {
+		return text
+	}
+	return strings.TrimSpace(truncate(text, NOTE_TITLE_LENGTH-1)) + "…"
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Credentials serve requires, from --auth
+type serveAuth struct {
+	user     string
+	password string
+}
+
+// Parse --auth, user:password, or just user to read the password from the
+// keyring's "serve" account so it isn't visible in ps
+func parseServeAuth(s string) (*serveAuth, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.SplitN(s, ":", 2)
+	auth := &serveAuth{user: parts[0]}
+	if len(parts) == 2 {
+		auth.password = parts[1]
+	} else {
+		password, err := keyringSecret("serve")
+		if err != nil {
+			return nil, err
+		}
+		auth.password = password
+	}
+	return auth, nil
+}
+
+// Require basic auth for h, or the password as ?token= for feed readers that
+// can't send credentials
+func (a *serveAuth) wrap(h http.Handler) http.Handler {
+	if a == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if ok && secureEqual(user, a.user) && secureEqual(password, a.password) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if token := r.URL.Query().Get("token"); token != "" && secureEqual(token, a.password) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="picofeed", charset="UTF-8"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+func secureEqual(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Cache directories left out of backups, they're fetched again as needed
+var unbackedCaches = []string{"favicons", "images"}
+
+// A directory archived under its name
+type backupDir struct {
+	name string
+	path func(elem ...string) (string, error)
+}
+
+var backupDirs = []backupDir{
+	{"config", configPath},
+	{"state", statePath},
+	{"cache", cachePath},
+}
+
+// Write the config, state and cache (but favicons and images) to a tar
+// archive at path, compressed with zstd or gzip if it ends in .zst or .gz.
+// It holds passwords and tokens, so only the user can read it.
+func writeBackup(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	files, err := writeArchive(f, path)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Not leaving a partial archive to be mistaken for a backup
+		os.Remove(path)
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Backed up %d files to %q\n", files, path)
+	return nil
+}
+
+// Write the backup's archive to out, returning how many files are in it
+func writeArchive(out io.Writer, path string) (int, error) {
+	w, closeCompression, err := compressedWriter(out, path)
+	if err != nil {
+		return 0, err
+	}
+	tw := tar.NewWriter(w)
+
+	files := 0
+	for _, d := range backupDirs {
+		root, err := d.path()
+		if err != nil {
+			return 0, err
+		}
+		err = filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) && file == root {
+				return filepath.SkipDir
+			}
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, file)
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if d.name == "cache" && contains(unbackedCaches, rel) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			// The lock belongs to this run, and temp files to interrupted saves
+			if !info.Mode().IsRegular() || (d.name == "state" && rel == "lock") || strings.HasSuffix(rel, ".tmp") {
+				return nil
+			}
+
+			hdr, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			hdr.Name = d.name + "/" + filepath.ToSlash(rel)
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			src, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer src.Close()
+			if _, err := io.Copy(tw, src); err != nil {
+				return err
+			}
+			files++
+			return nil
+		})
+		if err != nil {
+			return 0, errors.Wrapf(err, "Failed backing up %s", d.name)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	return files, closeCompression()
+}
+
+// Extract a backup written by writeBackup, overwriting the config, state and
+// cache files it has. Files it doesn't have are left alone. Everything is
+// extracted beside where it goes first, and only moved into place once the
+// whole archive has been read, so a bad one doesn't leave a half restore.
+func restoreBackup(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := decompressedReader(f, path)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+
+	// Extracted file -> where it goes, removed if they aren't all moved
+	staged := map[string]string{}
+	defer func() {
+		for tmp := range staged {
+			os.Remove(tmp)
+		}
+	}()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "Failed reading %q", path)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		parts := strings.SplitN(hdr.Name, "/", 2)
+		var root string
+		for _, d := range backupDirs {
+			if d.name == parts[0] {
+				root, err = d.path()
+				if err != nil {
+					return err
+				}
+			}
+		}
+		// Only files within the known directories, nothing escaping them
+		rel := ""
+		if len(parts) == 2 {
+			rel = filepath.Clean(filepath.FromSlash(parts[1]))
+		}
+		if root == "" || rel == "" || rel == "." || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("Unexpected file %q in %q", hdr.Name, path)
+		}
+
+		dest := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		tmp := dest + ".restore.tmp"
+		out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode).Perm())
+		if err != nil {
+			return err
+		}
+		staged[tmp] = dest
+		_, err = io.Copy(out, tr)
+		closeErr := out.Close()
+		if err != nil {
+			return errors.Wrapf(err, "Failed reading %q", path)
+		}
+		if closeErr != nil {
+			return closeErr
+		}
+	}
+
+	files := len(staged)
+	for tmp, dest := range staged {
+		if err := os.Rename(tmp, dest); err != nil {
+			return err
+		}
+		delete(staged, tmp)
+	}
+	fmt.Fprintf(os.Stderr, "Restored %d files from %q\n", files, path)
+	return nil
+}
+
+// Writer compressing to w by path's extension, and a func to flush it
+func compressedWriter(w io.Writer, path string) (io.Writer, func() error, error) {
+	switch {
+	case strings.HasSuffix(path, ".zst"):
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zw, zw.Close, nil
+	case strings.HasSuffix(path, ".gz") || strings.HasSuffix(path, ".tgz"):
+		gw := gzip.NewWriter(w)
+		return gw, gw.Close, nil
+	}
+	return w, func() error { return nil }, nil
+}
+
+func decompressedReader(r io.Reader, path string) (io.Reader, error) {
+	switch {
+	case strings.HasSuffix(path, ".zst"):
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case strings.HasSuffix(path, ".gz") || strings.HasSuffix(path, ".tgz"):
+		return gzip.NewReader(r)
+	}
+	return r, nil
+}
+
+// Whether path is a backup written by writeBackup, rather than OPML
+func isBackupArchive(path string) bool {
+	for _, ext := range []string{".tar", ".tar.zst", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	return false
+}
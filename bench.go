@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Phases of one fetch, zero for phases that didn't happen (e.g. no DNS lookup
+// for an IP, or DoH)
+type benchTiming struct {
+	dns     time.Duration
+	connect time.Duration
+	tls     time.Duration
+	ttfb    time.Duration
+	total   time.Duration
+}
+
+type benchResult struct {
+	feed    *url.URL
+	timings []*benchTiming
+	errors  int
+	lastErr error
+}
+
+// Connections aren't reused, so every round pays for DNS, connect and TLS like
+// a fresh run does
+var benchClient = &http.Client{Transport: benchTransport()}
+
+func benchTransport() *http.Transport {
+	t := transport.Clone()
+	t.DisableKeepAlives = true
+	return t
+}
+
+// Fetch each feed rounds times, in rounds so each host is only hit once per
+// round
+func benchFeeds(ctx context.Context, feeds []*url.URL, rounds int) []*benchResult {
+	results := []*benchResult{}
+	for _, f := range feeds {
+		results = append(results, &benchResult{feed: f})
+	}
+
+	for round := 0; round < rounds && ctx.Err() == nil; round++ {
+		fmt.Fprintf(os.Stderr, "Round %d of %d\n", round+1, rounds)
+		var wg sync.WaitGroup
+		for _, r := range results {
+			if r.feed.Scheme != "http" && r.feed.Scheme != "https" {
+				continue
+			}
+			wg.Add(1)
+			go func(r *benchResult) {
+				defer wg.Done()
+				timing, err := benchFetch(ctx, r.feed)
+				if err != nil {
+					r.errors++
+					r.lastErr = err
+					return
+				}
+				r.timings = append(r.timings, timing)
+			}(r)
+		}
+		wg.Wait()
+	}
+	return results
+}
+
+func benchFetch(ctx context.Context, u *url.URL) (*benchTiming, error) {
+	fc := config.feed(u)
+	ctx, cancel := context.WithTimeout(ctx, fc.timeout())
+	defer cancel()
+
+	release, err := limiter.acquire(ctx, u.Host)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	timing := &benchTiming{}
+	var dnsStart, connectStart, tlsStart time.Time
+	start := time.Now()
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:  func(httptrace.DNSDoneInfo) { timing.dns = time.Since(dnsStart) },
+		ConnectStart: func(string, string) {
+			if connectStart.IsZero() {
+				connectStart = time.Now()
+			}
+		},
+		ConnectDone:       func(string, string, error) { timing.connect = time.Since(connectStart) },
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { timing.tls = time.Since(tlsStart) },
+		GotFirstResponseByte: func() {
+			timing.ttfb = time.Since(start)
+		},
+	}
+
+	req, _ := http.NewRequest("GET", u.String(), nil)
+	for name, values := range fc.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("User-Agent", fc.userAgent())
+	req.Header.Set("Accept-Encoding", "br, gzip, deflate")
+	req = req.WithContext(httptrace.WithClientTrace(ctx, trace))
+
+	resp, err := benchClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(ioutil.Discard, bandwidth.reader(ctx, resp.Body)); err != nil {
+		return nil, err
+	}
+	timing.total = time.Since(start)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return timing, nil
+}
+
+// Nearest rank percentile p (0-100) of durations, sorted in place
+func percentile(durations []time.Duration, p int) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	rank := (p*len(durations) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return durations[rank-1]
+}
+
+// Print p50 and p90 of each phase per feed, slowest feeds first
+func renderBench(f io.Writer, results []*benchResult) {
+	phases := []struct {
+		name string
+		get  func(*benchTiming) time.Duration
+	}{
+		{"dns", func(t *benchTiming) time.Duration { return t.dns }},
+		{"connect", func(t *benchTiming) time.Duration { return t.connect }},
+		{"tls", func(t *benchTiming) time.Duration { return t.tls }},
+		{"ttfb", func(t *benchTiming) time.Duration { return t.ttfb }},
+		{"total", func(t *benchTiming) time.Duration { return t.total }},
+	}
+	durations := func(r *benchResult, get func(*benchTiming) time.Duration) []time.Duration {
+		ds := []time.Duration{}
+		for _, t := range r.timings {
+			ds = append(ds, get(t))
+		}
+		return ds
+	}
+	totalP50 := func(r *benchResult) time.Duration {
+		return percentile(durations(r, phases[len(phases)-1].get), 50)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return totalP50(results[i]) > totalP50(results[j])
+	})
+
+	fmt.Fprintf(f, "%-50s", "feed (p50/p90 ms)")
+	for _, phase := range phases {
+		fmt.Fprintf(f, " %11s", phase.name)
+	}
+	fmt.Fprintf(f, "  errors\n")
+	for _, r := range results {
+		fmt.Fprintf(f, "%-50s", truncate(r.feed.String(), 50))
+		for _, phase := range phases {
+			ds := durations(r, phase.get)
+			cell := "-"
+			if len(ds) > 0 {
+				cell = fmt.Sprintf("%d/%d", percentile(ds, 50).Milliseconds(), percentile(ds, 90).Milliseconds())
+			}
+			fmt.Fprintf(f, " %11s", cell)
+		}
+		fmt.Fprintf(f, "  %d\n", r.errors)
+	}
+
+	for _, r := range results {
+		if r.lastErr != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %q failed %d times, last with: %v\n", r.feed, r.errors, r.lastErr)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Picker actions and the keys they're on by default, in the order the footer
+// hints at them. Keys are named as readKey names them.
+var defaultPickKeys = []struct {
+	action string
+	keys   []string
+}{
+	{"mark", []string{"tab"}},
+	{"read", []string{"right"}},
+	{"close", []string{"left"}},
+	{"open", []string{"enter"}},
+	{"quit", []string{"esc", "ctrl-c", "ctrl-g"}},
+	{"up", []string{"up", "ctrl-p", "ctrl-k"}},
+	{"down", []string{"down", "ctrl-n", "ctrl-j"}},
+	{"page-up", []string{"pgup"}},
+	{"page-down", []string{"pgdown"}},
+	{"top", []string{"home"}},
+	{"bottom", []string{"end"}},
+	{"scroll-up", []string{"ctrl-b"}},
+	{"scroll-down", []string{"ctrl-f"}},
+	{"delete", []string{"backspace"}},
+	{"clear", []string{"ctrl-u"}},
+}
+
+var specialKeys = []string{"up", "down", "left", "right", "pgup", "pgdown", "home", "end", "enter", "tab", "backspace", "esc", "space"}
+
+// Picker colors by theme, SGR parameters for each part of the list and
+// reading pane. "default" is the theme without a [theme] section.
+var pickThemes = map[string]map[string]string{
+	"default": {
+		"cursor": "7", "marked": "1", "feed": "", "date": "", "footer": "2", "query": "",
+		"title": "1", "details": "2", "link": "2", "code": "36", "quote": "2",
+	},
+	"mono": {
+		"cursor": "7", "marked": "1", "feed": "", "date": "", "footer": "2", "query": "",
+		"title": "1", "details": "2", "link": "2", "code": "1", "quote": "2",
+	},
+	"solarized": {
+		"cursor": "38;5;234;48;5;136", "marked": "1;38;5;166", "feed": "38;5;37", "date": "38;5;64", "footer": "38;5;240", "query": "38;5;33",
+		"title": "1;38;5;33", "details": "38;5;240", "link": "38;5;125", "code": "38;5;37", "quote": "38;5;240",
+	},
+	"gruvbox": {
+		"cursor": "38;5;235;48;5;214", "marked": "1;38;5;208", "feed": "38;5;108", "date": "38;5;142", "footer": "38;5;245", "query": "38;5;214",
+		"title": "1;38;5;214", "details": "38;5;245", "link": "38;5;175", "code": "38;5;142", "quote": "38;5;245",
+	},
+}
+
+var sgrAttributes = map[string]string{"bold": "1", "dim": "2", "italic": "3", "underline": "4", "reverse": "7"}
+
+var sgrColors = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+func pickThemeNames() []string {
+	names := []string{}
+	for name := range pickThemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The picker's theme from the config's [theme] section, colors given there
+// replacing the named theme's
+func pickTheme(c *Config) map[string]string {
+	name := c.Theme
+	if name == "" {
+		name = "default"
+	}
+	theme := map[string]string{}
+	for part, style := range pickThemes[name] {
+		theme[part] = style
+	}
+	for part, style := range c.Colors {
+		theme[part] = style
+	}
+	return theme
+}
+
+// Keypress -> action for the picker, the defaults with the config's [keys]
+// section replacing the keys of the actions it lists. Also returns each
+// action's keys, to show in the footer.
+func pickKeys(c *Config) (map[string]string, map[string][]string) {
+	keys := map[string][]string{}
+	for _, d := range defaultPickKeys {
+		keys[d.action] = d.keys
+	}
+	// A key given to one action is taken from any other
+	for action, actionKeys := range c.Keys {
+		keys[action] = actionKeys
+		for other, otherKeys := range keys {
+			if other == action {
+				continue
+			}
+			kept := []string{}
+			for _, k := range otherKeys {
+				if !contains(actionKeys, k) {
+					kept = append(kept, k)
+				}
+			}
+			keys[other] = kept
+		}
+	}
+
+	actions := map[string]string{}
+	for action, actionKeys := range keys {
+		for _, k := range actionKeys {
+			if k == "space" {
+				k = " "
+			}
+			actions[k] = action
+		}
+	}
+	return actions, keys
+}
+
+func isPickAction(action string) bool {
+	for _, d := range defaultPickKeys {
+		if d.action == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether readKey can return key: a special key, ctrl-<letter> or a character
+func isKeyName(key string) bool {
+	if contains(specialKeys, key) || utf8.RuneCountInString(key) == 1 {
+		return true
+	}
+	return len(key) == 6 && strings.HasPrefix(key, "ctrl-") && key[5] >= 'a' && key[5] <= 'z'
+}
+
+// Key as shown in the footer
+func keyLabel(key string) string {
+	switch key {
+	case "up":
+		return "↑"
+	case "down":
+		return "↓"
+	case "left":
+		return "←"
+	case "right":
+		return "→"
+	}
+	return key
+}
+
+// Parse a color like "bold yellow on blue" into SGR parameters. Colors are
+// black, red, green, yellow, blue, magenta, cyan and white, bright- versions
+// of them, 256 color numbers or #rrggbb. "default" is the terminal's own.
+func parseColor(s string) (string, error) {
+	params := []string{}
+	background := false
+	for _, word := range strings.Fields(strings.ToLower(s)) {
+		if attr, ok := sgrAttributes[word]; ok {
+			params = append(params, attr)
+			continue
+		}
+		if word == "on" {
+			background = true
+			continue
+		}
+		if word == "default" {
+			continue
+		}
+
+		base := 38
+		if background {
+			base = 48
+		}
+		background = false
+		color, err := colorParams(word, base)
+		if err != nil {
+			return "", err
+		}
+		params = append(params, color)
+	}
+	return strings.Join(params, ";"), nil
+}
+
+// SGR parameters for a color word, base 38 for the foreground or 48 for the
+// background
+func colorParams(word string, base int) (string, error) {
+	bright := strings.HasPrefix(word, "bright-")
+	name := strings.TrimPrefix(word, "bright-")
+	for i, c := range sgrColors {
+		if c == name {
+			if bright {
+				return strconv.Itoa(base + 52 + i), nil
+			}
+			return strconv.Itoa(base - 8 + i), nil
+		}
+	}
+	if n, err := strconv.Atoi(word); err == nil && n >= 0 && n <= 255 {
+		return fmt.Sprintf("%d;5;%d", base, n), nil
+	}
+	if strings.HasPrefix(word, "#") && len(word) == 7 {
+		if rgb, err := strconv.ParseUint(word[1:], 16, 32); err == nil {
+			return fmt.Sprintf("%d;2;%d;%d;%d", base, rgb>>16, rgb>>8&0xff, rgb&0xff), nil
+		}
+	}
+	return "", fmt.Errorf("unknown color %q", word)
+}
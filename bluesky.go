@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/pkg/errors"
+)
+
+// Bluesky's public AppView, which answers without logging in
+const BSKY_API = "https://public.api.bsky.app/xrpc/"
+
+const BSKY_LIMIT = 50
+
+// Bluesky profiles and custom feeds, read from the public XRPC API:
+//
+//	bsky:@jay.bsky.team
+//	bsky:at://did:plc:z72i7hdynmk6r22z27h6tvur/app.bsky.feed.generator/whats-hot
+//
+// Posts sharing a link become posts of the link, with the post's text as their
+// content. A profile's reposts are left out.
+func isBluesky(u *url.URL) bool {
+	return u.Scheme == "bsky"
+}
+
+type bskyFeedResponse struct {
+	Feed []struct {
+		Post   *bskyPost       `json:"post"`
+		Reason json.RawMessage `json:"reason"`
+	} `json:"feed"`
+}
+
+type bskyPost struct {
+	URI    string `json:"uri"`
+	Author struct {
+		Handle      string `json:"handle"`
+		DisplayName string `json:"displayName"`
+	} `json:"author"`
+	Record struct {
+		Text      string `json:"text"`
+		CreatedAt string `json:"createdAt"`
+	} `json:"record"`
+	Embed *struct {
+		External *struct {
+			URI         string `json:"uri"`
+			Title       string `json:"title"`
+			Description string `json:"description"`
+			Thumb       string `json:"thumb"`
+		} `json:"external"`
+	} `json:"embed"`
+}
+
+func fetchBlueskyFeed(ctx context.Context, feedUrl *url.URL, fc *FeedConfig) (*gofeed.Feed, error) {
+	source := feedUrl.Opaque
+	feed := &gofeed.Feed{Items: []*gofeed.Item{}}
+	var endpoint string
+	params := url.Values{"limit": {fmt.Sprint(BSKY_LIMIT)}}
+	switch {
+	case strings.HasPrefix(source, "@"):
+		handle := strings.TrimPrefix(source, "@")
+		endpoint = "app.bsky.feed.getAuthorFeed"
+		params.Set("actor", handle)
+		params.Set("filter", "posts_no_replies")
+		feed.Title = handle
+		feed.Link = "https://bsky.app/profile/" + handle
+	case strings.HasPrefix(source, "at://") && strings.Contains(source, "/app.bsky.feed.generator/"):
+		endpoint = "app.bsky.feed.getFeed"
+		params.Set("feed", source)
+		generator := struct {
+			View struct {
+				DisplayName string `json:"displayName"`
+			} `json:"view"`
+		}{}
+		if err := bskyCall(ctx, "app.bsky.feed.getFeedGenerator", url.Values{"feed": {source}}, fc, &generator); err == nil {
+			feed.Title = generator.View.DisplayName
+		}
+		parts := strings.Split(strings.TrimPrefix(source, "at://"), "/")
+		feed.Link = "https://bsky.app/profile/" + parts[0] + "/feed/" + parts[len(parts)-1]
+	default:
+		return nil, fmt.Errorf("Expected bsky:@handle or bsky:at://<did>/app.bsky.feed.generator/<name>, got %q", feedUrl.String())
+	}
+
+	resp := &bskyFeedResponse{}
+	if err := bskyCall(ctx, endpoint, params, fc, resp); err != nil {
+		return nil, err
+	}
+	for _, entry := range resp.Feed {
+		p := entry.Post
+		// Reposts in a profile's feed
+		if p == nil || len(entry.Reason) > 0 {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, p.Record.CreatedAt)
+		if err != nil {
+			continue
+		}
+		// A profile is titled by its display name, which only its posts have
+		if feed.Title == p.Author.Handle && p.Author.DisplayName != "" {
+			feed.Title = p.Author.DisplayName
+		}
+
+		item := &gofeed.Item{
+			Title:           noteTitle(p.Record.Text),
+			Link:            bskyPostLink(p),
+			GUID:            p.URI,
+			Content:         p.Record.Text,
+			PublishedParsed: &t,
+			Author:          &gofeed.Person{Name: p.Author.Handle},
+		}
+		if p.Embed != nil && p.Embed.External != nil && p.Embed.External.URI != "" {
+			ext := p.Embed.External
+			item.Link = ext.URI
+			if ext.Title != "" {
+				item.Title = ext.Title
+			}
+			item.Content = strings.TrimSpace(p.Record.Text + "\n\n" + ext.Description)
+			if ext.Thumb != "" {
+				item.Image = &gofeed.Image{URL: ext.Thumb}
+			}
+		}
+		feed.Items = append(feed.Items, item)
+	}
+	return feed, nil
+}
+
+func bskyCall(ctx context.Context, method string, params url.Values, fc *FeedConfig, result interface{}) error {
+	u, err := url.Parse(BSKY_API + method + "?" + params.Encode())
+	if err != nil {
+		return err
+	}
+	contents, err := fetchUrl(ctx, u, fc)
+	if err != nil {
+		return errors.Wrapf(err, "%s failed", method)
+	}
+	return json.Unmarshal(contents, result)
+}
+
+// The post on bsky.app, at://<did>/app.bsky.feed.post/<rkey> as
+// https://bsky.app/profile/<handle>/post/<rkey>
+func bskyPostLink(p *bskyPost) string {
+	rkey := p.URI[strings.LastIndex(p.URI, "/")+1:]
+	return "https://bsky.app/profile/" + p.Author.Handle + "/post/" + rkey
+}
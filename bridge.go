@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Bridges are self-hosted RSSHub or Nitter instances, named in the config's
+// [bridge] section by their base url. Shortcuts like twitter:user expand to
+// a route on one of them, {user} being what follows the colon:
+//
+//	[bridge]
+//	rsshub = https://rsshub.example.com
+//	nitter = https://nitter.example.com
+//	pixiv = {rsshub}/pixiv/user/{user}
+var bridgeNames = []string{"rsshub", "nitter"}
+
+// Routes of the built in shortcuts, the first with its bridges configured is
+// used
+var bridgeRoutes = map[string][]string{
+	"twitter":   {"{nitter}/{user}/rss", "{rsshub}/twitter/user/{user}"},
+	"instagram": {"{rsshub}/instagram/user/{user}"},
+	"threads":   {"{rsshub}/threads/{user}"},
+	"tiktok":    {"{rsshub}/tiktok/user/@{user}"},
+	"youtube":   {"{rsshub}/youtube/user/@{user}"},
+	"telegram":  {"{rsshub}/telegram/channel/{user}"},
+}
+
+var bridgePlaceholderRegex = regexp.MustCompile(`\{([a-z]+)\}`)
+
+// Set a bridge's base url, or a shortcut's route
+func (c *Config) setBridge(key string, value string) error {
+	if c.Bridges == nil {
+		c.Bridges = map[string]string{}
+	}
+	if contains(bridgeNames, key) {
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("bad %s %q, expected a url", key, value)
+		}
+		c.Bridges[key] = strings.TrimSuffix(value, "/")
+		return nil
+	}
+	if !strings.Contains(value, "{user}") {
+		return fmt.Errorf("unknown bridge %q, expected one of %s or a route with {user}", key, strings.Join(bridgeNames, ", "))
+	}
+	for _, m := range bridgePlaceholderRegex.FindAllStringSubmatch(value, -1) {
+		if m[1] != "user" && !contains(bridgeNames, m[1]) {
+			return fmt.Errorf("unknown bridge {%s} in %q", m[1], value)
+		}
+	}
+	c.Bridges[key] = value
+	return nil
+}
+
+// The bridge url for a shortcut like twitter:user, nil if u isn't one
+func (c *Config) bridged(u *url.URL) (*url.URL, error) {
+	if u.Opaque == "" {
+		return nil, nil
+	}
+	routes := bridgeRoutes[u.Scheme]
+	if route, ok := c.Bridges[u.Scheme]; ok && strings.Contains(route, "{user}") {
+		routes = []string{route}
+	}
+	if len(routes) == 0 {
+		return nil, nil
+	}
+
+	user := url.PathEscape(strings.TrimPrefix(u.Opaque, "@"))
+	for _, route := range routes {
+		missing := false
+		expanded := bridgePlaceholderRegex.ReplaceAllStringFunc(route, func(p string) string {
+			name := p[1 : len(p)-1]
+			if name == "user" {
+				return user
+			}
+			base, ok := c.Bridges[name]
+			missing = missing || !ok
+			return base
+		})
+		if missing {
+			continue
+		}
+		bridged, err := url.Parse(expanded)
+		if err != nil {
+			return nil, err
+		}
+		// Route parameters like ?limit=, passed on
+		if u.RawQuery != "" {
+			bridged.RawQuery = u.RawQuery
+		}
+		return bridged, nil
+	}
+	return nil, fmt.Errorf("%s: needs %s in the config's [bridge] section", u.Scheme, strings.Join(routeBridges(routes), " or "))
+}
+
+// Bridges the routes are on
+func routeBridges(routes []string) []string {
+	names := []string{}
+	for _, route := range routes {
+		for _, m := range bridgePlaceholderRegex.FindAllStringSubmatch(route, -1) {
+			if m[1] != "user" && !contains(names, m[1]) {
+				names = append(names, m[1])
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
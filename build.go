@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A page of posts in the static API, linking to the pages either side
+type apiPage struct {
+	Page  int        `json:"page"`
+	Pages int        `json:"pages"`
+	Total int        `json:"total"`
+	Prev  string     `json:"prev,omitempty"`
+	Next  string     `json:"next,omitempty"`
+	Posts []*apiPost `json:"posts"`
+}
+
+type apiPost struct {
+	*Post
+	Summary string `json:"summary"`
+	// The feed's pages, relative to the api's root
+	FeedPath string `json:"feed_path"`
+}
+
+// An entry in feeds.json or months.json, pointing at its first page
+type apiIndex struct {
+	Title  string   `json:"title"`
+	URL    string   `json:"url,omitempty"`
+	Alias  string   `json:"alias,omitempty"`
+	Path   string   `json:"path"`
+	Total  int      `json:"total"`
+	Latest *apiPost `json:"latest,omitempty"`
+}
+
+// Write posts as static JSON files under dir for a front end to read without
+// a server: latest.json, feeds.json with feeds/<feed>.json and months.json with
+// months/<yyyy-mm>.json. Lists of more than pageSize posts continue in
+// -2.json, -3.json etc pages, 0 for one page each.
+func buildApi(dir string, posts []*Post, pageSize int) error {
+	sort.Sort(ByTimestamp{posts})
+
+	feedPaths := map[string]string{}
+	byFeed := map[string][]*apiPost{}
+	byMonth := map[string][]*apiPost{}
+	all := []*apiPost{}
+	feeds := []string{}
+	for _, p := range posts {
+		path, ok := feedPaths[p.FeedLink]
+		if !ok {
+			path = "feeds/" + feedSlug(p, feedPaths) + ".json"
+			feedPaths[p.FeedLink] = path
+			feeds = append(feeds, p.FeedLink)
+		}
+		ap := &apiPost{Post: p, Summary: p.summary(), FeedPath: path}
+		all = append(all, ap)
+		byFeed[p.FeedLink] = append(byFeed[p.FeedLink], ap)
+		month := p.Timestamp.Format("2006-01")
+		byMonth[month] = append(byMonth[month], ap)
+	}
+
+	if err := writeApiPages(dir, "latest.json", all, pageSize); err != nil {
+		return err
+	}
+
+	feedIndex := []*apiIndex{}
+	for _, f := range feeds {
+		feedPosts := byFeed[f]
+		if err := writeApiPages(dir, feedPaths[f], feedPosts, pageSize); err != nil {
+			return err
+		}
+		feedIndex = append(feedIndex, &apiIndex{
+			Title:  feedPosts[0].feedName(),
+			URL:    f,
+			Alias:  feedPosts[0].FeedAlias,
+			Path:   feedPaths[f],
+			Total:  len(feedPosts),
+			Latest: feedPosts[0],
+		})
+	}
+	sort.SliceStable(feedIndex, func(i, j int) bool {
+		return strings.ToLower(feedIndex[i].Title) < strings.ToLower(feedIndex[j].Title)
+	})
+	if err := writeApiFile(dir, "feeds.json", feedIndex); err != nil {
+		return err
+	}
+
+	months := []string{}
+	for month := range byMonth {
+		months = append(months, month)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(months)))
+	monthIndex := []*apiIndex{}
+	for _, month := range months {
+		path := "months/" + month + ".json"
+		if err := writeApiPages(dir, path, byMonth[month], pageSize); err != nil {
+			return err
+		}
+		monthIndex = append(monthIndex, &apiIndex{
+			Title: byMonth[month][0].Timestamp.Format("January 2006"),
+			Path:  path,
+			Total: len(byMonth[month]),
+		})
+	}
+	if err := writeApiFile(dir, "months.json", monthIndex); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Wrote %d posts from %d feeds to %s\n", len(posts), len(feeds), dir)
+	return nil
+}
+
+// A file name for the post's feed from its alias or url, unique among used.
+// Repeats get _2 etc, -2 is for pages.
+func feedSlug(p *Post, used map[string]string) string {
+	name := p.FeedAlias
+	if name == "" {
+		name = p.FeedLink
+		if u, err := url.Parse(p.FeedLink); err == nil {
+			name = u.Host + u.Path
+		}
+	}
+	slug := strings.Trim(slugRegex.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if slug == "" {
+		slug = "feed"
+	}
+	taken := map[string]bool{}
+	for _, path := range used {
+		taken[path] = true
+	}
+	unique := slug
+	for n := 2; taken["feeds/"+unique+".json"]; n++ {
+		unique = fmt.Sprintf("%s_%d", slug, n)
+	}
+	return unique
+}
+
+// Write posts as path, and path-2.json etc if there's more than a page
+func writeApiPages(dir string, path string, posts []*apiPost, pageSize int) error {
+	if pageSize <= 0 || pageSize > len(posts) {
+		pageSize = len(posts)
+	}
+	pages := 1
+	if pageSize > 0 {
+		pages = (len(posts) + pageSize - 1) / pageSize
+	}
+	pagePath := func(page int) string {
+		if page == 1 {
+			return path
+		}
+		return fmt.Sprintf("%s-%d.json", strings.TrimSuffix(path, ".json"), page)
+	}
+
+	for page := 1; page <= pages; page++ {
+		start := (page - 1) * pageSize
+		end := start + pageSize
+		if end > len(posts) {
+			end = len(posts)
+		}
+		p := &apiPage{Page: page, Pages: pages, Total: len(posts), Posts: posts[start:end]}
+		if page > 1 {
+			p.Prev = pagePath(page - 1)
+		}
+		if page < pages {
+			p.Next = pagePath(page + 1)
+		}
+		if err := writeApiFile(dir, pagePath(page), p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeApiFile(dir string, path string, v interface{}) error {
+	full := filepath.Join(dir, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return err
+	}
+	contents, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(full, contents, 0644)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Backoff after a 429/503 without a usable Retry-After
+const DEFAULT_BACKOFF = 15 * time.Minute
+
+// A cached feed response
+type cacheEntry struct {
+	Fetched time.Time `json:"fetched"`
+	// From Cache-Control max-age, the body can be reused without a request
+	// until then
+	Expires      time.Time `json:"expires"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Body         []byte    `json:"body"`
+}
+
+func cacheEntryPath(u string) (string, error) {
+	sum := sha1.Sum([]byte(u))
+	return cachePath("feeds", hex.EncodeToString(sum[:])+".json")
+}
+
+// Cached response for u, nil if there isn't one
+func readCache(u string) *cacheEntry {
+	path, err := cacheEntryPath(u)
+	if err != nil {
+		return nil
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(contents, entry); err != nil {
+		return nil
+	}
+	return entry
+}
+
+func writeCache(u string, entry *cacheEntry) error {
+	path, err := cacheEntryPath(u)
+	if err != nil {
+		return err
+	}
+	contents, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, contents, 0644)
+}
+
+// Update entry's validators and expiry from a response, returning false if
+// the response shouldn't be cached at all
+func (entry *cacheEntry) update(resp *http.Response, now time.Time) bool {
+	entry.Fetched = now
+	entry.Expires = now
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		entry.ETag = etag
+	}
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		entry.LastModified = lastModified
+	}
+
+	for _, directive := range strings.Split(resp.Header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-store":
+			return false
+		case directive == "no-cache":
+			entry.Expires = now
+			return true
+		case strings.HasPrefix(directive, "max-age="):
+			if seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil {
+				entry.Expires = now.Add(time.Duration(seconds) * time.Second)
+			}
+		}
+	}
+	return true
+}
+
+// When to retry after a 429/503, from Retry-After in seconds or as a date
+func retryAfter(resp *http.Response, now time.Time) time.Time {
+	header := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return now.Add(time.Duration(seconds) * time.Second)
+	}
+	if t, err := http.ParseTime(header); err == nil && t.After(now) {
+		return t
+	}
+	return now.Add(DEFAULT_BACKOFF)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	xhtml "golang.org/x/net/html"
+)
+
+const CARD_CONCURRENCY = 8
+const CARD_MAX_AGE = 30 * 24 * time.Hour
+
+// Only the <head> is needed, don't download entire pages
+const CARD_MAX_BYTES = 512 * 1024
+
+// OpenGraph preview of a post
+type Card struct {
+	Image       string `json:"image"`
+	Description string `json:"description"`
+}
+
+// Fetch OpenGraph cards for each post, returning link -> card. At most
+// CARD_CONCURRENCY pages are fetched at once, and cards (including pages
+// without one) are cached on disk for CARD_MAX_AGE.
+func fetchCards(ctx context.Context, posts []*Post) map[string]*Card {
+	fmt.Fprintf(os.Stderr, "Fetching previews for %d posts\n", len(posts))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, CARD_CONCURRENCY)
+	cards := map[string]*Card{}
+	for _, p := range posts {
+		wg.Add(1)
+		go func(link string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			card, err := cachedCard(ctx, link)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed fetching preview for %q: %v\n", link, err)
+				return
+			}
+			if card.Image == "" && card.Description == "" {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			cards[link] = card
+		}(p.Link)
+	}
+	wg.Wait()
+
+	return cards
+}
+
+func cachedCard(ctx context.Context, link string) (*Card, error) {
+	sum := sha1.Sum([]byte(link))
+	path, err := cachePath("cards", hex.EncodeToString(sum[:])+".json")
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err == nil && (*offline || time.Since(info.ModTime()) < CARD_MAX_AGE) {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		card := &Card{}
+		return card, json.Unmarshal(contents, card)
+	}
+	if *offline {
+		return &Card{}, nil
+	}
+
+	card, err := fetchCard(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := json.Marshal(card)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return card, ioutil.WriteFile(path, contents, 0644)
+}
+
+func fetchCard(ctx context.Context, link string) (*Card, error) {
+	ctxTimeout, timeoutCancel := context.WithTimeout(ctx, FETCH_TIMEOUT)
+	defer timeoutCancel()
+
+	req, err := http.NewRequest("GET", link, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent())
+	req = req.WithContext(ctxTimeout)
+
+	release, err := limiter.acquire(ctxTimeout, req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// Cache as a page without a card
+		return &Card{}, nil
+	}
+
+	contents, err := ioutil.ReadAll(io.LimitReader(resp.Body, CARD_MAX_BYTES))
+	if err != nil {
+		return nil, err
+	}
+
+	og := ogProperties(string(contents))
+	card := &Card{
+		Image:       og["og:image"],
+		Description: og["og:description"],
+	}
+
+	// og:image is supposed to be absolute, but relative paths are common
+	if card.Image != "" {
+		base, _ := url.Parse(link)
+		if u, err := url.Parse(card.Image); err == nil && base != nil {
+			card.Image = base.ResolveReference(u).String()
+		}
+	}
+
+	return card, nil
+}
+
+// The first value of each OpenGraph property in the page's <meta> tags, by
+// property or (as some sites have it) name
+func ogProperties(contents string) map[string]string {
+	properties := map[string]string{}
+	z := xhtml.NewTokenizer(strings.NewReader(contents))
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			return properties
+		}
+		if tt != xhtml.StartTagToken && tt != xhtml.SelfClosingTagToken {
+			continue
+		}
+		name, hasAttr := z.TagName()
+		if !hasAttr || string(name) != "meta" {
+			continue
+		}
+		property, content := "", ""
+		for {
+			key, val, more := z.TagAttr()
+			switch string(key) {
+			case "property", "name":
+				if property == "" {
+					property = strings.ToLower(strings.TrimSpace(string(val)))
+				}
+			case "content":
+				content = strings.TrimSpace(string(val))
+			}
+			if !more {
+				break
+			}
+		}
+		if _, ok := properties[property]; !ok && strings.HasPrefix(property, "og:") && content != "" {
+			properties[property] = content
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+var changelogHeadingRegex = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+
+var changelogVersionRegex = regexp.MustCompile(`\bv?\d+\.\d+(\.\d+)?([-+.][0-9A-Za-z.-]+)?\b`)
+
+var changelogDateRegex = regexp.MustCompile(`\b(\d{4})[-/.](\d{1,2})[-/.](\d{1,2})\b`)
+
+var markdownLinkRegex = regexp.MustCompile(`\[([^\]]*)\](\([^)]*\)|\[[^\]]*\])?`)
+
+var anchorStripRegex = regexp.MustCompile(`[^\p{L}\p{N} _-]+`)
+
+// Software that only keeps a changelog, followed with changelog: and the
+// changelog's url:
+//
+//	changelog:https://github.com/owner/repo/blob/main/CHANGELOG.md
+//	changelog:https://github.com/owner/repo/releases
+//	changelog:https://example.com/docs/changelog.html
+//
+// Forge pages of Markdown files are read raw, and GitHub release pages from
+// their Atom feed. Each heading with a version and a date, like Keep a
+// Changelog's "## [1.2.0] - 2024-01-31", is a post of the changes under it.
+// Versions without a date (e.g. Unreleased) are left out.
+func isChangelog(u *url.URL) bool {
+	return u.Scheme == "changelog"
+}
+
+func fetchChangelogFeed(ctx context.Context, feedUrl *url.URL, fc *FeedConfig) (*gofeed.Feed, error) {
+	page, err := url.Parse(feedUrl.Opaque)
+	if err != nil || (page.Scheme != "http" && page.Scheme != "https") {
+		return nil, fmt.Errorf("Expected a changelog url like changelog:https://example.com/CHANGELOG.md, got %q", feedUrl.String())
+	}
+	page.RawQuery = feedUrl.RawQuery
+
+	parts := strings.Split(strings.Trim(page.Path, "/"), "/")
+	if page.Host == "github.com" && len(parts) >= 2 && (len(parts) == 2 || parts[2] == "releases" || parts[2] == "tags") {
+		releases := *page
+		releases.Path = "/" + parts[0] + "/" + parts[1] + "/releases.atom"
+		return fetchFeed(ctx, &releases, fc, 1)
+	}
+
+	raw := rawForgeUrl(page)
+	contents, err := fetchUrl(ctx, raw, fc)
+	if err != nil {
+		return nil, err
+	}
+	text := string(contents)
+	if raw == page && !isMarkdownPath(page.Path) {
+		text = htmlToMarkdown(text)
+	}
+
+	title := page.Host
+	if raw != page && len(parts) >= 2 {
+		title = parts[1]
+	}
+	feed := &gofeed.Feed{Title: title, Link: page.String(), Items: []*gofeed.Item{}}
+	for _, entry := range changelogEntries(text) {
+		link := *page
+		link.Fragment = headingAnchor(entry.heading)
+		feed.Items = append(feed.Items, &gofeed.Item{
+			Title:           entry.heading,
+			Link:            link.String(),
+			GUID:            page.String() + "#" + entry.version,
+			Content:         markdownParagraphs(entry.body),
+			PublishedParsed: &entry.date,
+		})
+	}
+	return feed, nil
+}
+
+type changelogEntry struct {
+	heading string
+	version string
+	date    time.Time
+	body    string
+}
+
+// Versions in a Markdown changelog, from the headings at the level of the first
+// one with a version
+func changelogEntries(text string) []*changelogEntry {
+	entries := []*changelogEntry{}
+	level := 0
+	var current *changelogEntry
+	body := []string{}
+	flush := func() {
+		if current != nil {
+			current.body = strings.TrimSpace(strings.Join(body, "\n"))
+			entries = append(entries, current)
+		}
+		current, body = nil, []string{}
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		m := changelogHeadingRegex.FindStringSubmatch(line)
+		if m == nil || (level > 0 && len(m[1]) > level) {
+			body = append(body, line)
+			continue
+		}
+		heading := strings.TrimSpace(markdownLinkRegex.ReplaceAllString(m[2], "$1"))
+		version := changelogVersionRegex.FindString(heading)
+		if level == 0 && version == "" {
+			continue
+		}
+		level = len(m[1])
+		flush()
+
+		d := changelogDateRegex.FindStringSubmatch(heading)
+		if version == "" || d == nil {
+			continue
+		}
+		date, err := time.ParseInLocation("2006-1-2", d[1]+"-"+d[2]+"-"+d[3], time.Local)
+		if err != nil {
+			continue
+		}
+		current = &changelogEntry{heading: heading, version: version, date: date}
+	}
+	flush()
+	return entries
+}
+
+// The raw file behind a forge's page of a Markdown file, or page itself
+func rawForgeUrl(page *url.URL) *url.URL {
+	if !isMarkdownPath(page.Path) {
+		return page
+	}
+	raw := *page
+	raw.Fragment = ""
+	switch {
+	case page.Host == "github.com" && strings.Contains(page.Path, "/blob/"):
+		raw.Host = "raw.githubusercontent.com"
+		raw.Path = strings.Replace(page.Path, "/blob/", "/", 1)
+	case strings.Contains(page.Path, "/-/blob/"):
+		// GitLab
+		raw.Path = strings.Replace(page.Path, "/-/blob/", "/-/raw/", 1)
+	case strings.Contains(page.Path, "/src/branch/") || strings.Contains(page.Path, "/src/tag/"):
+		// Gitea and Forgejo
+		raw.Path = strings.Replace(page.Path, "/src/", "/raw/", 1)
+	case page.Host == "git.sr.ht" && strings.Contains(page.Path, "/tree/"):
+		raw.Path = strings.Replace(strings.Replace(page.Path, "/tree/", "/blob/", 1), "/item/", "/", 1)
+	default:
+		return page
+	}
+	return &raw
+}
+
+func isMarkdownPath(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasSuffix(lower, ".md") || strings.HasSuffix(lower, ".markdown")
+}
+
+// A heading's anchor as GitHub and most renderers make them
+func headingAnchor(heading string) string {
+	return strings.ReplaceAll(anchorStripRegex.ReplaceAllString(strings.ToLower(heading), ""), " ", "-")
+}
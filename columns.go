@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A column of text output, see --columns
+type textColumn struct {
+	name string
+	// Padded or cut to this many characters, 0 to leave as is
+	width int
+}
+
+// Columns --columns can show, with their default widths
+var textColumnFields = map[string]struct {
+	width int
+	value func(p *Post) string
+}{
+	"date":       {10, func(p *Post) string { return p.Timestamp.Format("2006-01-02") }},
+	"time":       {16, func(p *Post) string { return p.Timestamp.Format("2006-01-02 15:04") }},
+	"feed":       {20, func(p *Post) string { return p.shortFeedName() }},
+	"title":      {70, func(p *Post) string { return p.displayTitle() }},
+	"link":       {0, func(p *Post) string { return p.Link }},
+	"author":     {20, func(p *Post) string { return p.Author }},
+	"categories": {30, func(p *Post) string { return strings.Join(p.Categories, ", ") }},
+	"lang":       {4, func(p *Post) string { return p.Lang }},
+	"minutes":    {4, minutesColumn},
+}
+
+// Reading time, blank for posts without content to measure
+func minutesColumn(p *Post) string {
+	if p.Words == 0 {
+		return ""
+	}
+	return fmt.Sprint(p.ReadingMinutes)
+}
+
+// Parsed --columns, nil for the default layout
+var textColumns []*textColumn
+
+// Parse --columns, names like date,feed,title:50,link with an optional width
+func parseColumns(s string) ([]*textColumn, error) {
+	if s == "" {
+		return nil, nil
+	}
+	columns := []*textColumn{}
+	for _, c := range strings.Split(s, ",") {
+		name, width, hasWidth := strings.Cut(strings.TrimSpace(c), ":")
+		field, ok := textColumnFields[name]
+		if !ok {
+			names := []string{}
+			for n := range textColumnFields {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("Unknown column %q in --columns, expected one of %s", name, strings.Join(names, ", "))
+		}
+		column := &textColumn{name: name, width: field.width}
+		if hasWidth {
+			w, err := strconv.Atoi(width)
+			if err != nil || w < 0 {
+				return nil, fmt.Errorf("Invalid width %q for column %q in --columns", width, name)
+			}
+			column.width = w
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// Whether the columns show when each post was published, so it doesn't need
+// date headings
+func hasDateColumn(columns []*textColumn) bool {
+	for _, c := range columns {
+		if c.name == "date" || c.name == "time" {
+			return true
+		}
+	}
+	return false
+}
+
+// The post's columns on one line, each padded or cut to its width except the
+// last, which is never padded
+func formatColumns(p *Post, columns []*textColumn) string {
+	cells := []string{}
+	for i, c := range columns {
+		value := []rune(strings.Join(strings.Fields(textColumnFields[c.name].value(p)), " "))
+		if c.width > 0 && len(value) > c.width {
+			value = append(value[:c.width-1], '…')
+		}
+		cell := string(value)
+		if c.width > 0 && i < len(columns)-1 {
+			cell += strings.Repeat(" ", c.width-len(value))
+		}
+		cells = append(cells, cell)
+	}
+	return strings.Join(cells, "  ")
+}
+
+// Write each post's columns as fields between fieldSep, ended by recordSep,
+// for scripts: nothing is padded, cut or headed, so with a NUL recordSep
+// (--print0) titles with newlines in them are safe to pipe to xargs -0.
+// Without --columns only the link is written.
+func renderDelimited(w io.Writer, posts []*Post, columns []*textColumn, fieldSep string, recordSep string) {
+	if columns == nil {
+		columns = []*textColumn{{name: "link"}}
+	}
+	sortPosts(posts)
+	for _, p := range posts {
+		fields := []string{}
+		for _, c := range columns {
+			fields = append(fields, textColumnFields[c.name].value(p))
+		}
+		fmt.Fprint(w, strings.Join(fields, fieldSep)+recordSep)
+	}
+}
+
+// A --field-sep or --record-sep, with \0, \t, \n, \r and \\ escapes
+func unescapeSeparator(s string) string {
+	return strings.NewReplacer(`\0`, "\x00", `\t`, "\t", `\n`, "\n", `\r`, "\r", `\\`, `\`).Replace(s)
+}
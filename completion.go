@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+var subcommands = []string{"add", "backup", "bench", "build", "completion", "diff", "discover", "heatmap", "mute", "proxy", "remove", "restore", "save", "serve", "snapshot", "unmute", "validate", "version"}
+
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// Allowed values of flags that take one of a fixed set
+func flagValues() map[string][]string {
+	themeNames := []string{"auto"}
+	for name := range themes {
+		themeNames = append(themeNames, name)
+	}
+	sort.Strings(themeNames[1:])
+
+	return map[string][]string{
+		"feed":    config.aliases(),
+		"skip":    config.aliases(),
+		"output":  outputFormats,
+		"save-to": saveServiceNames(),
+		"theme":   themeNames,
+	}
+}
+
+// Write a completion script for shell covering subcommands and flags
+func renderCompletion(f io.Writer, shell string) error {
+	flags := []*flag.Flag{}
+	flag.CommandLine.VisitAll(func(fl *flag.Flag) {
+		if !fl.Hidden {
+			flags = append(flags, fl)
+		}
+	})
+
+	switch shell {
+	case "bash":
+		bashCompletion(f, flags)
+	case "zsh":
+		zshCompletion(f, flags)
+	case "fish":
+		fishCompletion(f, flags)
+	default:
+		return fmt.Errorf("Unknown shell %q, expected one of %s", shell, strings.Join(completionShells, ", "))
+	}
+	return nil
+}
+
+func bashCompletion(f io.Writer, flags []*flag.Flag) {
+	names := []string{}
+	for _, fl := range flags {
+		names = append(names, "--"+fl.Name)
+	}
+
+	fmt.Fprintf(f, `_picofeed() {
+	local cur="${COMP_WORDS[COMP_CWORD]}"
+	local prev="${COMP_WORDS[COMP_CWORD-1]}"
+
+	case "$prev" in
+`)
+	values := flagValues()
+	for _, name := range sortedKeys(values) {
+		fmt.Fprintf(f, "\t--%s)\n\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n\t\treturn\n\t\t;;\n", name, strings.Join(values[name], " "))
+	}
+	fmt.Fprintf(f, `	completion)
+		COMPREPLY=($(compgen -W %q -- "$cur"))
+		return
+		;;
+	remove|mute|unmute)
+		COMPREPLY=($(compgen -W %q -- "$cur"))
+		return
+		;;
+	esac
+
+	if [[ "$cur" == -* ]]; then
+		COMPREPLY=($(compgen -W %q -- "$cur"))
+	elif [[ $COMP_CWORD -eq 1 ]]; then
+		COMPREPLY=($(compgen -W %q -- "$cur") $(compgen -f -- "$cur"))
+	else
+		COMPREPLY=($(compgen -f -- "$cur"))
+	fi
+}
+complete -o filenames -F _picofeed picofeed
+`, strings.Join(completionShells, " "), strings.Join(config.aliases(), " "), strings.Join(names, " "), strings.Join(subcommands, " "))
+}
+
+func zshCompletion(f io.Writer, flags []*flag.Flag) {
+	escape := strings.NewReplacer("'", "'\\''", "[", "\\[", "]", "\\]", ":", "\\:").Replace
+
+	fmt.Fprintf(f, "#compdef picofeed\n\n_arguments \\\n")
+	values := flagValues()
+	for _, fl := range flags {
+		spec := fmt.Sprintf("--%s[%s]", fl.Name, escape(fl.Usage))
+		if v, ok := values[fl.Name]; ok {
+			spec += fmt.Sprintf(":%s:(%s)", fl.Name, strings.Join(v, " "))
+		} else if fl.Value.Type() != "bool" {
+			spec += fmt.Sprintf(":%s: ", fl.Name)
+		}
+		fmt.Fprintf(f, "\t'%s' \\\n", spec)
+	}
+	fmt.Fprintf(f, "\t'1:command or feed:_alternative \"commands:command:(%s)\" \"files:file:_files\"' \\\n", strings.Join(subcommands, " "))
+	fmt.Fprintf(f, "\t'*:feed:_files'\n")
+}
+
+func fishCompletion(f io.Writer, flags []*flag.Flag) {
+	quote := func(s string) string {
+		return "'" + strings.NewReplacer("\\", "\\\\", "'", "\\'").Replace(s) + "'"
+	}
+
+	fmt.Fprintf(f, "complete -c picofeed -n __fish_use_subcommand -a %s\n", quote(strings.Join(subcommands, " ")))
+	fmt.Fprintf(f, "complete -c picofeed -n '__fish_seen_subcommand_from completion' -x -a %s\n", quote(strings.Join(completionShells, " ")))
+	fmt.Fprintf(f, "complete -c picofeed -n '__fish_seen_subcommand_from remove mute unmute' -a %s\n", quote(strings.Join(config.aliases(), " ")))
+	values := flagValues()
+	for _, fl := range flags {
+		line := fmt.Sprintf("complete -c picofeed -l %s", fl.Name)
+		if v, ok := values[fl.Name]; ok {
+			line += " -x -a " + quote(strings.Join(v, " "))
+		} else if fl.Value.Type() != "bool" {
+			line += " -r"
+		}
+		fmt.Fprintf(f, "%s -d %s\n", line, quote(fl.Usage))
+	}
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Settings from the config file, ~/.config/picofeed/config
+//
+// The file is made of sections of "key = value" lines, blank lines and lines
+// starting with # are ignored. Per-feed overrides go in a section named after
+// the feed's url, or an alias given with url:
+//
+//	[feed https://example.com/feed.xml]
+//	timeout = 30s
+//	user-agent = Mozilla/5.0
+//	header = Cookie: consent=yes
+//	max-items = 20
+//
+//	[feed hn]
+//	url = https://news.ycombinator.com/rss
+//	title = Hacker News
+//	autodiscover = false
+//	mute = true
+//	tags = news, tech
+//
+// Aliases can be used in place of the url in arguments and feeds files.
+//
+// The picker's keys and colors are set in [keys] and [theme] sections:
+//
+//	[keys]
+//	down = j, down
+//	up = k, up
+//	quit = q, esc
+//
+//	[theme]
+//	name = solarized
+//	cursor = bold black on yellow
+//
+// Posts are scored by the rules in a [score] section, see scoreRule:
+//
+//	[score]
+//	+5 title:"go"
+//	-10 feed:"dealsite"
+//	min-score = 0
+//
+// Shortcuts like twitter:user are read through the bridges in a [bridge]
+// section, see bridgeNames.
+type Config struct {
+	Feeds []*FeedConfig
+
+	// Picker action -> the keys replacing its defaults, see pickKeys
+	Keys map[string][]string
+	// Picker theme, one of pickThemes, and colors replacing its own
+	Theme  string
+	Colors map[string]string
+
+	// Rules scoring posts, and the score posts need to be shown if given
+	Scores   []*scoreRule
+	MinScore *int
+
+	// Bridge name -> its base url, and shortcut -> its route
+	Bridges map[string]string
+}
+
+type FeedConfig struct {
+	// Feed url, "" for defaults
+	URL string
+	// Section name, if it isn't the url
+	Alias string
+	// Display name, replacing the feed's own title
+	Title string
+
+	// Fetch timeout, 0 for FETCH_TIMEOUT
+	Timeout time.Duration
+	// Replaces the default User-Agent
+	UserAgent string
+	// Extra request headers
+	Header http.Header
+	// How often serve refetches the feed, 0 for --interval
+	Interval time.Duration
+	// Only read the feed's newest MaxItems, 0 for all, like --max-items-per-feed
+	MaxItems int
+	// Don't look for a feed link when the url isn't a feed
+	NoAutodiscover bool
+	// Skip the feed unless it's asked for with --feed, see mute
+	Muted bool
+	// Tags given to its posts when they're saved, replacing the feed's own
+	Tags []string
+
+	// For TT-RSS sources, the account's password and whether to mark posts
+	// read there once they've been shown
+	Password string
+	MarkRead bool
+}
+
+var config = &Config{}
+
+// Settings for the feed at u, or defaults if it has no section
+func (c *Config) feed(u *url.URL) *FeedConfig {
+	for _, fc := range c.Feeds {
+		if fc.URL == u.String() {
+			return fc
+		}
+	}
+	return &FeedConfig{}
+}
+
+// Url of the feed with alias name, or name parsed as a url
+func (c *Config) resolve(name string) (*url.URL, error) {
+	for _, fc := range c.Feeds {
+		if fc.Alias != "" && fc.Alias == name {
+			return url.Parse(fc.URL)
+		}
+	}
+	return url.Parse(name)
+}
+
+func (c *Config) aliases() []string {
+	aliases := []string{}
+	for _, fc := range c.Feeds {
+		if fc.Alias != "" {
+			aliases = append(aliases, fc.Alias)
+		}
+	}
+	return aliases
+}
+
+func (fc *FeedConfig) timeout() time.Duration {
+	if fc.Timeout > 0 {
+		return fc.Timeout
+	}
+	return FETCH_TIMEOUT
+}
+
+func (fc *FeedConfig) userAgent() string {
+	if fc.UserAgent != "" {
+		return fc.UserAgent
+	}
+	return defaultUserAgent()
+}
+
+// --user-agent, or picofeed/VERSION
+func defaultUserAgent() string {
+	if *userAgent != "" {
+		return *userAgent
+	}
+	return fmt.Sprintf("picofeed/%s", VERSION)
+}
+
+// Read the config file, a missing file is an empty config
+func loadConfig() (*Config, error) {
+	path, err := configPath("config")
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c, err := parseConfig(bufio.NewScanner(f))
+	return c, errors.Wrapf(err, "Failed reading %q", path)
+}
+
+func parseConfig(scanner *bufio.Scanner) (*Config, error) {
+	c := &Config{}
+
+	var fc *FeedConfig
+	// "feed", "keys", "theme", "score" or "bridge"
+	section := ""
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			fields := strings.Fields(line[1 : len(line)-1])
+			if len(fields) == 1 && (fields[0] == "keys" || fields[0] == "theme" || fields[0] == "score" || fields[0] == "bridge") {
+				section = fields[0]
+				continue
+			}
+			if len(fields) != 2 || fields[0] != "feed" {
+				return nil, fmt.Errorf("line %d: unknown section %s", lineNum, line)
+			}
+			section = "feed"
+
+			name := strings.Trim(fields[1], `"`)
+			fc = &FeedConfig{URL: name}
+			if u, err := url.Parse(name); err != nil || u.Scheme == "" {
+				// Not a url, the url must be set in the section
+				fc = &FeedConfig{Alias: name}
+			}
+			c.Feeds = append(c.Feeds, fc)
+			continue
+		}
+
+		// Score rules aren't key = value
+		if section == "score" && (line[0] == '+' || line[0] == '-') {
+			rule, err := parseScoreRule(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNum, err)
+			}
+			c.Scores = append(c.Scores, rule)
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNum)
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		var err error
+		switch section {
+		case "feed":
+			err = fc.set(key, value)
+		case "keys":
+			err = c.setKeys(key, value)
+		case "theme":
+			err = c.setTheme(key, value)
+		case "bridge":
+			err = c.setBridge(key, value)
+		case "score":
+			if key != "min-score" {
+				err = fmt.Errorf("unknown key %q", key)
+				break
+			}
+			var min int
+			min, err = strconv.Atoi(value)
+			c.MinScore = &min
+		default:
+			return nil, fmt.Errorf("line %d: %q is outside of a section", lineNum, line)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, fc := range c.Feeds {
+		if fc.URL == "" {
+			return nil, fmt.Errorf("feed %q has no url", fc.Alias)
+		}
+	}
+	return c, nil
+}
+
+// Put the picker's action on keys, a comma separated list
+func (c *Config) setKeys(action string, value string) error {
+	if !isPickAction(action) {
+		return fmt.Errorf("unknown action %q", action)
+	}
+	keys := []string{}
+	for _, k := range strings.Split(value, ",") {
+		k = strings.TrimSpace(k)
+		if !isKeyName(k) {
+			return fmt.Errorf("unknown key %q for %s", k, action)
+		}
+		keys = append(keys, k)
+	}
+	if c.Keys == nil {
+		c.Keys = map[string][]string{}
+	}
+	c.Keys[action] = keys
+	return nil
+}
+
+// Set the picker's theme by name, or the color of one of its parts
+func (c *Config) setTheme(key string, value string) error {
+	if key == "name" {
+		if _, ok := pickThemes[value]; !ok {
+			return fmt.Errorf("unknown theme %q, expected one of %s", value, strings.Join(pickThemeNames(), ", "))
+		}
+		c.Theme = value
+		return nil
+	}
+	if _, ok := pickThemes["default"][key]; !ok {
+		return fmt.Errorf("unknown key %q", key)
+	}
+	style, err := parseColor(value)
+	if err != nil {
+		return fmt.Errorf("bad %s %q: %v", key, value, err)
+	}
+	if c.Colors == nil {
+		c.Colors = map[string]string{}
+	}
+	c.Colors[key] = style
+	return nil
+}
+
+func (fc *FeedConfig) set(key string, value string) error {
+	var err error
+	switch key {
+	case "url":
+		fc.URL = value
+	case "timeout":
+		fc.Timeout, err = time.ParseDuration(value)
+	case "title":
+		fc.Title = value
+	case "user-agent":
+		fc.UserAgent = value
+	case "header":
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("expected header = Name: value, got %q", value)
+		}
+		if fc.Header == nil {
+			fc.Header = http.Header{}
+		}
+		fc.Header.Add(textproto.TrimString(parts[0]), textproto.TrimString(parts[1]))
+	case "interval":
+		fc.Interval, err = time.ParseDuration(value)
+	case "max-items":
+		fc.MaxItems, err = strconv.Atoi(value)
+	case "autodiscover":
+		var autodiscover bool
+		autodiscover, err = strconv.ParseBool(value)
+		fc.NoAutodiscover = !autodiscover
+	case "mute":
+		fc.Muted, err = strconv.ParseBool(value)
+	case "tags":
+		fc.Tags = []string{}
+		for _, t := range strings.Split(value, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				fc.Tags = append(fc.Tags, t)
+			}
+		}
+	case "password":
+		fc.Password = value
+	case "mark-read":
+		fc.MarkRead, err = strconv.ParseBool(value)
+	default:
+		return fmt.Errorf("unknown key %q", key)
+	}
+	return errors.Wrapf(err, "bad %s %q", key, value)
+}
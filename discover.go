@@ -0,0 +1,474 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mmcdole/gofeed"
+	xhtml "golang.org/x/net/html"
+)
+
+// Where sites commonly put their feed, tried when a page doesn't link to one
+var WELL_KNOWN_FEED_PATHS = []string{"feed", "rss", "atom.xml", "index.xml", "feed.json", "feed.xml", "rss.xml"}
+
+// Link types advertising a feed in a page's <head>
+var feedLinkTypes = map[string]bool{
+	"application/rss+xml":   true,
+	"application/atom+xml":  true,
+	"application/feed+json": true,
+	"application/rdf+xml":   true,
+	"text/xml":              true,
+}
+
+// A feed a page links to
+type feedCandidate struct {
+	URL   *url.URL
+	Title string
+	Type  string
+}
+
+// Feeds linked from an html page with <link rel="alternate"> (or rel="feed"),
+// without repeats, in page order except comments feeds go last. Relative links
+// resolve against the page's <base href> if it has one, otherwise baseUrl.
+func feedLinks(baseUrl *url.URL, contents string) []*feedCandidate {
+	base := baseUrl
+	candidates := []*feedCandidate{}
+	seen := map[string]bool{}
+
+	z := xhtml.NewTokenizer(strings.NewReader(contents))
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			// Comments feeds are rarely the one wanted, and often linked first
+			sort.SliceStable(candidates, func(i, j int) bool {
+				return !candidates[i].comments() && candidates[j].comments()
+			})
+			return candidates
+		}
+		if tt != xhtml.StartTagToken && tt != xhtml.SelfClosingTagToken {
+			continue
+		}
+
+		name, hasAttr := z.TagName()
+		if !hasAttr {
+			continue
+		}
+		attrs := map[string]string{}
+		for {
+			key, val, more := z.TagAttr()
+			attrs[string(key)] = strings.TrimSpace(string(val))
+			if !more {
+				break
+			}
+		}
+
+		switch string(name) {
+		case "base":
+			if u, err := baseUrl.Parse(attrs["href"]); err == nil && attrs["href"] != "" {
+				base = u
+			}
+		case "link":
+			rels := strings.Fields(strings.ToLower(attrs["rel"]))
+			typ := strings.ToLower(strings.TrimSpace(strings.Split(attrs["type"], ";")[0]))
+			isFeed := contains(rels, "feed") || (contains(rels, "alternate") && feedLinkTypes[typ])
+			if !isFeed || attrs["href"] == "" {
+				continue
+			}
+			u, err := base.Parse(attrs["href"])
+			if err != nil || seen[u.String()] {
+				continue
+			}
+			seen[u.String()] = true
+			candidates = append(candidates, &feedCandidate{URL: u, Title: attrs["title"], Type: typ})
+		}
+	}
+}
+
+func (c *feedCandidate) comments() bool {
+	return strings.Contains(strings.ToLower(c.Title+" "+c.URL.String()), "comment")
+}
+
+// The first feed linked from an html page, nil if there isn't one
+func extractFeedLink(baseUrl *url.URL, contents string) *url.URL {
+	candidates := feedLinks(baseUrl, contents)
+	if len(candidates) == 0 {
+		return nil
+	}
+	return candidates[0].URL
+}
+
+// Try WELL_KNOWN_FEED_PATHS under page's directory then the site root, one at a
+// time, returning the first that parses as a feed
+func probeFeedPaths(ctx context.Context, page *url.URL, fc *FeedConfig) (*url.URL, *gofeed.Feed) {
+	dirs := []string{"/"}
+	if dir := path.Dir(page.Path + "x"); dir != "/" && dir != "." {
+		dirs = []string{dir + "/", "/"}
+	}
+
+	for _, dir := range dirs {
+		for _, p := range WELL_KNOWN_FEED_PATHS {
+			if ctx.Err() != nil {
+				return nil, nil
+			}
+			u := &url.URL{Scheme: page.Scheme, User: page.User, Host: page.Host, Path: dir + p}
+			contents, err := fetchUrl(ctx, u, fc)
+			if err != nil {
+				continue
+			}
+			if feed, err := gofeed.NewParser().ParseString(string(contents)); err == nil {
+				return u, feed
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Fetch every candidate page links to, merging their items into one feed
+func fetchDiscoveredFeeds(ctx context.Context, page *url.URL, candidates []*feedCandidate, fc *FeedConfig) (*gofeed.Feed, error) {
+	var merged *gofeed.Feed
+	var lastErr error
+	for _, c := range candidates {
+		fmt.Fprintf(os.Stderr, "Autodiscovering feed %q for %q\n", c.URL, page)
+		feed, err := fetchFeed(ctx, c.URL, fc, 1)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: failed fetching feed %q: %v\n", c.URL, err)
+			lastErr = err
+			continue
+		}
+		if merged == nil {
+			merged = feed
+		} else {
+			merged.Items = append(merged.Items, feed.Items...)
+		}
+	}
+	if merged == nil {
+		return nil, lastErr
+	}
+	return merged, nil
+}
+
+// Feeds to add for u: u itself if it's a feed or a page without feed links,
+// otherwise the feed it links to. Pages linking to several ask which to add on
+// the terminal, or add them all with --all-feeds.
+func chooseFeeds(ctx context.Context, u *url.URL) ([]*url.URL, error) {
+	contents, err := fetchUrl(ctx, u, config.feed(u))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't check %q for feeds, adding it as is: %v\n", u, err)
+		return []*url.URL{u}, nil
+	}
+	if _, err := gofeed.NewParser().ParseString(string(contents)); err == nil {
+		return []*url.URL{u}, nil
+	}
+
+	candidates := feedLinks(u, string(contents))
+	switch {
+	case len(candidates) == 0:
+		return []*url.URL{u}, nil
+	case len(candidates) == 1 || *allFeeds:
+		feeds := []*url.URL{}
+		for _, c := range candidates {
+			feeds = append(feeds, c.URL)
+		}
+		return feeds, nil
+	}
+
+	list := ""
+	for i, c := range candidates {
+		list += fmt.Sprintf("  %d. %s", i+1, c.URL)
+		if c.Title != "" {
+			list += fmt.Sprintf(" (%s)", c.Title)
+		}
+		list += "\n"
+	}
+	answer, err := promptTty(fmt.Sprintf("%q links to %d feeds:\n%sAdd which? [1-%d, a for all] ", u.String(), len(candidates), list, len(candidates)))
+	if err != nil {
+		return nil, fmt.Errorf("%q links to %d feeds, add one directly or all with --all-feeds:\n%s", u.String(), len(candidates), strings.TrimSuffix(list, "\n"))
+	}
+	answer = strings.TrimSpace(answer)
+	if answer == "a" {
+		feeds := []*url.URL{}
+		for _, c := range candidates {
+			feeds = append(feeds, c.URL)
+		}
+		return feeds, nil
+	}
+	n, err := strconv.Atoi(answer)
+	if err != nil || n < 1 || n > len(candidates) {
+		return nil, fmt.Errorf("Expected a number from 1 to %d, or a", len(candidates))
+	}
+	return []*url.URL{candidates[n-1].URL}, nil
+}
+
+// Pages discover fetches at once
+const DISCOVER_CONCURRENCY = 8
+
+// A feed found for a page by discoverFeeds
+type discoveredFeed struct {
+	Page  *url.URL
+	Feed  *url.URL
+	Title string
+}
+
+// Links of an html page to other sites, in page order without repeats, links
+// differing only by a fragment, the host's case or a www. prefix being the same
+func outboundLinks(page *url.URL, contents string) []*url.URL {
+	links := []*url.URL{}
+	seen := map[string]bool{}
+	base := page
+	z := xhtml.NewTokenizer(strings.NewReader(contents))
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			return links
+		}
+		if tt != xhtml.StartTagToken && tt != xhtml.SelfClosingTagToken {
+			continue
+		}
+		name, hasAttr := z.TagName()
+		if !hasAttr || (string(name) != "a" && string(name) != "base") {
+			continue
+		}
+		href := ""
+		for {
+			key, val, more := z.TagAttr()
+			if string(key) == "href" {
+				href = strings.TrimSpace(string(val))
+			}
+			if !more {
+				break
+			}
+		}
+		u, err := base.Parse(href)
+		if href == "" || err != nil {
+			continue
+		}
+		if string(name) == "base" {
+			base = u
+			continue
+		}
+		u.Fragment = ""
+		if (u.Scheme != "http" && u.Scheme != "https") || siteHost(u) == siteHost(page) {
+			continue
+		}
+		key := *u
+		key.Host = strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+		if seen[key.String()] {
+			continue
+		}
+		seen[key.String()] = true
+		links = append(links, u)
+	}
+}
+
+// u's host without case or a www. prefix, which name the same site
+func siteHost(u *url.URL) string {
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// Look for a feed for each of pages, several at a time, returning those found
+// in the order of pages
+func discoverFeeds(ctx context.Context, pages []*url.URL) []*discoveredFeed {
+	found := make([]*discoveredFeed, len(pages))
+	sem := make(chan bool, DISCOVER_CONCURRENCY)
+	var wg sync.WaitGroup
+	for i, page := range pages {
+		wg.Add(1)
+		go func(i int, page *url.URL) {
+			defer wg.Done()
+			sem <- true
+			defer func() { <-sem }()
+
+			fc := config.feed(page)
+			ctx, cancel := context.WithTimeout(ctx, fc.timeout())
+			defer cancel()
+			feedUrl, feed := pageFeed(ctx, page, fc)
+			if feed == nil {
+				fmt.Fprintf(os.Stderr, "No feed for %q\n", page)
+				return
+			}
+			found[i] = &discoveredFeed{Page: page, Feed: feedUrl, Title: strings.TrimSpace(feed.Title)}
+		}(i, page)
+	}
+	wg.Wait()
+
+	kept := []*discoveredFeed{}
+	seen := map[string]bool{}
+	for _, d := range found {
+		if d != nil && !seen[d.Feed.String()] {
+			seen[d.Feed.String()] = true
+			kept = append(kept, d)
+		}
+	}
+	return kept
+}
+
+// The feed of page: page itself if it's a feed, the first feed it links to,
+// or one at a well known path. nil if it has none.
+func pageFeed(ctx context.Context, page *url.URL, fc *FeedConfig) (*url.URL, *gofeed.Feed) {
+	contents, err := fetchUrl(ctx, page, fc)
+	if err != nil {
+		return nil, nil
+	}
+	if feed, err := gofeed.NewParser().ParseString(string(contents)); err == nil {
+		return page, feed
+	}
+	for _, c := range feedLinks(page, string(contents)) {
+		contents, err := fetchUrl(ctx, c.URL, fc)
+		if err != nil {
+			continue
+		}
+		if feed, err := gofeed.NewParser().ParseString(string(contents)); err == nil {
+			return c.URL, feed
+		}
+	}
+	return probeFeedPaths(ctx, page, fc)
+}
+
+// Print the feeds found for the sites page links to, e.g. a blogroll or
+// awesome list, a feed url and its title per line
+func discoverLinkedFeeds(ctx context.Context, f io.Writer, page *url.URL) error {
+	contents, err := fetchUrl(ctx, page, config.feed(page))
+	if err != nil {
+		return err
+	}
+	links := outboundLinks(page, string(contents))
+	if len(links) == 0 {
+		return fmt.Errorf("%q doesn't link to any other sites", page.String())
+	}
+	fmt.Fprintf(os.Stderr, "Looking for feeds on %d sites linked from %q\n", len(links), page)
+	found := discoverFeeds(ctx, links)
+	renderDiscovered(f, found)
+	fmt.Fprintf(os.Stderr, "Found %d feeds\n", len(found))
+	return nil
+}
+
+func renderDiscovered(f io.Writer, found []*discoveredFeed) {
+	for _, d := range found {
+		fmt.Fprintf(f, "%-60s %s\n", d.Feed, d.Title)
+	}
+}
+
+// A link from a browser's bookmarks export
+type bookmark struct {
+	URL *url.URL
+	// Folders it's in, outermost first, joined with " / "
+	Folder string
+}
+
+// Bookmarks in a Netscape bookmarks file, as exported by every browser: links
+// in nested <DL> lists, each list after the <H3> naming its folder
+func parseBookmarks(contents string) []*bookmark {
+	bookmarks := []*bookmark{}
+	folders := []string{}
+	heading := ""
+	inHeading := false
+	z := xhtml.NewTokenizer(strings.NewReader(contents))
+	for {
+		tt := z.Next()
+		switch tt {
+		case xhtml.ErrorToken:
+			return bookmarks
+		case xhtml.TextToken:
+			if inHeading {
+				heading += string(z.Text())
+			}
+		case xhtml.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "h3":
+				inHeading = false
+			case "dl":
+				if len(folders) > 0 {
+					folders = folders[:len(folders)-1]
+				}
+			}
+		case xhtml.StartTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "h3":
+				inHeading = true
+				heading = ""
+			case "dl":
+				// The outermost list is the file itself, not a folder
+				folders = append(folders, strings.TrimSpace(heading))
+				heading = ""
+			case "a":
+				for hasAttr {
+					var key, val []byte
+					key, val, hasAttr = z.TagAttr()
+					if string(key) != "href" {
+						continue
+					}
+					u, err := url.Parse(strings.TrimSpace(string(val)))
+					if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+						break
+					}
+					path := []string{}
+					for _, f := range folders {
+						if f != "" {
+							path = append(path, f)
+						}
+					}
+					bookmarks = append(bookmarks, &bookmark{URL: u, Folder: strings.Join(path, " / ")})
+				}
+			}
+		}
+	}
+}
+
+// Print the feeds found for a bookmarks file's links, under a heading for
+// each folder
+func discoverBookmarkFeeds(ctx context.Context, f io.Writer, path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	bookmarks := parseBookmarks(string(contents))
+	if len(bookmarks) == 0 {
+		return fmt.Errorf("No bookmarks in %q, expected a browser's bookmarks.html export", path)
+	}
+
+	pages := []*url.URL{}
+	folders := map[string]string{}
+	for _, b := range bookmarks {
+		if _, ok := folders[b.URL.String()]; !ok {
+			folders[b.URL.String()] = b.Folder
+			pages = append(pages, b.URL)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Looking for feeds for %d bookmarks\n", len(pages))
+	found := discoverFeeds(ctx, pages)
+
+	byFolder := map[string][]*discoveredFeed{}
+	names := []string{}
+	for _, d := range found {
+		folder := folders[d.Page.String()]
+		if _, ok := byFolder[folder]; !ok {
+			names = append(names, folder)
+		}
+		byFolder[folder] = append(byFolder[folder], d)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if i > 0 {
+			fmt.Fprintf(f, "\n")
+		}
+		heading := name
+		if heading == "" {
+			heading = "Unfiled"
+		}
+		fmt.Fprintf(f, "%s\n", heading)
+		renderDiscovered(f, byFolder[name])
+	}
+	fmt.Fprintf(os.Stderr, "Found %d feeds for %d bookmarks\n", len(found), len(pages))
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Largest DNS response to read from a DoH server
+const DOH_MAX_BYTES = 64 * 1024
+
+// DNS-over-HTTPS server to resolve feed hosts with, set from --doh. The DoH
+// server's own host is resolved normally.
+var dohUrl string
+
+var dohClient = &http.Client{Timeout: FETCH_TIMEOUT}
+
+var dohCache = struct {
+	sync.Mutex
+	hosts map[string]dohAnswer
+}{hosts: map[string]dohAnswer{}}
+
+type dohAnswer struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// Look up host's A and AAAA records, cached for their TTL
+func dohLookup(ctx context.Context, host string) ([]net.IP, error) {
+	dohCache.Lock()
+	answer, ok := dohCache.hosts[host]
+	dohCache.Unlock()
+	if ok && time.Now().Before(answer.expires) {
+		return answer.ips, nil
+	}
+
+	ips := []net.IP{}
+	minTtl := uint32(0)
+	var lastErr error
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		found, ttl, err := dohQuery(ctx, host, qtype)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		ips = append(ips, found...)
+		if len(found) > 0 && (minTtl == 0 || ttl < minTtl) {
+			minTtl = ttl
+		}
+	}
+	if len(ips) == 0 {
+		if lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, fmt.Errorf("No addresses for %q", host)
+	}
+
+	dohCache.Lock()
+	dohCache.hosts[host] = dohAnswer{ips: ips, expires: time.Now().Add(time.Duration(minTtl) * time.Second)}
+	dohCache.Unlock()
+	return ips, nil
+}
+
+// Send a single RFC 8484 query, returning the addresses and their lowest TTL
+func dohQuery(ctx context.Context, host string, qtype dnsmessage.Type) ([]net.IP, uint32, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// ID 0 so responses are cacheable by HTTP caches, as the RFC recommends
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{RecursionDesired: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, 0, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, 0, err
+	}
+	query, err := b.Finish()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req, err := http.NewRequest("POST", dohUrl, bytes.NewReader(query))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := dohClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("Unexpected status code from DoH server: %s", resp.Status)
+	}
+	msg, err := ioutil.ReadAll(io.LimitReader(resp.Body, DOH_MAX_BYTES))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var p dnsmessage.Parser
+	header, err := p.Start(msg)
+	if err != nil {
+		return nil, 0, err
+	}
+	if header.RCode != dnsmessage.RCodeSuccess {
+		return nil, 0, fmt.Errorf("DoH server answered %v", header.RCode)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, 0, err
+	}
+
+	ips := []net.IP{}
+	minTtl := uint32(0)
+	for {
+		h, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		// Skip CNAMEs, resolvers include the records they point to
+		switch h.Type {
+		case dnsmessage.TypeA:
+			r, err := p.AResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			ips = append(ips, net.IP(r.A[:]))
+		case dnsmessage.TypeAAAA:
+			r, err := p.AAAAResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			ips = append(ips, net.IP(r.AAAA[:]))
+		default:
+			if err := p.SkipAnswer(); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		if minTtl == 0 || h.TTL < minTtl {
+			minTtl = h.TTL
+		}
+	}
+	return ips, minTtl, nil
+}
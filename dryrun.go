@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// List the feeds a run would fetch, after feeds files, includes, aliases and
+// --skip, each with its settings from the config as "key = value" lines and
+// where a shortcut is read from. Header values and passwords are left out.
+func renderDryRun(w io.Writer, feeds []*url.URL) {
+	for _, f := range feeds {
+		fc := config.feed(f)
+		if fc.Alias != "" {
+			fmt.Fprintf(w, "%s %s\n", fc.Alias, f)
+		} else {
+			fmt.Fprintf(w, "%s\n", f)
+		}
+		for _, l := range dryRunSettings(f, fc) {
+			fmt.Fprintf(w, "    %s\n", l)
+		}
+	}
+}
+
+func dryRunSettings(f *url.URL, fc *FeedConfig) []string {
+	settings := []string{}
+	set := func(key string, value interface{}) {
+		settings = append(settings, fmt.Sprintf("%s = %v", key, value))
+	}
+
+	if bridged, err := config.bridged(f); err != nil {
+		set("via", "ERROR: "+err.Error())
+	} else if bridged != nil {
+		set("via", bridged)
+	}
+	if fc.Title != "" {
+		set("title", fc.Title)
+	}
+	if fc.Timeout > 0 {
+		set("timeout", fc.Timeout)
+	}
+	if fc.UserAgent != "" {
+		set("user-agent", fc.UserAgent)
+	}
+	names := []string{}
+	for name := range fc.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		set("header", name+": …")
+	}
+	if fc.Interval > 0 {
+		set("interval", fc.Interval)
+	}
+	if fc.MaxItems > 0 {
+		set("max-items", fc.MaxItems)
+	}
+	if fc.NoAutodiscover {
+		set("autodiscover", false)
+	}
+	if fc.Muted {
+		set("mute", true)
+	}
+	if fc.Tags != nil {
+		set("tags", strings.Join(fc.Tags, ", "))
+	}
+	if fc.Password != "" {
+		set("password", "…")
+	}
+	if fc.MarkRead {
+		set("mark-read", true)
+	}
+
+	h := state.health(f.String())
+	if retry, ok := h.retryAt(); ok && time.Now().Before(retry) {
+		skipped := "skipped"
+		if *retryFailing {
+			skipped = "fetched anyway with --retry-failing"
+		}
+		settings = append(settings, fmt.Sprintf("# failed %d runs in a row, %s until %s", h.Failures, skipped, retry.Format("Jan 2 15:04")))
+	}
+	return settings
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// Prefix of environment variables setting flags, so picofeed can be
+// configured in a container without mounting a config:
+//
+//	PICOFEED_LISTEN=:8080 PICOFEED_INTERVAL=30m picofeed serve
+//
+// Also PICOFEED_FEEDS for feed urls or files when none are given, and
+// PICOFEED_DATA_DIR for the config, cache and state, see xdgPath.
+const ENV_PREFIX = "PICOFEED_"
+
+// Environment variable for a flag, e.g. PICOFEED_HOST_CONCURRENCY for
+// --host-concurrency
+func flagEnv(name string) string {
+	return ENV_PREFIX + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// Set flags not given on the command line from their environment variables
+func applyEnvFlags() error {
+	var err error
+	flag.CommandLine.VisitAll(func(fl *flag.Flag) {
+		value, ok := os.LookupEnv(flagEnv(fl.Name))
+		if !ok || fl.Changed || err != nil {
+			return
+		}
+		if setErr := flag.Set(fl.Name, value); setErr != nil {
+			err = fmt.Errorf("Invalid $%s %q: %v", flagEnv(fl.Name), value, setErr)
+		}
+	})
+	return err
+}
+
+// Feed urls and files in $PICOFEED_FEEDS, separated by commas or spaces
+func envFeeds() []string {
+	return strings.FieldsFunc(os.Getenv(ENV_PREFIX+"FEEDS"), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n'
+	})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Icons are inlined into every page, so larger ones are left out
+const FAVICON_MAX_BYTES = 100 * 1024
+
+// Fetch the favicon for each feed host in posts, returning host -> data uri.
+// Icons are cached on disk for FAVICON_MAX_AGE, including hosts that don't
+// have one (as an empty file) so they aren't retried every run.
+func fetchFavicons(ctx context.Context, posts []*Post) map[string]string {
+	ctxTimeout, timeoutCancel := context.WithTimeout(ctx, FETCH_TIMEOUT)
+	defer timeoutCancel()
+
+	hosts := map[string]*url.URL{}
+	for _, p := range posts {
+		u, err := url.Parse(p.FeedLink)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		if scheme := faviconScheme(u); scheme != "" {
+			hosts[u.Host] = &url.URL{Scheme: scheme, Host: u.Host, Path: "/favicon.ico"}
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	icons := map[string]string{}
+	for host, iconUrl := range hosts {
+		wg.Add(1)
+		go func(host string, iconUrl *url.URL) {
+			defer wg.Done()
+
+			data, err := cachedFavicon(ctxTimeout, host, iconUrl)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed fetching favicon for %q: %v\n", host, err)
+				return
+			}
+			if len(data) == 0 {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			icons[host] = fmt.Sprintf("data:%s;base64,%s", http.DetectContentType(data), base64.StdEncoding.EncodeToString(data))
+		}(host, iconUrl)
+	}
+	wg.Wait()
+
+	return icons
+}
+
+// Scheme the feed's host serves its favicon over, "" for sources that aren't
+// websites, like gemini or imaps
+func faviconScheme(u *url.URL) string {
+	switch u.Scheme {
+	case "http", "https":
+		return u.Scheme
+	case "webcal":
+		return "https"
+	case "ttrss+http", "ttrss+https":
+		return strings.TrimPrefix(u.Scheme, "ttrss+")
+	}
+	return ""
+}
+
+func cachedFavicon(ctx context.Context, host string, iconUrl *url.URL) ([]byte, error) {
+	path, err := cachePath("favicons", host)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err == nil && (*offline || time.Since(info.ModTime()) < FAVICON_MAX_AGE) {
+		return ioutil.ReadFile(path)
+	}
+	if *offline {
+		return nil, nil
+	}
+
+	data, err := fetchFavicon(ctx, iconUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return data, ioutil.WriteFile(path, data, 0644)
+}
+
+// Fetch favicon, returning no data if the host doesn't have one
+func fetchFavicon(ctx context.Context, iconUrl *url.URL) ([]byte, error) {
+	req, _ := http.NewRequest("GET", iconUrl.String(), nil)
+	req.Header.Set("User-Agent", defaultUserAgent())
+	req = req.WithContext(ctx)
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, FAVICON_MAX_BYTES+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > FAVICON_MAX_BYTES {
+		return nil, nil
+	}
+	if !strings.HasPrefix(http.DetectContentType(data), "image/") {
+		// Some hosts serve an html page for any path
+		return nil, nil
+	}
+	return data, nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Append feed to the default feeds file, or the feeds it links to if it's a
+// page, giving it an alias and title in the config if set
+func addFeed(ctx context.Context, feed string, alias string, title string) error {
+	u, err := url.Parse(feed)
+	if dir, ok := localDirUrl(feed); ok {
+		u, err = dir, nil
+	}
+	if err != nil || u.Scheme == "" {
+		return fmt.Errorf("%q is not a url", feed)
+	}
+	feeds := []*url.URL{u}
+	if u.Scheme == "http" || u.Scheme == "https" {
+		feeds, err = chooseFeeds(ctx, u)
+		if err != nil {
+			return err
+		}
+	}
+	if len(feeds) > 1 && (alias != "" || title != "") {
+		return errors.New("--alias and --title can only be given when adding one feed")
+	}
+	for _, f := range feeds {
+		if f.String() != feed {
+			fmt.Fprintf(os.Stderr, "Found feed %q for %q\n", f, feed)
+		}
+		if err := addFeedUrl(f.String(), alias, title); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addFeedUrl(feed string, alias string, title string) error {
+	if alias != "" {
+		resolved, err := config.resolve(alias)
+		if err != nil {
+			return fmt.Errorf("Invalid alias %q: %v", alias, err)
+		}
+		if resolved.String() != alias {
+			return fmt.Errorf("Alias %q is already used for %q", alias, resolved)
+		}
+	}
+
+	path, err := configPath("feeds")
+	if err != nil {
+		return err
+	}
+	lines, err := readLines(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, l := range lines {
+		if l == feed {
+			return fmt.Errorf("%q is already in %q", feed, path)
+		}
+	}
+
+	// Backed up before either file changes, so a failed backup changes nothing
+	if err := backupFeeds(); err != nil {
+		return fmt.Errorf("Failed backing up %q: %v", path, err)
+	}
+	if alias != "" || title != "" {
+		section := []string{"", fmt.Sprintf("[feed %s]", feed)}
+		if alias != "" {
+			section = []string{"", fmt.Sprintf("[feed %s]", alias), "url = " + feed}
+		}
+		if title != "" {
+			section = append(section, "title = "+title)
+		}
+		configFile, err := configPath("config")
+		if err != nil {
+			return err
+		}
+		if err := appendLines(configFile, section); err != nil {
+			return err
+		}
+	}
+
+	if err := appendLines(path, []string{feed}); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Added %q to %q\n", feed, path)
+	return nil
+}
+
+// Remove feed, by url or alias, from the default feeds file and its settings
+// from the config
+func removeFeed(feed string) error {
+	u, err := config.resolve(feed)
+	if err != nil {
+		return err
+	}
+
+	path, err := configPath("feeds")
+	if err != nil {
+		return err
+	}
+	lines, err := readLines(path)
+	if err != nil {
+		return err
+	}
+
+	kept := []string{}
+	for _, l := range lines {
+		if l != feed && l != u.String() {
+			kept = append(kept, l)
+		}
+	}
+	if len(kept) == len(lines) {
+		return fmt.Errorf("%q is not in %q", feed, path)
+	}
+	if err := backupFeeds(); err != nil {
+		return fmt.Errorf("Failed backing up %q: %v", path, err)
+	}
+	if err := writeLines(path, kept); err != nil {
+		return err
+	}
+
+	// Settings are either under the url or an alias for it
+	fc := config.feed(u)
+	names := []string{u.String()}
+	if fc.Alias != "" {
+		names = append(names, fc.Alias)
+	}
+	if err := removeConfigSections(names); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Removed %q from %q\n", u, path)
+	return nil
+}
+
+// Replace page urls in the feeds files among args with the feeds discovered for
+// them, keeping anything else on each line as is
+func saveDiscoveredFeeds(args []string) error {
+	for _, path := range args {
+		if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		lines, err := readLines(path)
+		if err != nil {
+			return err
+		}
+
+		replaced := 0
+		for i, l := range lines {
+			if discovered, ok := state.discovered(strings.TrimSpace(l)); ok {
+				lines[i] = strings.Replace(l, strings.TrimSpace(l), discovered.String(), 1)
+				replaced++
+			}
+		}
+		if replaced == 0 {
+			continue
+		}
+		if err := writeLines(path, lines); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Replaced %d page urls with their feeds in %q\n", replaced, path)
+	}
+	return nil
+}
+
+// Drop [feed <name>] sections from the config file
+func removeConfigSections(names []string) error {
+	path, err := configPath("config")
+	if err != nil {
+		return err
+	}
+	lines, err := readLines(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	kept := []string{}
+	skipping := false
+	for _, l := range lines {
+		if name, ok := configSection(l); ok {
+			skipping = contains(names, name)
+		}
+		if !skipping {
+			kept = append(kept, l)
+		}
+	}
+	if len(kept) == len(lines) {
+		return nil
+	}
+	return writeLines(path, kept)
+}
+
+// Set mute in the config section of feed, by url or alias, adding a section if
+// it doesn't have one
+func muteFeed(feed string, mute bool) error {
+	u, err := config.resolve(feed)
+	if err != nil || u.Scheme == "" {
+		return fmt.Errorf("%q is not a url or alias", feed)
+	}
+	fc := config.feed(u)
+	if fc.Muted == mute {
+		if mute {
+			return fmt.Errorf("%q is already muted", feed)
+		}
+		return fmt.Errorf("%q isn't muted", feed)
+	}
+	name := u.String()
+	if fc.Alias != "" {
+		name = fc.Alias
+	}
+
+	path, err := configPath("config")
+	if err != nil {
+		return err
+	}
+	lines, err := readLines(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	kept := []string{}
+	found := false
+	inSection := false
+	for _, l := range lines {
+		if section, ok := configSection(l); ok {
+			inSection = section == name || section == u.String()
+			kept = append(kept, l)
+			if inSection && mute && !found {
+				kept = append(kept, "mute = true")
+			}
+			found = found || inSection
+			continue
+		}
+		// Drop any existing setting, the new one was added after the header
+		if parts := strings.SplitN(l, "=", 2); inSection && len(parts) == 2 && strings.TrimSpace(parts[0]) == "mute" {
+			continue
+		}
+		kept = append(kept, l)
+	}
+	if mute && !found {
+		kept = append(kept, "", fmt.Sprintf("[feed %s]", name), "mute = true")
+	}
+	if err := writeLines(path, kept); err != nil {
+		return err
+	}
+
+	if mute {
+		fmt.Fprintf(os.Stderr, "Muted %q\n", feed)
+	} else {
+		fmt.Fprintf(os.Stderr, "Unmuted %q\n", feed)
+	}
+	return nil
+}
+
+// Name of the [feed <name>] section started by line, if it starts one
+func configSection(line string) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "[") || !strings.HasSuffix(trimmed, "]") {
+		return "", false
+	}
+	fields := strings.Fields(trimmed[1 : len(trimmed)-1])
+	if len(fields) != 2 || fields[0] != "feed" {
+		return "", true
+	}
+	return strings.Trim(fields[1], `"`), true
+}
+
+// Lines of a file, without a trailing empty line
+func readLines(path string) ([]string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := strings.TrimSuffix(string(contents), "\n")
+	if s == "" {
+		return []string{}, nil
+	}
+	return strings.Split(s, "\n"), nil
+}
+
+func writeLines(path string, lines []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	contents := strings.Join(lines, "\n")
+	if len(lines) > 0 {
+		contents += "\n"
+	}
+	return ioutil.WriteFile(path, []byte(contents), 0644)
+}
+
+// Append lines to the file, creating it if needed
+func appendLines(path string, lines []string) error {
+	existing, err := readLines(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return writeLines(path, append(existing, lines...))
+}
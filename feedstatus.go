@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	gohtml "html"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Posts per week are counted over this long
+const FEED_RATE_WINDOW = 30 * 24 * time.Hour
+
+// How a served feed is doing, for /feeds
+type feedStatus struct {
+	Name string
+	URL  string
+	// "ok", "failing", "skipped" while failing feeds wait to be retried, or
+	// "pending" before its first fetch
+	Status    string
+	Failures  int
+	LastError string
+	// Zero if it's never been fetched
+	LastSuccess time.Time
+	NextPoll    time.Time
+	PerWeek     float64
+}
+
+func (s *server) feedStatuses() []*feedStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	statuses := []*feedStatus{}
+	for _, f := range s.feeds {
+		fc := config.feed(f)
+		fs := &feedStatus{Name: f.Host, URL: f.String(), Status: "pending", NextPoll: s.nextPoll[f.String()]}
+		if fc.Title != "" {
+			fs.Name = fc.Title
+		} else if fc.Alias != "" {
+			fs.Name = fc.Alias
+		}
+
+		posts := s.feedPosts[f.String()]
+		recent := 0
+		for _, p := range posts {
+			if now.Sub(*p.Timestamp) < FEED_RATE_WINDOW {
+				recent++
+			}
+		}
+		fs.PerWeek = float64(recent) * float64(7*24*time.Hour) / float64(FEED_RATE_WINDOW)
+		if len(posts) > 0 && fc.Title == "" {
+			fs.Name = posts[0].feedName()
+		}
+
+		if h := state.health(f.String()); h != nil {
+			fs.Failures = h.Failures
+			fs.LastError = h.LastError
+			fs.LastSuccess = h.LastSuccess
+			switch retry, ok := h.retryAt(); {
+			case ok && now.Before(retry):
+				fs.Status = "skipped"
+			case h.Failures > 0:
+				fs.Status = "failing"
+			default:
+				fs.Status = "ok"
+			}
+		}
+		statuses = append(statuses, fs)
+	}
+	return statuses
+}
+
+// Each feed's status, last successful fetch, error streak, posting rate and
+// next poll
+func (s *server) handleFeeds(w http.ResponseWriter, r *http.Request) {
+	statuses := s.feedStatuses()
+
+	s.mu.Lock()
+	opts := s.opts
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	renderFeedsHtml(w, statuses, opts)
+}
+
+func renderFeedsHtml(f io.Writer, statuses []*feedStatus, opts htmlOptions) {
+	fmt.Fprintf(f, `<!DOCTYPE html>
+<head>
+<title>Picofeed feeds</title>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<style>
+%s
+body {
+	margin: 0 auto;
+	padding: 2em 1em;
+	max-width: 1000px;
+	color: var(--fg);
+	background: var(--bg);
+	font-family: -apple-system,system-ui,BlinkMacSystemFont,"Segoe UI",Roboto,"Helvetica Neue",Arial,sans-serif;
+	font-size: 14px;
+}
+h4, a {color: var(--strong);}
+table {border-collapse: collapse; width: 100%%;}
+th {text-align: left; font-weight: normal; color: var(--strong);}
+td, th {padding: 0.3em 1em 0.3em 0; vertical-align: top;}
+.failing, .skipped {color: #c33;}
+.error {font-size: 0.9em;}
+</style>
+%s</head>
+<body>
+<h4><a href="/">Picofeed</a> feeds</h4>
+<table>
+<tr><th>Feed</th><th>Status</th><th>Last fetched</th><th>Errors</th><th>Posts/week</th><th>Next poll</th></tr>
+`, themeCss(opts.Theme), userCss(opts))
+
+	for _, fs := range statuses {
+		lastSuccess := "never"
+		if !fs.LastSuccess.IsZero() {
+			lastSuccess = fs.LastSuccess.Format("Jan 2 15:04")
+		}
+		nextPoll := ""
+		if !fs.NextPoll.IsZero() {
+			nextPoll = fs.NextPoll.Format("Jan 2 15:04")
+		}
+		errors := ""
+		if fs.Failures > 0 {
+			errors = fmt.Sprintf("%d in a row<div class=\"error\">%s</div>", fs.Failures, gohtml.EscapeString(fs.LastError))
+		}
+		fmt.Fprintf(f, "<tr><td><a href=\"%s\">%s</a></td><td class=\"%s\">%s</td><td>%s</td><td>%s</td><td>%.1f</td><td>%s</td></tr>\n",
+			gohtml.EscapeString(safeUrl(fs.URL, true)), gohtml.EscapeString(fs.Name), fs.Status, fs.Status, lastSuccess, errors, fs.PerWeek, nextPoll)
+	}
+	fmt.Fprintf(f, "</table>\n</body>\n")
+}
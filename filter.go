@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Drop posts excluded by the filter flags, and repeats of the same post (e.g.
+// from a feed included twice, or the same article in two feeds). With
+// --collapse-similar near duplicates are collapsed too, see collapseSimilar.
+func filterPosts(posts []*Post) []*Post {
+	posts = filterUnseenPosts(posts, map[string]bool{})
+	if *collapse {
+		posts = collapseSimilar(posts)
+	}
+	return posts
+}
+
+// filterPosts, also dropping posts in seen (by id or link), which is updated
+// with the posts kept so it can be reused across calls
+func filterUnseenPosts(posts []*Post, seen map[string]bool) []*Post {
+	filtered := []*Post{}
+	for _, p := range posts {
+		if keepPost(p) && !seen[p.id()] && (p.Link == "" || !seen[p.Link]) {
+			seen[p.id()] = true
+			if p.Link != "" {
+				seen[p.Link] = true
+			}
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered
+}
+
+// Drop feeds given to --skip, and muted feeds unless they're asked for with
+// --feed
+func skipFeeds(feeds []*url.URL) []*url.URL {
+	kept := []*url.URL{}
+	for _, f := range feeds {
+		names := []string{f.String(), f.Host}
+		fc := config.feed(f)
+		if fc.Alias != "" {
+			names = append(names, fc.Alias)
+		}
+		if containsAny(*skip, names) || (fc.Muted && !containsAny(*feedFilter, names)) {
+			continue
+		}
+		kept = append(kept, f)
+	}
+	return kept
+}
+
+func containsAny(list []string, items []string) bool {
+	for _, item := range items {
+		if contains(list, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compiled --where, nil if not given
+var where *whereExpr
+
+// Parsed --since, nil to show posts however old
+var cutoff *sinceCutoff
+
+// Posts published before a date, or more than an age ago, are hidden. Feeds
+// publishing their whole archive would otherwise bury recent posts.
+type sinceCutoff struct {
+	date time.Time
+	age  time.Duration
+}
+
+// Parse --since, an age like 90d or a date like 2024-01-01. "all" and "0" are
+// no cutoff.
+func parseSince(s string) (*sinceCutoff, error) {
+	if s == "" || s == "all" || s == "0" {
+		return nil, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return &sinceCutoff{date: t}, nil
+	}
+	age, err := parseDays(s)
+	if err != nil || age <= 0 {
+		return nil, fmt.Errorf("Invalid --since %q, expected an age like 90d or a date like 2024-01-01", s)
+	}
+	return &sinceCutoff{age: age}, nil
+}
+
+// The oldest a post can be published, worked out when it's asked for so it
+// moves along while serve runs
+func (c *sinceCutoff) time() time.Time {
+	if c.age > 0 {
+		return time.Now().Add(-c.age)
+	}
+	return c.date
+}
+
+func keepPost(p *Post) bool {
+	if cutoff != nil && p.Timestamp.Before(cutoff.time()) {
+		return false
+	}
+	if len(*feedFilter) > 0 && !matchesFeed(p, *feedFilter) {
+		return false
+	}
+	if len(*langs) > 0 && p.Lang != "" && !contains(*langs, p.Lang) {
+		return false
+	}
+	if len(*authors) > 0 && p.Author != "" && !matchesAuthor(p, *authors) {
+		return false
+	}
+	if matchesAuthor(p, *skipAuthor) {
+		return false
+	}
+	if len(*categories) > 0 && len(p.Categories) > 0 && !inCategory(p, *categories) {
+		return false
+	}
+	if inCategory(p, *skipCat) {
+		return false
+	}
+	if where != nil && !where.match(p) {
+		return false
+	}
+	if min, ok := minPostScore(); ok && p.score() < min {
+		return false
+	}
+
+	// Reading time filters only apply when there's content to measure
+	if p.Words > 0 {
+		if *minWords > 0 && p.Words < *minWords {
+			return false
+		}
+		if *maxMinutes > 0 && p.ReadingMinutes > *maxMinutes {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Whether p is from one of feeds, given by alias, host or url
+func matchesFeed(p *Post, feeds []string) bool {
+	for _, f := range feeds {
+		if f == p.FeedAlias || f == p.shortFeedLink() || f == p.FeedLink {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether p's author's name has one of authors in it, ignoring case
+func matchesAuthor(p *Post, authors []string) bool {
+	if p.Author == "" {
+		return false
+	}
+	name := strings.ToLower(p.Author)
+	for _, a := range authors {
+		if a != "" && strings.Contains(name, strings.ToLower(a)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether p is in one of categories, ignoring case
+func inCategory(p *Post, categories []string) bool {
+	for _, c := range p.Categories {
+		for _, want := range categories {
+			if strings.EqualFold(c, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
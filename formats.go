@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	gohtml "html"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Render posts as an org-mode outline: a heading per date, with a TODO entry
+// per post that can be refiled into an agenda
+func renderOrg(f io.Writer, posts []*Post, dateFormat string) {
+	grouped := groupByDate(posts, dateFormat)
+
+	for _, group := range grouped {
+		for i, p := range group {
+			if i == 0 {
+				fmt.Fprintf(f, "* %s\n", groupHeading(p, dateFormat))
+			}
+			fmt.Fprintf(f, "** TODO [[%s][%s]]\n", p.Link, orgEscape(p.displayTitle()))
+			fmt.Fprintf(f, "   :PROPERTIES:\n")
+			fmt.Fprintf(f, "   :URL:       %s\n", p.Link)
+			fmt.Fprintf(f, "   :FEED:      %s\n", orgEscape(p.feedName()))
+			fmt.Fprintf(f, "   :FEED_URL:  %s\n", p.FeedLink)
+			fmt.Fprintf(f, "   :PUBLISHED: [%s]\n", p.Timestamp.Format("2006-01-02 Mon 15:04"))
+			if p.UpdatedAt != nil {
+				fmt.Fprintf(f, "   :UPDATED:   [%s]\n", p.UpdatedAt.Format("2006-01-02 Mon 15:04"))
+			}
+			fmt.Fprintf(f, "   :END:\n")
+		}
+	}
+}
+
+// Titles go inside [[link][title]] on a single line
+func orgEscape(s string) string {
+	return strings.NewReplacer("[", "{", "]", "}", "\n", " ").Replace(s)
+}
+
+// Render posts as gemtext, a heading per date and a link line per post
+func renderGemtext(f io.Writer, posts []*Post, dateFormat string) {
+	fmt.Fprintf(f, "# Picofeed\n")
+
+	grouped := groupByDate(posts, dateFormat)
+
+	for _, group := range grouped {
+		for i, p := range group {
+			if i == 0 {
+				fmt.Fprintf(f, "\n## %s\n", groupHeading(p, dateFormat))
+			}
+			title := strings.Replace(p.displayTitle(), "\n", " ", -1)
+			fmt.Fprintf(f, "=> %s %s (%s)\n", p.Link, title, p.shortFeedName())
+		}
+	}
+}
+
+const ICS_TIME = "20060102T150405Z"
+
+// Render posts as an iCalendar file with a VEVENT per post. Events run from the
+// post's event metadata if the feed has it, otherwise at the published time.
+func renderIcs(f io.Writer, posts []*Post) {
+	now := time.Now().UTC().Format(ICS_TIME)
+
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		fmt.Sprintf("PRODID:-//picofeed//picofeed %s//EN", VERSION),
+	}
+	for _, p := range posts {
+		sum := sha1.Sum([]byte(p.Link))
+		start := p.Timestamp
+		if p.EventStart != nil {
+			start = p.EventStart
+		}
+
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			fmt.Sprintf("UID:%s@picofeed", hex.EncodeToString(sum[:])),
+			"DTSTAMP:"+now,
+			"DTSTART:"+start.UTC().Format(ICS_TIME),
+		)
+		if p.EventEnd != nil {
+			lines = append(lines, "DTEND:"+p.EventEnd.UTC().Format(ICS_TIME))
+		}
+		if p.UpdatedAt != nil {
+			lines = append(lines, "LAST-MODIFIED:"+p.UpdatedAt.UTC().Format(ICS_TIME))
+		}
+		lines = append(lines,
+			"SUMMARY:"+icsEscape(p.Title),
+			"URL:"+p.Link,
+			"DESCRIPTION:"+icsEscape(p.feedName()+"\n"+p.Link),
+		)
+		if p.EventLocation != "" {
+			lines = append(lines, "LOCATION:"+icsEscape(p.EventLocation))
+		}
+		lines = append(lines, "END:VEVENT")
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	for _, l := range lines {
+		fmt.Fprintf(f, "%s\r\n", icsFold(l))
+	}
+}
+
+func icsEscape(s string) string {
+	return strings.NewReplacer("\\", "\\\\", ";", "\\;", ",", "\\,", "\n", "\\n").Replace(s)
+}
+
+// Lines longer than 75 octets are continued on the next line after a space,
+// without splitting utf-8 sequences
+func icsFold(line string) string {
+	folded := ""
+	// Continuation lines lose an octet to the leading space
+	max := 75
+	for len(line) > max {
+		cut := max
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		folded += line[:cut] + "\r\n "
+		line = line[cut:]
+		max = 74
+	}
+	return folded + line
+}
+
+// Write each feed's posts as JSON lines as soon as it's fetched, rather than
+// once every feed is
+func streamJsonl(ctx context.Context, f io.Writer, feeds []*url.URL) {
+	enc := json.NewEncoder(f)
+	seen := map[string]bool{}
+	fetchEach(ctx, feeds, func(posts []*Post) {
+		fixLinks(ctx, posts)
+		posts = filterUnseenPosts(transformPosts(posts), seen)
+		hooks.onPosts(state.markSeen(posts))
+		for _, p := range posts {
+			_ = enc.Encode(p)
+		}
+	})
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Links     []atomLink  `xml:"link"`
+	Author    atomAuthor  `xml:"author"`
+	Content   atomContent `xml:"content"`
+	// The feed the post came from
+	Source atomSource `xml:"source"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomSource struct {
+	Title string     `xml:"title"`
+	ID    string     `xml:"id"`
+	Links []atomLink `xml:"link"`
+}
+
+// The post's author, or its feed's name if it has none
+func (p *Post) authorName() string {
+	if p.Author != "" {
+		return p.Author
+	}
+	return p.feedName()
+}
+
+// Urn unique to the post, which Atom requires ids to be
+func (p *Post) urn() string {
+	sum := sha1.Sum([]byte(p.id()))
+	return "urn:sha1:" + hex.EncodeToString(sum[:])
+}
+
+// When the post was last changed, for feeds that want one
+func (p *Post) modified() *time.Time {
+	if p.UpdatedAt != nil {
+		return p.UpdatedAt
+	}
+	return p.Timestamp
+}
+
+// Render posts as one Atom feed, served at selfUrl, with a source per entry
+// naming the feed it came from
+func renderAtom(f io.Writer, posts []*Post, selfUrl string) {
+	feed := atomFeed{
+		Title:   "Picofeed",
+		ID:      selfUrl,
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Links:   []atomLink{{Rel: "self", Href: selfUrl}},
+		Entries: []atomEntry{},
+	}
+	// The html page served alongside it
+	if u, err := url.Parse(selfUrl); err == nil {
+		feed.Links = append(feed.Links, atomLink{Rel: "alternate", Href: u.ResolveReference(&url.URL{Path: "/"}).String()})
+	}
+	for _, p := range posts {
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:     p.displayTitle(),
+			ID:        p.urn(),
+			Published: p.Timestamp.UTC().Format(time.RFC3339),
+			Updated:   p.modified().UTC().Format(time.RFC3339),
+			Links:     []atomLink{{Rel: "alternate", Href: p.Link}},
+			Author:    atomAuthor{Name: p.authorName()},
+			Content:   atomContent{Type: "html", Body: p.Content},
+			Source: atomSource{
+				Title: p.feedName(),
+				ID:    p.FeedLink,
+				Links: []atomLink{{Rel: "self", Href: p.FeedLink}},
+			},
+		})
+	}
+
+	fmt.Fprintf(f, "%s", xml.Header)
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "  ")
+	_ = enc.Encode(feed)
+	fmt.Fprintf(f, "\n")
+}
+
+type jsonFeed struct {
+	Version string          `json:"version"`
+	Title   string          `json:"title"`
+	FeedUrl string          `json:"feed_url"`
+	Items   []*jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	Url           string           `json:"url"`
+	Title         string           `json:"title"`
+	ContentHtml   string           `json:"content_html"`
+	DatePublished string           `json:"date_published"`
+	DateModified  string           `json:"date_modified,omitempty"`
+	Authors       []jsonFeedAuthor `json:"authors"`
+	Image         string           `json:"image,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+	Url  string `json:"url,omitempty"`
+}
+
+// Render posts as one JSON Feed (https://jsonfeed.org/version/1.1), served at
+// selfUrl, with each post's author, or its feed if it has none
+func renderJsonFeed(f io.Writer, posts []*Post, selfUrl string) {
+	feed := jsonFeed{
+		Version: "https://jsonfeed.org/version/1.1",
+		Title:   "Picofeed",
+		FeedUrl: selfUrl,
+		Items:   []*jsonFeedItem{},
+	}
+	for _, p := range posts {
+		item := &jsonFeedItem{
+			ID:            p.urn(),
+			Url:           p.Link,
+			Title:         p.displayTitle(),
+			ContentHtml:   p.Content,
+			DatePublished: p.Timestamp.UTC().Format(time.RFC3339),
+			Authors:       []jsonFeedAuthor{{Name: p.authorName(), Url: p.FeedLink}},
+			Image:         p.Thumbnail,
+		}
+		if p.UpdatedAt != nil {
+			item.DateModified = p.UpdatedAt.UTC().Format(time.RFC3339)
+		}
+		feed.Items = append(feed.Items, item)
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(feed)
+}
+
+// Characters of plain text kept in a post's summary
+const SUMMARY_LENGTH = 280
+
+// The start of the post's content as plain text, cut at a word
+func (p *Post) summary() string {
+	text := strings.Join(strings.Fields(gohtml.UnescapeString(tagRegex.ReplaceAllString(p.Content, " "))), " ")
+	if utf8.RuneCountInString(text) <= SUMMARY_LENGTH {
+		return text
+	}
+	text = truncate(text, SUMMARY_LENGTH)
+	if i := strings.LastIndex(text, " "); i > 0 {
+		text = text[:i]
+	}
+	return text + "…"
+}
+
+// Render posts as a YAML data file for Hugo's data/ or Jekyll's _data/: the
+// posts newest first, and each feed with its latest post for blogrolls
+func renderHugoData(f io.Writer, posts []*Post) {
+	sort.Sort(ByTimestamp{posts})
+	fmt.Fprintf(f, "generated: %s\n", time.Now().UTC().Format(time.RFC3339))
+
+	fmt.Fprintf(f, "feeds:\n")
+	latest := map[string]bool{}
+	for _, p := range posts {
+		if latest[p.FeedLink] {
+			continue
+		}
+		latest[p.FeedLink] = true
+		fmt.Fprintf(f, "  - title: %s\n", yamlQuote(p.feedName()))
+		fmt.Fprintf(f, "    url: %s\n", yamlQuote(p.FeedLink))
+		if p.FeedAlias != "" {
+			fmt.Fprintf(f, "    alias: %s\n", yamlQuote(p.FeedAlias))
+		}
+		fmt.Fprintf(f, "    latest:\n")
+		writeHugoPost(f, "      ", p)
+	}
+	if len(latest) == 0 {
+		fmt.Fprintf(f, "  []\n")
+	}
+
+	fmt.Fprintf(f, "posts:\n")
+	for _, p := range posts {
+		fmt.Fprintf(f, "  -\n")
+		writeHugoPost(f, "    ", p)
+	}
+	if len(posts) == 0 {
+		fmt.Fprintf(f, "  []\n")
+	}
+}
+
+func writeHugoPost(f io.Writer, indent string, p *Post) {
+	fmt.Fprintf(f, "%stitle: %s\n", indent, yamlQuote(p.Title))
+	fmt.Fprintf(f, "%slink: %s\n", indent, yamlQuote(p.Link))
+	fmt.Fprintf(f, "%sdate: %s\n", indent, p.Timestamp.Format(time.RFC3339))
+	fmt.Fprintf(f, "%sfeed: %s\n", indent, yamlQuote(p.feedName()))
+	fmt.Fprintf(f, "%sfeed_url: %s\n", indent, yamlQuote(p.FeedLink))
+	fmt.Fprintf(f, "%ssummary: %s\n", indent, yamlQuote(p.summary()))
+	fmt.Fprintf(f, "%stags: %s\n", indent, yamlQuote(append([]string{}, p.Tags...)))
+	if p.ReadingMinutes > 0 {
+		fmt.Fprintf(f, "%sreading_minutes: %d\n", indent, p.ReadingMinutes)
+	}
+	if p.Thumbnail != "" {
+		fmt.Fprintf(f, "%simage: %s\n", indent, yamlQuote(p.Thumbnail))
+	}
+}
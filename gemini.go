@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/pkg/errors"
+)
+
+const GEMINI_TIMEOUT = 10 * time.Second
+const GEMINI_MAX_REDIRECTS = 5
+
+// Guards the known_hosts file, feeds are fetched in parallel
+var knownHostsMu sync.Mutex
+
+var gemfeedRegex = regexp.MustCompile(`^=>\s*(\S+)\s+(\d{4}-\d{2}-\d{2})\s*[-:]?\s*(.*)$`)
+
+// Fetch a gemini feed, either an atom feed or a gemtext page of links in the
+// gemfeed format (=> url YYYY-MM-DD title)
+func fetchGeminiFeed(ctx context.Context, feedUrl *url.URL) (*gofeed.Feed, error) {
+	u := feedUrl
+	for i := 0; i <= GEMINI_MAX_REDIRECTS; i++ {
+		status, meta, body, err := geminiRequest(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+
+		switch status[0] {
+		case '2':
+			if strings.HasPrefix(meta, "text/gemini") {
+				return parseGemfeed(u, string(body)), nil
+			}
+			return gofeed.NewParser().ParseString(string(body))
+		case '3':
+			next, err := url.Parse(meta)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Bad redirect %q", meta)
+			}
+			u = u.ResolveReference(next)
+		default:
+			return nil, fmt.Errorf("Unexpected status: %s %s", status, meta)
+		}
+	}
+	return nil, errors.New("Too many redirects")
+}
+
+// Send a gemini request, returning the status, meta and body of the response
+func geminiRequest(ctx context.Context, u *url.URL) (string, string, []byte, error) {
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "1965")
+	}
+
+	ctxTimeout, timeoutCancel := context.WithTimeout(ctx, GEMINI_TIMEOUT)
+	rawConn, err := dialContext(ctxTimeout, "tcp", host)
+	timeoutCancel()
+	if err != nil {
+		return "", "", nil, err
+	}
+	defer rawConn.Close()
+
+	conn := tls.Client(rawConn, &tls.Config{
+		ServerName: u.Hostname(),
+		MinVersion: tls.VersionTLS12,
+		// Gemini servers are mostly self-signed, verifyTofu checks instead
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			return verifyTofu(host, state.PeerCertificates[0])
+		},
+	})
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if _, err := fmt.Fprintf(conn, "%s\r\n", u.String()); err != nil {
+		return "", "", nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return "", "", nil, errors.Wrap(err, "Failed reading response header")
+	}
+	parts := strings.SplitN(strings.TrimRight(header, "\r\n"), " ", 2)
+	if len(parts[0]) != 2 {
+		return "", "", nil, fmt.Errorf("Malformed response header %q", header)
+	}
+	meta := ""
+	if len(parts) > 1 {
+		meta = parts[1]
+	}
+
+	body, err := ioutil.ReadAll(bandwidth.reader(ctx, r))
+	if err != nil {
+		return "", "", nil, errors.Wrap(err, "Failed reading response body")
+	}
+	return parts[0], meta, body, nil
+}
+
+// Trust on first use: remember each host's certificate fingerprint, and reject
+// a different certificate unless the remembered one has expired
+func verifyTofu(host string, cert *x509.Certificate) error {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	path, err := statePath("gemini", "known_hosts")
+	if err != nil {
+		return err
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	sum := sha256.Sum256(cert.Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+
+	lines := []string{}
+	for _, l := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(l)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[0] != host {
+			lines = append(lines, l)
+			continue
+		}
+
+		if fields[1] == fingerprint {
+			return nil
+		}
+		expiry, _ := strconv.ParseInt(fields[2], 10, 64)
+		if time.Now().Unix() < expiry {
+			return fmt.Errorf("Certificate for %q changed, remove it from %q if this is expected", host, path)
+		}
+		// Known certificate expired, trust the new one
+	}
+
+	lines = append(lines, fmt.Sprintf("%s %s %d", host, fingerprint, cert.NotAfter.Unix()))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+func parseGemfeed(feedUrl *url.URL, contents string) *gofeed.Feed {
+	feed := &gofeed.Feed{}
+	for _, l := range strings.Split(contents, "\n") {
+		l = strings.TrimRight(l, "\r")
+		if feed.Title == "" && strings.HasPrefix(l, "# ") {
+			feed.Title = strings.TrimSpace(l[2:])
+			continue
+		}
+
+		matches := gemfeedRegex.FindStringSubmatch(l)
+		if matches == nil {
+			continue
+		}
+		link, err := url.Parse(matches[1])
+		if err != nil {
+			continue
+		}
+		t, err := time.Parse("2006-01-02", matches[2])
+		if err != nil {
+			continue
+		}
+		title := matches[3]
+		if title == "" {
+			title = matches[2]
+		}
+
+		feed.Items = append(feed.Items, &gofeed.Item{
+			Title:           title,
+			Link:            feedUrl.ResolveReference(link).String(),
+			PublishedParsed: &t,
+		})
+	}
+	return feed
+}
+
+// Listen for gemini requests. Gemini clients trust on first use, so a
+// self-signed certificate is generated once and kept in the config directory.
+func listenGemini(addr string) (net.Listener, error) {
+	cert, err := geminiCertificate()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed loading gemini certificate")
+	}
+
+	return tls.Listen("tcp", addr, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	})
+}
+
+// Serve the gemtext render of posts on l until ctx is done
+func (s *server) serveGemini(ctx context.Context, l net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.handleGemini(conn)
+	}
+}
+
+func (s *server) handleGemini(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(GEMINI_TIMEOUT))
+
+	// Requests are a single url of at most 1024 bytes, terminated by CRLF
+	line, err := bufio.NewReaderSize(conn, 1026).ReadString('\n')
+	if err != nil {
+		return
+	}
+	u, err := url.Parse(strings.TrimRight(line, "\r\n"))
+	if err != nil || len(line) > 1026 {
+		fmt.Fprintf(conn, "59 Bad request\r\n")
+		return
+	}
+	if u.Path != "" && u.Path != "/" {
+		fmt.Fprintf(conn, "51 Not found\r\n")
+		return
+	}
+
+	s.mu.Lock()
+	var buf bytes.Buffer
+	renderGemtext(&buf, append([]*Post{}, s.posts...), "Jan 2006")
+	s.mu.Unlock()
+
+	fmt.Fprintf(conn, "20 text/gemini; charset=utf-8\r\n")
+	_, _ = buf.WriteTo(conn)
+}
+
+func geminiCertificate() (tls.Certificate, error) {
+	certPath, err := configPath("gemini", "cert.pem")
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPath, err := configPath("gemini", "key.pem")
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	if _, err := os.Stat(certPath); os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Generating gemini certificate %q\n", certPath)
+		if err := generateCertificate(certPath, keyPath); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+
+	return tls.LoadX509KeyPair(certPath, keyPath)
+}
+
+func generateCertificate(certPath string, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	host, _ := os.Hostname()
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host, "localhost"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(certPath), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/pkg/errors"
+)
+
+// Commits (or tags) read per fetch, and how deep the clone is
+const GIT_MAX_COMMITS = 50
+
+// Fields and records of git's --format output
+const GIT_FIELD = "\x1f"
+const GIT_RECORD = "\x1e"
+
+// Git repositories' recent commits on a branch (the default one if not given),
+// or their tags with #tags:
+//
+//	git:https://github.com/golang/go.git
+//	git:https://git.sr.ht/~sircmpwn/aerc#master
+//	git:https://codeberg.org/forgejo/forgejo.git#tags
+//
+// They're kept as shallow clones of only the commits (no files) in the cache,
+// with the git command. Commits link to their page on the forge hosting them.
+func isGit(u *url.URL) bool {
+	return u.Scheme == "git"
+}
+
+func fetchGitFeed(ctx context.Context, feedUrl *url.URL, fc *FeedConfig) (*gofeed.Feed, error) {
+	repo, err := url.Parse(feedUrl.Opaque)
+	if err != nil || repo.Scheme == "" {
+		return nil, fmt.Errorf("Expected a repository url like git:https://example.com/repo.git, got %q", feedUrl.String())
+	}
+	branch := feedUrl.Fragment
+	tags := branch == "tags"
+
+	// A clone per branch, as cloning one only fetches that branch
+	sum := sha1.Sum([]byte(feedUrl.String()))
+	dir, err := cachePath("git", hex.EncodeToString(sum[:8]))
+	if err != nil {
+		return nil, err
+	}
+	if err := updateGitClone(ctx, repo, dir, branch, fc); err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	if tags {
+		out, err = gitCommand(ctx, dir, "for-each-ref", "--sort=-creatordate", fmt.Sprintf("--count=%d", GIT_MAX_COMMITS),
+			"--format=%(refname:short)"+GIT_FIELD+"%(objectname)"+GIT_FIELD+"%(creatordate:iso-strict)"+GIT_FIELD+"%(taggername)%(authorname)"+GIT_FIELD+"%(contents)"+GIT_RECORD,
+			"refs/tags")
+	} else {
+		ref := "HEAD"
+		if branch != "" {
+			ref = "refs/heads/" + branch
+		}
+		out, err = gitCommand(ctx, dir, "log", "-n", fmt.Sprint(GIT_MAX_COMMITS),
+			"--format=%s"+GIT_FIELD+"%H"+GIT_FIELD+"%aI"+GIT_FIELD+"%an"+GIT_FIELD+"%b"+GIT_RECORD, ref)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	web := forgeUrl(repo)
+	name := strings.TrimSuffix(repo.Path[strings.LastIndex(repo.Path, "/")+1:], ".git")
+	if tags {
+		name += " tags"
+	} else if branch != "" {
+		name += " " + branch
+	}
+	feed := &gofeed.Feed{Title: name, Link: web, Items: []*gofeed.Item{}}
+	for _, record := range strings.Split(string(out), GIT_RECORD) {
+		fields := strings.Split(strings.TrimLeft(record, "\n"), GIT_FIELD)
+		if len(fields) != 5 {
+			continue
+		}
+		title, hash, date, author, body := fields[0], fields[1], fields[2], fields[3], strings.TrimSpace(fields[4])
+		t, err := time.Parse(time.RFC3339, date)
+		if err != nil {
+			continue
+		}
+		link := forgeLink(repo, "commit", hash)
+		if tags {
+			link = forgeLink(repo, "tag", title)
+		}
+		feed.Items = append(feed.Items, &gofeed.Item{
+			Title:           title,
+			Link:            link,
+			GUID:            hash,
+			Content:         markdownParagraphs(body),
+			PublishedParsed: &t,
+			Author:          &gofeed.Person{Name: author},
+		})
+	}
+	return feed, nil
+}
+
+// Clone the repository into dir if it isn't yet, otherwise fetch its new
+// commits. Nothing's fetched with --offline.
+func updateGitClone(ctx context.Context, repo *url.URL, dir string, branch string, fc *FeedConfig) error {
+	_, err := os.Stat(dir)
+	cloned := err == nil
+	if *offline {
+		if !cloned {
+			return errors.New("Not cloned, and --offline")
+		}
+		return nil
+	}
+	release, err := limiter.acquire(ctx, repo.Host)
+	if err != nil {
+		return err
+	}
+	defer release()
+	ctx, cancel := context.WithTimeout(ctx, fc.timeout())
+	defer cancel()
+
+	depth := fmt.Sprintf("--depth=%d", GIT_MAX_COMMITS)
+	if !cloned {
+		args := []string{"clone", "--quiet", "--bare", "--filter=tree:0", depth}
+		if branch != "" && branch != "tags" {
+			args = append(args, "--branch", branch)
+		}
+		if _, err := gitCommand(ctx, "", append(args, repo.String(), dir)...); err != nil {
+			os.RemoveAll(dir)
+			return err
+		}
+	}
+
+	switch branch {
+	case "tags":
+		_, err = gitCommand(ctx, dir, "fetch", "--quiet", "--depth=1", "origin", "+refs/tags/*:refs/tags/*")
+	case "":
+		if !cloned {
+			return nil
+		}
+		var head []byte
+		head, err = gitCommand(ctx, dir, "symbolic-ref", "HEAD")
+		if err == nil {
+			ref := strings.TrimSpace(string(head))
+			_, err = gitCommand(ctx, dir, "fetch", "--quiet", depth, "origin", "+"+ref+":"+ref)
+		}
+	default:
+		if !cloned {
+			return nil
+		}
+		ref := "refs/heads/" + branch
+		_, err = gitCommand(ctx, dir, "fetch", "--quiet", depth, "origin", "+"+ref+":"+ref)
+	}
+	return err
+}
+
+// Run git in dir, returning its output or its error message
+func gitCommand(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	verb := args[0]
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	// Never ask for credentials, private repositories aren't supported
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("git %s failed: %s", verb, msg)
+	}
+	return out, nil
+}
+
+// Paths of commit and tag pages on forges, by host
+var forgePaths = []struct {
+	match  func(host string) bool
+	commit string
+	tag    string
+}{
+	{func(h string) bool { return h == "github.com" }, "/commit/", "/releases/tag/"},
+	{func(h string) bool { return strings.Contains(h, "gitlab") }, "/-/commit/", "/-/tags/"},
+	{func(h string) bool { return h == "bitbucket.org" }, "/commits/", "/src/"},
+	{func(h string) bool { return strings.HasPrefix(h, "git.sr.ht") }, "/commit/", "/refs/"},
+	// Gitea and Forgejo
+	{func(h string) bool {
+		return h == "codeberg.org" || strings.Contains(h, "gitea") || strings.Contains(h, "forgejo")
+	}, "/commit/", "/releases/tag/"},
+}
+
+// The repository's web page, its url without .git
+func forgeUrl(repo *url.URL) string {
+	web := *repo
+	web.User = nil
+	if web.Scheme != "https" && web.Scheme != "http" && web.Host != "" {
+		web.Scheme = "https"
+	}
+	web.Path = strings.TrimSuffix(strings.TrimSuffix(web.Path, "/"), ".git")
+	return web.String()
+}
+
+// The page of a commit or tag on the repository's forge, or the repository's
+// page with the commit or tag as its fragment if the forge isn't known
+func forgeLink(repo *url.URL, kind string, name string) string {
+	web := forgeUrl(repo)
+	for _, f := range forgePaths {
+		if !f.match(repo.Hostname()) {
+			continue
+		}
+		if kind == "tag" {
+			return web + f.tag + url.PathEscape(name)
+		}
+		return web + f.commit + name
+	}
+	return web + "#" + url.PathEscape(name)
+}
@@ -1,12 +1,27 @@
 module picofeed
 
+go 1.23.0
+
 require (
-	github.com/PuerkitoBio/goquery v1.5.0 // indirect
+	github.com/andybalholm/brotli v1.0.4
+	github.com/klauspost/compress v1.17.11
 	github.com/mmcdole/gofeed v1.0.0-beta2.0.20181010182736-eb870fd61fb8
-	github.com/mmcdole/goxpp v0.0.0-20181012175147-0068e33feabf // indirect
 	github.com/pkg/browser v0.0.0-20180916011732-0a3d74bf9ce4
 	github.com/pkg/errors v0.8.0
 	github.com/spf13/pflag v1.0.3
-	golang.org/x/net v0.0.0-20181201002055-351d144fa1fc // indirect
-	golang.org/x/text v0.3.0 // indirect
+	github.com/tetratelabs/wazero v1.10.1
+	github.com/yuin/gopher-lua v1.1.1
+	golang.org/x/crypto v0.31.0
+	golang.org/x/net v0.21.0
+)
+
+require (
+	github.com/PuerkitoBio/goquery v1.5.0 // indirect
+	github.com/andybalholm/cascadia v1.0.0 // indirect
+	github.com/chzyer/logex v1.1.10 // indirect
+	github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e // indirect
+	github.com/chzyer/test v0.0.0-20180213035817-a1ea475d72b1 // indirect
+	github.com/mmcdole/goxpp v0.0.0-20181012175147-0068e33feabf // indirect
+	golang.org/x/sys v0.28.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
 )
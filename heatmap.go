@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	gohtml "html"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const HEATMAP_WEEKS = 53
+
+// Cells from no posts to the busiest day
+var heatmapBlocks = []string{"·", "░", "▒", "▓", "█"}
+
+// Posts per day over the last HEATMAP_WEEKS, in columns of weeks starting on
+// Sunday like GitHub's contribution graph
+type heatmap struct {
+	title string
+	// First day of the first column
+	start time.Time
+	today time.Time
+	days  map[string]int
+	total int
+}
+
+func newHeatmap(title string, posts []*Post, now time.Time) *heatmap {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	start := today.AddDate(0, 0, -int(today.Weekday())-7*(HEATMAP_WEEKS-1))
+	h := &heatmap{title: title, start: start, today: today, days: map[string]int{}}
+	for _, p := range posts {
+		t := p.Timestamp.In(now.Location())
+		if t.Before(start) || t.After(now) {
+			continue
+		}
+		h.days[t.Format("2006-01-02")]++
+		h.total++
+	}
+	return h
+}
+
+// Heatmap of all posts, or one per feed sorted busiest first so dormant feeds
+// end up at the bottom
+func heatmaps(posts []*Post, perFeed bool, now time.Time) []*heatmap {
+	if !perFeed {
+		return []*heatmap{newHeatmap("All feeds", posts, now)}
+	}
+
+	feeds := map[string][]*Post{}
+	for _, p := range posts {
+		feeds[p.FeedLink] = append(feeds[p.FeedLink], p)
+	}
+	maps := []*heatmap{}
+	for _, feedPosts := range feeds {
+		maps = append(maps, newHeatmap(feedPosts[0].feedName(), feedPosts, now))
+	}
+	sort.Slice(maps, func(i, j int) bool {
+		if maps[i].total != maps[j].total {
+			return maps[i].total > maps[j].total
+		}
+		return maps[i].title < maps[j].title
+	})
+	return maps
+}
+
+func (h *heatmap) max() int {
+	max := 0
+	for _, n := range h.days {
+		if n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+// 0 for no posts, up to len(heatmapBlocks)-1 for the busiest day
+func (h *heatmap) level(n int, max int) int {
+	if n == 0 {
+		return 0
+	}
+	levels := len(heatmapBlocks) - 1
+	return (n*levels + max - 1) / max
+}
+
+// Date of the cell in week column and weekday row, false if it's after today
+func (h *heatmap) day(week int, weekday int) (time.Time, bool) {
+	d := h.start.AddDate(0, 0, week*7+weekday)
+	return d, !d.After(h.today)
+}
+
+type heatmapMonth struct {
+	label string
+	weeks int
+}
+
+// Columns grouped by the month their week starts in
+func (h *heatmap) months() []heatmapMonth {
+	months := []heatmapMonth{}
+	for week := 0; week < HEATMAP_WEEKS; week++ {
+		d, _ := h.day(week, 0)
+		if week == 0 || d.Day() <= 7 {
+			months = append(months, heatmapMonth{label: d.Format("Jan")})
+		}
+		months[len(months)-1].weeks++
+	}
+	return months
+}
+
+// Month names above the column each month starts in
+func (h *heatmap) monthLabels() string {
+	labels := ""
+	for _, m := range h.months() {
+		label := ""
+		if m.weeks >= 4 {
+			label = m.label
+		}
+		labels += fmt.Sprintf("%-*s", m.weeks, label)
+	}
+	return strings.TrimRight(labels, " ")
+}
+
+func renderHeatmaps(f io.Writer, maps []*heatmap) {
+	for i, h := range maps {
+		if i > 0 {
+			fmt.Fprintf(f, "\n")
+		}
+		fmt.Fprintf(f, "%s, %d posts in the last year\n", h.title, h.total)
+		fmt.Fprintf(f, "    %s\n", h.monthLabels())
+
+		max := h.max()
+		for weekday := 0; weekday < 7; weekday++ {
+			label := ""
+			if weekday%2 == 1 {
+				label = time.Weekday(weekday).String()[:3]
+			}
+			fmt.Fprintf(f, "%-4s", label)
+			for week := 0; week < HEATMAP_WEEKS; week++ {
+				d, ok := h.day(week, weekday)
+				if !ok {
+					break
+				}
+				fmt.Fprint(f, heatmapBlocks[h.level(h.days[d.Format("2006-01-02")], max)])
+			}
+			fmt.Fprintf(f, "\n")
+		}
+	}
+	fmt.Fprintf(f, "\n    Less %s More\n", strings.Join(heatmapBlocks, ""))
+}
+
+func renderHeatmapsHtml(f io.Writer, maps []*heatmap, opts htmlOptions) {
+	fmt.Fprintf(f, `<!DOCTYPE html>
+<head>
+<title>Picofeed heatmap</title>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<style>
+%s
+body {
+	margin: 0 auto;
+	padding: 2em 1em;
+	max-width: 800px;
+	color: var(--fg);
+	background: var(--bg);
+	font-family: -apple-system,system-ui,BlinkMacSystemFont,"Segoe UI",Roboto,"Helvetica Neue",Arial,sans-serif;
+	font-size: 14px;
+}
+h4 {color: var(--strong); margin-bottom: 0.5em;}
+.heatmap {border-spacing: 2px; font-size: 10px;}
+.heatmap td {width: 10px; height: 10px; padding: 0; border-radius: 2px; background: var(--strong);}
+.heatmap th {font-weight: normal; text-align: left; padding-right: 4px;}
+.heatmap td.l0 {background: var(--fg); opacity: 0.15;}
+.heatmap td.l1 {opacity: 0.3;}
+.heatmap td.l2 {opacity: 0.55;}
+.heatmap td.l3 {opacity: 0.8;}
+.heatmap td.empty {background: none;}
+</style>
+%s</head>
+<body>
+`, themeCss(opts.Theme), userCss(opts))
+
+	for _, h := range maps {
+		fmt.Fprintf(f, "<h4>%s</h4>\n<div>%d posts in the last year</div>\n", gohtml.EscapeString(h.title), h.total)
+		fmt.Fprintf(f, "<table class=\"heatmap\">\n<tr><th></th>")
+		for _, m := range h.months() {
+			label := ""
+			// Too narrow for the name, e.g. the partial month at the start
+			if m.weeks >= 3 {
+				label = m.label
+			}
+			fmt.Fprintf(f, "<th colspan=\"%d\">%s</th>", m.weeks, label)
+		}
+		fmt.Fprintf(f, "</tr>\n")
+
+		max := h.max()
+		for weekday := 0; weekday < 7; weekday++ {
+			label := ""
+			if weekday%2 == 1 {
+				label = time.Weekday(weekday).String()[:3]
+			}
+			fmt.Fprintf(f, "<tr><th>%s</th>", label)
+			for week := 0; week < HEATMAP_WEEKS; week++ {
+				d, ok := h.day(week, weekday)
+				if !ok {
+					fmt.Fprintf(f, "<td class=\"empty\"></td>")
+					continue
+				}
+				n := h.days[d.Format("2006-01-02")]
+				fmt.Fprintf(f, "<td class=\"l%d\" title=\"%s: %d posts\"></td>", h.level(n, max), d.Format("Jan 2 2006"), n)
+			}
+			fmt.Fprintf(f, "</tr>\n")
+		}
+		fmt.Fprintf(f, "</table>\n")
+	}
+	fmt.Fprintf(f, "</body>\n")
+}
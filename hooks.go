@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Lua hooks from ~/.config/picofeed/hooks.lua, any of which can be defined:
+//
+//	-- Rewrite a post by returning it changed, or drop it by returning nil
+//	function transform_post(post) return post end
+//	-- Called for each new post, not shown on a previous run, e.g. to send notifications
+//	function on_post(post) end
+//	function on_feed_error(url, err) end
+//
+// Posts are tables of title, link, content, guid, timestamp (unix seconds),
+// words, updated, feed_link, feed_title and feed_alias.
+type luaHooks struct {
+	// LStates aren't safe for concurrent use
+	mu sync.Mutex
+	L  *lua.LState
+}
+
+// nil if there isn't a hooks file
+var hooks *luaHooks
+
+func loadHooks() (*luaHooks, error) {
+	path, err := configPath("hooks.lua")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	L := lua.NewState()
+	if err := L.DoFile(path); err != nil {
+		L.Close()
+		return nil, fmt.Errorf("Failed loading %q: %v", path, err)
+	}
+	return &luaHooks{L: L}, nil
+}
+
+// Close the Lua state, once nothing calls the hooks any more
+func (h *luaHooks) close() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.L.Close()
+}
+
+// Global function name, nil if the hooks file doesn't define it
+func (h *luaHooks) hook(name string) *lua.LFunction {
+	fn, ok := h.L.GetGlobal(name).(*lua.LFunction)
+	if !ok {
+		return nil
+	}
+	return fn
+}
+
+func (h *luaHooks) call(fn *lua.LFunction, args ...lua.LValue) (lua.LValue, error) {
+	if err := h.L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}, args...); err != nil {
+		return nil, err
+	}
+	ret := h.L.Get(-1)
+	h.L.Pop(1)
+	return ret, nil
+}
+
+// Run the Lua hooks then the WASM plugins over posts, returning those they keep
+func transformPosts(posts []*Post) []*Post {
+	return plugins.transformPosts(hooks.transformPosts(posts))
+}
+
+// Run transform_post over posts, returning those it keeps. Posts are kept
+// unchanged if it fails.
+func (h *luaHooks) transformPosts(posts []*Post) []*Post {
+	if h == nil {
+		return posts
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fn := h.hook("transform_post")
+	if fn == nil {
+		return posts
+	}
+	kept := []*Post{}
+	for _, p := range posts {
+		ret, err := h.call(fn, h.postTable(p))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: transform_post failed for %q: %v\n", p.Link, err)
+			kept = append(kept, p)
+			continue
+		}
+		if t, ok := ret.(*lua.LTable); ok {
+			h.updatePost(p, t)
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+func (h *luaHooks) onPosts(posts []*Post) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fn := h.hook("on_post")
+	if fn == nil {
+		return
+	}
+	for _, p := range posts {
+		if _, err := h.call(fn, h.postTable(p)); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: on_post failed for %q: %v\n", p.Link, err)
+		}
+	}
+}
+
+func (h *luaHooks) onFeedError(feed string, feedErr error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if fn := h.hook("on_feed_error"); fn != nil {
+		if _, err := h.call(fn, lua.LString(feed), lua.LString(feedErr.Error())); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: on_feed_error failed for %q: %v\n", feed, err)
+		}
+	}
+}
+
+func (h *luaHooks) postTable(p *Post) *lua.LTable {
+	t := h.L.NewTable()
+	t.RawSetString("title", lua.LString(p.Title))
+	t.RawSetString("link", lua.LString(p.Link))
+	t.RawSetString("content", lua.LString(p.Content))
+	t.RawSetString("guid", lua.LString(p.GUID))
+	t.RawSetString("timestamp", lua.LNumber(p.Timestamp.Unix()))
+	t.RawSetString("words", lua.LNumber(p.Words))
+	t.RawSetString("updated", lua.LBool(p.Updated))
+	t.RawSetString("feed_link", lua.LString(p.FeedLink))
+	t.RawSetString("feed_title", lua.LString(p.FeedTitle))
+	t.RawSetString("feed_alias", lua.LString(p.FeedAlias))
+	return t
+}
+
+// Copy back the fields a hook may change
+func (h *luaHooks) updatePost(p *Post, t *lua.LTable) {
+	p.Title = lua.LVAsString(t.RawGetString("title"))
+	p.Link = lua.LVAsString(t.RawGetString("link"))
+	p.FeedTitle = lua.LVAsString(t.RawGetString("feed_title"))
+	p.FeedAlias = lua.LVAsString(t.RawGetString("feed_alias"))
+	if content := lua.LVAsString(t.RawGetString("content")); content != p.Content {
+		p.Content = content
+		p.countWords()
+	}
+	if ts, ok := t.RawGetString("timestamp").(lua.LNumber); ok && int64(ts) != p.Timestamp.Unix() {
+		t := time.Unix(int64(ts), 0)
+		p.Timestamp = &t
+	}
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"fmt"
+	gohtml "html"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+type htmlOptions struct {
+	// Posts per page, 0 to render all at once
+	PageSize int
+	// Feed host -> favicon data uri
+	Favicons map[string]string
+	// Post link -> OpenGraph preview, nil if cards are disabled
+	Cards map[string]*Card
+	// Show each post's Thumbnail
+	Thumbnails bool
+	// Post link -> --summarize summary
+	Summaries map[string]string
+	// One of themes, or "auto" to follow prefers-color-scheme
+	Theme string
+	// User css inlined after the default styles
+	Css string
+	// User stylesheet url linked after the default styles
+	CssLink string
+	// Subscribe to /events for new posts, see serve
+	Live bool
+	// Feeds without posts since StaleSince, listed after the posts with --stale
+	Stale      []*staleFeed
+	StaleSince time.Time
+	// Posts (a feed's folded posts counting as one) shown in each date group
+	// before the rest are behind "show more", 0 to show them all
+	GroupSize int
+	// Posts shown from a feed in each date group before the rest of its posts
+	// are folded, 0 to never fold
+	FoldFeed int
+}
+
+// A post, or several of a feed's posts folded into one, in a date group
+type htmlEntry struct {
+	post   *Post
+	folded []*Post
+}
+
+// Fold each feed's posts after its first FoldFeed in the group into one
+// entry, after the last one shown
+func groupEntries(group []*Post, foldFeed int) []*htmlEntry {
+	entries := []*htmlEntry{}
+	counts := map[string]int{}
+	// Feeds named per item (e.g. TT-RSS) share a FeedLink
+	feed := func(p *Post) string {
+		return p.FeedLink + "\n" + p.FeedTitle
+	}
+	for _, p := range group {
+		counts[feed(p)]++
+	}
+	shown := map[string]int{}
+	folds := map[string]*htmlEntry{}
+	for _, p := range group {
+		host := feed(p)
+		if foldFeed <= 0 || counts[host] <= foldFeed || shown[host] < foldFeed {
+			entries = append(entries, &htmlEntry{post: p})
+			shown[host]++
+			if shown[host] == foldFeed && counts[host] > foldFeed {
+				folds[host] = &htmlEntry{}
+				entries = append(entries, folds[host])
+			}
+			continue
+		}
+		folds[host].folded = append(folds[host].folded, p)
+	}
+	return entries
+}
+
+// Colors for each html theme, as css variables
+var themes = map[string]string{
+	"light":          "--bg: #fff; --fg: #888; --strong: #000; --visited: #888;",
+	"dark":           "--bg: #1b1b1b; --fg: #888; --strong: #ddd; --visited: #777;",
+	"solarized":      "--bg: #fdf6e3; --fg: #93a1a1; --strong: #073642; --visited: #839496;",
+	"solarized-dark": "--bg: #002b36; --fg: #657b83; --strong: #eee8d5; --visited: #586e75;",
+}
+
+// When served, link the web app manifest so the page can be installed to a
+// phone's home screen, and the merged feeds so readers can subscribe to it
+func appHead(opts htmlOptions) string {
+	if !opts.Live {
+		return ""
+	}
+	return `<link rel="manifest" href="manifest.json">
+<link rel="icon" href="icon.svg" type="image/svg+xml">
+<link rel="apple-touch-icon" href="icon.svg">
+<meta name="apple-mobile-web-app-capable" content="yes">
+<meta name="theme-color" content="#000">
+<link rel="alternate" type="application/atom+xml" title="Picofeed" href="feed.atom">
+<link rel="alternate" type="application/feed+json" title="Picofeed" href="feed.json">
+`
+}
+
+func userCss(opts htmlOptions) string {
+	css := ""
+	if opts.Css != "" {
+		css += fmt.Sprintf("<style>\n%s\n</style>\n", strings.TrimSpace(opts.Css))
+	}
+	if opts.CssLink != "" {
+		css += fmt.Sprintf("<link rel=\"stylesheet\" href=\"%s\">\n", gohtml.EscapeString(opts.CssLink))
+	}
+	return css
+}
+
+// Css to set the theme's colors. "auto" and "solarized" switch to their dark
+// variant when the browser prefers a dark color scheme.
+func themeCss(theme string) string {
+	dark := ""
+	switch theme {
+	case "auto":
+		theme, dark = "light", "dark"
+	case "solarized":
+		dark = "solarized-dark"
+	}
+
+	css := fmt.Sprintf(":root {%s}", themes[theme])
+	if dark != "" {
+		css += fmt.Sprintf("\n@media (prefers-color-scheme: dark) {\n\t:root {%s}\n}", themes[dark])
+	}
+	return css
+}
+
+// Render posts as a single html document. If PageSize > 0, only the first page
+// is displayed, the rest are kept in inert <template>s which are appended on
+// "Load more" so the browser doesn't have to lay out every post up front.
+func renderHtml(f io.Writer, posts []*Post, dateFormat string, opts htmlOptions) {
+	pageSize := opts.PageSize
+
+	fmt.Fprintf(f, `<!DOCTYPE html>
+<head>
+<title>Picofeed</title>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+%s<style>
+%s
+body {
+	margin: 0 auto;
+	padding: 2em 0px;
+	max-width: 800px;
+	color: var(--fg);
+	background: var(--bg);
+	font-family: -apple-system,system-ui,BlinkMacSystemFont,"Segoe UI",Roboto,"Helvetica Neue",Arial,sans-serif;
+	font-size: 14px;
+	line-height: 1.4em;
+}
+h4   {color: var(--strong);}
+a {color: var(--strong);}
+a:visited {color: var(--visited);}
+#filters {margin-bottom: 2em;}
+#filters label {margin-right: 1em; white-space: nowrap;}
+#filter {display: block; width: 100%%; margin-bottom: 0.5em; box-sizing: border-box;}
+#more {margin-top: 2em;}
+.selected {margin-left: -1em; padding-left: calc(1em - 2px); border-left: 2px solid var(--strong);}
+.favicon {width: 16px; height: 16px; margin-right: 4px; vertical-align: text-bottom;}
+.thumbnail {width: 48px; height: 48px; margin: 0.2em 0.5em 0.2em 0; object-fit: cover; vertical-align: middle;}
+.updated {font-style: italic;}
+.card {display: flex; margin: 0.5em 0 1.5em; gap: 1em; color: var(--fg);}
+.card img {width: 160px; max-height: 120px; object-fit: cover;}
+.card p {margin: 0;}
+.alternates {margin-left: 1em; font-size: 0.9em;}
+.stale {color: var(--fg);}
+.summary {margin: 0.25em 0 1em; color: var(--fg); font-size: 0.9em;}
+details summary {cursor: pointer; margin: 0.25em 0; color: var(--fg);}
+.fold {margin-left: 1em;}
+@media (max-width: 840px) {
+	body {padding: 1em; font-size: 16px; line-height: 1.5em;}
+	.post {padding: 0.3em 0;}
+	.card {flex-direction: column; gap: 0.5em;}
+	.card img {width: 100%%; max-height: 200px;}
+}
+</style>
+%s</head>
+<body>
+<h4 style="padding-bottom: 2em">Picofeed</h4>
+`, appHead(opts), themeCss(opts.Theme), userCss(opts))
+
+	renderFilters(f, posts)
+	fmt.Fprintf(f, "<div id=\"posts\">\n")
+
+	grouped := groupByDate(posts, dateFormat)
+
+	// Pages only break between entries, so a fold is never split
+	n, pages := 0, 1
+	for _, group := range grouped {
+		entries := groupEntries(group, opts.FoldFeed)
+		for i, e := range entries {
+			if pageSize > 0 && n >= pages*pageSize {
+				// Start a new page
+				if pages == 1 {
+					fmt.Fprintf(f, "</div>\n")
+				} else {
+					fmt.Fprintf(f, "</template>\n")
+				}
+				fmt.Fprintf(f, "<template class=\"page\">\n")
+				pages++
+			}
+			if i == 0 {
+				fmt.Fprintf(f, "<h4>%s</h4>\n", groupHeading(e.post, dateFormat))
+			}
+			if opts.GroupSize > 0 && i == opts.GroupSize {
+				rest := 0
+				for _, e := range entries[i:] {
+					rest += max(len(e.folded), 1)
+				}
+				fmt.Fprintf(f, "<details class=\"more\"><summary>Show %d more</summary>\n", rest)
+				for _, e := range entries[i:] {
+					n += renderHtmlEntry(f, e, opts)
+				}
+				fmt.Fprintf(f, "</details>\n")
+				break
+			}
+			n += renderHtmlEntry(f, e, opts)
+		}
+	}
+
+	if pages > 1 {
+		fmt.Fprintf(f, "</template>\n<button id=\"more\" onclick=\"loadMore()\">Load more</button>\n")
+	} else {
+		fmt.Fprintf(f, "</div>\n")
+	}
+	renderStaleHtml(f, opts.Stale, opts.StaleSince)
+
+	fmt.Fprintf(f, "<script>\n%s", htmlScript)
+	if opts.Live {
+		fmt.Fprintf(f, "%s", liveScript)
+	}
+	fmt.Fprintf(f, "</script>\n</body>\n</html>\n")
+}
+
+// Render a post or a feed's folded posts, returning how many posts it was
+func renderHtmlEntry(f io.Writer, e *htmlEntry, opts htmlOptions) int {
+	if e.post != nil {
+		renderHtmlPost(f, e.post, opts)
+		return 1
+	}
+	fmt.Fprintf(f, "<details class=\"fold\"><summary>%d more from %s</summary>\n", len(e.folded), gohtml.EscapeString(e.folded[0].shortFeedName()))
+	for _, p := range e.folded {
+		renderHtmlPost(f, p, opts)
+	}
+	fmt.Fprintf(f, "</details>\n")
+	return len(e.folded)
+}
+
+func renderHtmlPost(f io.Writer, p *Post, opts htmlOptions) {
+	host := p.shortFeedLink()
+	icon := ""
+	if uri, ok := opts.Favicons[host]; ok {
+		icon = fmt.Sprintf("<img class=\"favicon\" src=\"%s\">", uri)
+	}
+	readingTime := ""
+	if p.Words > 0 {
+		readingTime = fmt.Sprintf(" <span class=\"reading-time\" title=\"%d words\">%d min</span>", p.Words, p.ReadingMinutes)
+	}
+	updated := ""
+	if p.Updated && p.UpdatedAt != nil {
+		updated = fmt.Sprintf(" <span class=\"updated\" title=\"Updated %s\">(updated)</span>", p.UpdatedAt.Format("Jan 2 2006 15:04"))
+	}
+	thumbnail := ""
+	if src := safeUrl(p.Thumbnail, false); opts.Thumbnails && src != "" {
+		thumbnail = fmt.Sprintf("<img class=\"thumbnail\" src=\"%s\" loading=\"lazy\">", gohtml.EscapeString(src))
+	}
+	// Everything from the feed is escaped, and links must be http(s), see
+	// sanitizeHtml for content
+	fmt.Fprintf(f, "<div class=\"post\" data-feed=\"%s\">%s<a href=\"%s\">%s</a>%s (%s%s)%s",
+		gohtml.EscapeString(host), thumbnail, gohtml.EscapeString(safeUrl(p.Link, true)), gohtml.EscapeString(p.Title), updated, icon, gohtml.EscapeString(p.shortFeedName()), readingTime)
+	if card, ok := opts.Cards[p.Link]; ok {
+		fmt.Fprintf(f, "<div class=\"card\">")
+		if image := safeUrl(card.Image, false); image != "" {
+			fmt.Fprintf(f, "<img src=\"%s\" loading=\"lazy\">", gohtml.EscapeString(image))
+		}
+		fmt.Fprintf(f, "<p>%s</p></div>", gohtml.EscapeString(card.Description))
+	}
+	if len(p.Alternates) > 0 {
+		fmt.Fprintf(f, "<div class=\"alternates\">Also in")
+		for i, a := range p.Alternates {
+			sep := ","
+			if i == 0 {
+				sep = ""
+			}
+			fmt.Fprintf(f, "%s <a href=\"%s\" title=\"%s\">%s</a>", sep, gohtml.EscapeString(safeUrl(a.Link, true)), gohtml.EscapeString(a.Title), gohtml.EscapeString(a.FeedTitle))
+		}
+		fmt.Fprintf(f, "</div>")
+	}
+	if summary, ok := opts.Summaries[p.Link]; ok {
+		fmt.Fprintf(f, "<p class=\"summary\">%s</p>", gohtml.EscapeString(summary))
+	}
+	fmt.Fprintf(f, "</div>\n")
+}
+
+// Filter box and a checkbox to toggle each feed
+func renderFilters(f io.Writer, posts []*Post) {
+	hosts := []string{}
+	seen := map[string]bool{}
+	for _, p := range posts {
+		host := p.shortFeedLink()
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+
+	fmt.Fprintf(f, "<div id=\"filters\">\n")
+	fmt.Fprintf(f, "<input id=\"filter\" type=\"search\" placeholder=\"Filter\" oninput=\"applyFilters()\">\n")
+	for _, host := range hosts {
+		host = gohtml.EscapeString(host)
+		fmt.Fprintf(f, "<label><input type=\"checkbox\" class=\"feed-toggle\" value=\"%s\" checked onchange=\"applyFilters()\"> %s</label>\n", host, host)
+	}
+	fmt.Fprintf(f, "</div>\n")
+}
+
+const htmlScript = `// Append the next page of posts
+function loadMore() {
+	var page = document.querySelector("template.page");
+	if (page) {
+		document.getElementById("posts").appendChild(page.content);
+		page.remove();
+	}
+	var more = document.getElementById("more");
+	if (more && !document.querySelector("template.page")) {
+		more.remove();
+	}
+}
+
+// Show posts matching the filter box from enabled feeds, hiding dates with no
+// visible posts. Every page is loaded first so filtering covers all posts.
+function applyFilters() {
+	while (document.querySelector("template.page")) {
+		loadMore();
+	}
+
+	var query = document.getElementById("filter").value.toLowerCase();
+	var enabled = {};
+	document.querySelectorAll(".feed-toggle").forEach(function(toggle) {
+		enabled[toggle.value] = toggle.checked;
+	});
+
+	selected = -1;
+	document.querySelectorAll(".selected").forEach(function(el) {
+		el.classList.remove("selected");
+	});
+
+	var header = null;
+	var visible = false;
+	var updateHeader = function() {
+		if (header) {
+			header.style.display = visible ? "" : "none";
+		}
+	};
+	document.querySelectorAll("#posts h4, #posts .post").forEach(function(el) {
+		if (el.tagName == "H4") {
+			updateHeader();
+			header = el;
+			visible = false;
+			return;
+		}
+		var show = enabled[el.dataset.feed] && el.textContent.toLowerCase().indexOf(query) >= 0;
+		el.style.display = show ? "" : "none";
+		visible = visible || show;
+	});
+	updateHeader();
+
+	// Hide folds with no visible posts left, and open the rest while
+	// searching so matches aren't tucked away
+	document.querySelectorAll("#posts details").forEach(function(fold) {
+		var show = Array.prototype.some.call(fold.querySelectorAll(".post"), function(el) {
+			return el.style.display != "none";
+		});
+		fold.style.display = show ? "" : "none";
+		if (show && query) {
+			fold.open = true;
+		}
+	});
+}
+
+// Keyboard navigation: j/k to move between posts, o to open the selected post
+// in a new tab, enter to open it in this one and / to focus the filter box.
+// Posts in closed folds are skipped.
+var selected = -1;
+
+function visiblePosts() {
+	return Array.prototype.filter.call(document.querySelectorAll(".post"), function(el) {
+		return el.style.display != "none" && !el.closest("details:not([open])");
+	});
+}
+
+function select(i) {
+	var posts = visiblePosts();
+	if (i >= posts.length && document.querySelector("template.page")) {
+		loadMore();
+		posts = visiblePosts();
+	}
+	if (posts.length == 0) {
+		return;
+	}
+	selected = Math.max(0, Math.min(i, posts.length - 1));
+	document.querySelectorAll(".selected").forEach(function(el) {
+		el.classList.remove("selected");
+	});
+	posts[selected].classList.add("selected");
+	posts[selected].scrollIntoView({block: "nearest"});
+}
+
+document.addEventListener("keydown", function(e) {
+	if (e.target.tagName == "INPUT") {
+		if (e.key == "Escape") {
+			e.target.blur();
+		}
+		return;
+	}
+	if (e.ctrlKey || e.metaKey || e.altKey) {
+		return;
+	}
+
+	var post = visiblePosts()[selected];
+	switch (e.key) {
+	case "j":
+		select(selected + 1);
+		break;
+	case "k":
+		select(selected - 1);
+		break;
+	case "o":
+		if (post) {
+			window.open(post.querySelector("a").href, "_blank");
+		}
+		break;
+	case "Enter":
+		if (post) {
+			window.location = post.querySelector("a").href;
+		}
+		break;
+	case "/":
+		document.getElementById("filter").focus();
+		break;
+	default:
+		return;
+	}
+	e.preventDefault();
+});
+`
+
+// Insert posts pushed by serve at the top of the page
+const liveScript = `
+var events = new EventSource("events");
+events.addEventListener("post", function(e) {
+	var post = JSON.parse(e.data);
+	var posts = document.getElementById("posts");
+	var header = posts.querySelector("h4");
+	if (!header || header.textContent != post.date) {
+		header = document.createElement("h4");
+		header.textContent = post.date;
+		posts.insertBefore(header, posts.firstChild);
+	}
+	header.insertAdjacentHTML("afterend", post.html);
+	if (selected >= 0) {
+		selected++;
+	}
+});
+`
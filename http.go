@@ -0,0 +1,126 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/pkg/errors"
+)
+
+// Shared by every fetch, so connections (and HTTP/2 sessions) to a host are
+// reused across feeds, cards and favicons. Compression is negotiated in
+// doRequest rather than by the transport, so brotli and deflate are handled
+// too.
+var transport = &http.Transport{
+	Proxy:               http.ProxyFromEnvironment,
+	DialContext:         dialContext,
+	ForceAttemptHTTP2:   true,
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 8,
+	IdleConnTimeout:     90 * time.Second,
+	TLSHandshakeTimeout: 10 * time.Second,
+	DisableCompression:  true,
+}
+
+var httpClient = &http.Client{Transport: transport}
+
+var dialer = &net.Dialer{
+	Timeout:   30 * time.Second,
+	KeepAlive: 30 * time.Second,
+}
+
+// "4" or "6" to only connect over that IP version, set from --ipv4/--ipv6
+var ipVersion string
+
+// Dial addr for fetches, resolving its host over DoH if --doh is set
+func dialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	if network == "tcp" {
+		network += ipVersion
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || dohUrl == "" || net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	ips, err := dohLookup(ctx, host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed resolving %q over DoH", host)
+	}
+	var conn net.Conn
+	err = fmt.Errorf("No usable addresses for %q", host)
+	for _, ip := range ips {
+		if (ipVersion == "4" && ip.To4() == nil) || (ipVersion == "6" && ip.To4() != nil) {
+			continue
+		}
+		conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// Send req with the shared client, asking for a compressed response and
+// decoding it. Reading the body counts against --max-rate, before
+// decompression.
+func doRequest(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Accept-Encoding", "br, gzip, deflate")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := resp.Body
+	var body io.Reader = bandwidth.reader(req.Context(), raw)
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip", "x-gzip":
+		body = &lazyReader{r: body, open: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }}
+	case "br":
+		body = brotli.NewReader(body)
+	case "deflate":
+		body = &lazyReader{r: body, open: func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) }}
+	case "", "identity":
+	default:
+		raw.Close()
+		return nil, fmt.Errorf("Unsupported Content-Encoding %q", resp.Header.Get("Content-Encoding"))
+	}
+	if body != raw {
+		resp.Header.Del("Content-Encoding")
+		resp.ContentLength = -1
+		resp.Body = readCloser{body, raw}
+	}
+	return resp, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Decompressor created on the first read, since bodiless responses (304s) can
+// still claim to be compressed
+type lazyReader struct {
+	r       io.Reader
+	open    func(io.Reader) (io.Reader, error)
+	decoded io.Reader
+}
+
+func (r *lazyReader) Read(p []byte) (int, error) {
+	if r.decoded == nil {
+		decoded, err := r.open(r.r)
+		if err != nil {
+			return 0, err
+		}
+		r.decoded = decoded
+	}
+	return r.decoded.Read(p)
+}
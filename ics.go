@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+)
+
+var icsUrlRegex = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// Calendars (.ics) are sources too, their upcoming events becoming posts
+// dated by when they start, with the event's start, end and location as if
+// the feed had them in the ev: extension. webcal:// urls are fetched over
+// https. Repeating events only appear for their first occurrence.
+func isIcs(contents []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(bytes.TrimPrefix(contents, []byte("\xef\xbb\xbf"))), []byte("BEGIN:VCALENDAR"))
+}
+
+// A content line, NAME;PARAM=VALUE:VALUE
+type icsProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+func parseIcs(feedUrl *url.URL, contents []byte) *gofeed.Feed {
+	feed := &gofeed.Feed{Title: feedUrl.Host, Link: feedUrl.String(), Items: []*gofeed.Item{}}
+	now := time.Now()
+
+	var event map[string]*icsProperty
+	for _, p := range icsProperties(string(contents)) {
+		switch {
+		case p.name == "X-WR-CALNAME" && event == nil:
+			feed.Title = icsUnescape(p.value)
+		case p.name == "BEGIN" && p.value == "VEVENT":
+			event = map[string]*icsProperty{}
+		case p.name == "END" && p.value == "VEVENT":
+			if item := icsEventItem(feedUrl, event); item != nil {
+				// Upcoming and ongoing events only
+				end := item.PublishedParsed
+				if e := parseEventTime(extensionValue(item.Extensions, "ev", "enddate")); e != nil {
+					end = e
+				}
+				if !end.Before(now) {
+					feed.Items = append(feed.Items, item)
+				}
+			}
+			event = nil
+		case event != nil:
+			// The first of each, later ones are usually in alarms
+			if _, ok := event[p.name]; !ok {
+				event[p.name] = p
+			}
+		}
+	}
+	return feed
+}
+
+func icsEventItem(feedUrl *url.URL, event map[string]*icsProperty) *gofeed.Item {
+	if event["DTSTART"] == nil || event["STATUS"] != nil && event["STATUS"].value == "CANCELLED" {
+		return nil
+	}
+	start, ok := icsTime(event["DTSTART"])
+	if !ok {
+		return nil
+	}
+	value := func(name string) string {
+		if p := event[name]; p != nil {
+			return icsUnescape(p.value)
+		}
+		return ""
+	}
+
+	description := value("DESCRIPTION")
+	link := value("URL")
+	if link == "" {
+		link = icsUrlRegex.FindString(description)
+	}
+	uid := value("UID")
+	if link == "" {
+		// Events without a link of their own are told apart by their uid
+		link = feedUrl.String() + "#" + url.PathEscape(uid)
+	}
+
+	eventExt := map[string][]ext.Extension{
+		"startdate": {{Name: "startdate", Value: start.Format(time.RFC3339)}},
+	}
+	end, ok := time.Time{}, false
+	if p := event["DTEND"]; p != nil {
+		end, ok = icsTime(p)
+	} else if len(event["DTSTART"].value) == len("20060102") {
+		// All day events last the day
+		end, ok = start.AddDate(0, 0, 1), true
+	}
+	if ok {
+		eventExt["enddate"] = []ext.Extension{{Name: "enddate", Value: end.Format(time.RFC3339)}}
+	}
+	if location := value("LOCATION"); location != "" {
+		eventExt["location"] = []ext.Extension{{Name: "location", Value: location}}
+	}
+
+	item := &gofeed.Item{
+		Title:           value("SUMMARY"),
+		Link:            link,
+		GUID:            uid,
+		Content:         markdownParagraphs(description),
+		PublishedParsed: &start,
+		Extensions:      ext.Extensions{"ev": eventExt},
+	}
+	if p := event["LAST-MODIFIED"]; p != nil {
+		if modified, ok := icsTime(p); ok {
+			item.UpdatedParsed = &modified
+		}
+	}
+	if categories := value("CATEGORIES"); categories != "" {
+		for _, c := range strings.Split(categories, ",") {
+			item.Categories = append(item.Categories, strings.TrimSpace(c))
+		}
+	}
+	return item
+}
+
+// Unfold the calendar's lines and split them into properties
+func icsProperties(contents string) []*icsProperty {
+	contents = strings.ReplaceAll(contents, "\r\n", "\n")
+	contents = strings.NewReplacer("\n ", "", "\n\t", "").Replace(contents)
+
+	props := []*icsProperty{}
+	for _, line := range strings.Split(contents, "\n") {
+		// Parameter values can be quoted, and have colons in them
+		colon, quoted := -1, false
+		for i, c := range line {
+			if c == '"' {
+				quoted = !quoted
+			} else if c == ':' && !quoted {
+				colon = i
+				break
+			}
+		}
+		if colon < 0 {
+			continue
+		}
+		parts := strings.Split(line[:colon], ";")
+		p := &icsProperty{name: strings.ToUpper(parts[0]), params: map[string]string{}, value: line[colon+1:]}
+		for _, param := range parts[1:] {
+			if kv := strings.SplitN(param, "=", 2); len(kv) == 2 {
+				p.params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+			}
+		}
+		props = append(props, p)
+	}
+	return props
+}
+
+// A DATE-TIME in UTC, in its TZID or floating (local), or an all day DATE
+func icsTime(p *icsProperty) (time.Time, bool) {
+	loc := time.Local
+	if strings.HasSuffix(p.value, "Z") {
+		loc = time.UTC
+	} else if tzid := p.params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	for _, layout := range []string{ICS_TIME, "20060102T150405", "20060102"} {
+		if t, err := time.ParseInLocation(layout, p.value, loc); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func icsUnescape(s string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const IMAGE_CONCURRENCY = 8
+const IMAGE_MAX_AGE = 30 * 24 * time.Hour
+const IMAGE_MAX_BYTES = 5 * 1024 * 1024
+
+// Largest image decoded, since a small file can claim a huge canvas
+const IMAGE_MAX_PIXELS = 16 * 1024 * 1024
+
+// Size of thumbnails in terminal cells, and the pixels they're scaled to fit
+// assuming cells around 10x20
+const IMAGE_COLUMNS = 16
+const IMAGE_ROWS = 5
+const IMAGE_WIDTH = 160
+const IMAGE_HEIGHT = 100
+
+// How this terminal shows images: the kitty graphics protocol, iTerm2's
+// inline images (also in WezTerm) or sixel
+func terminalImageProtocol() string {
+	if os.Getenv("KITTY_WINDOW_ID") != "" || os.Getenv("TERM") == "xterm-kitty" {
+		return "kitty"
+	}
+	switch os.Getenv("TERM_PROGRAM") {
+	case "iTerm.app", "WezTerm":
+		return "iterm"
+	}
+	return "sixel"
+}
+
+// Fetch each post's thumbnail as escapes drawing it in the terminal,
+// returning link -> escapes. At most IMAGE_CONCURRENCY images are fetched at
+// once, and they're cached on disk scaled down for IMAGE_MAX_AGE.
+func fetchTerminalImages(ctx context.Context, posts []*Post) map[string]string {
+	protocol := terminalImageProtocol()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, IMAGE_CONCURRENCY)
+	images := map[string]string{}
+	for _, p := range posts {
+		if p.Thumbnail == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(link string, thumbnail string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			contents, err := cachedImage(ctx, thumbnail)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed fetching image %q: %v\n", thumbnail, err)
+				return
+			}
+			if len(contents) == 0 {
+				return
+			}
+			escapes, err := terminalImage(contents, protocol)
+			if err != nil {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			images[link] = escapes
+		}(p.Link, p.Thumbnail)
+	}
+	wg.Wait()
+
+	return images
+}
+
+// The image at imageUrl scaled to fit IMAGE_WIDTH x IMAGE_HEIGHT as a png, or
+// nothing if it isn't one that can be decoded
+func cachedImage(ctx context.Context, imageUrl string) ([]byte, error) {
+	sum := sha1.Sum([]byte(imageUrl))
+	path, err := cachePath("images", hex.EncodeToString(sum[:])+".png")
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err == nil && (*offline || time.Since(info.ModTime()) < IMAGE_MAX_AGE) {
+		return ioutil.ReadFile(path)
+	}
+	if *offline {
+		return nil, nil
+	}
+
+	contents, err := fetchImage(ctx, imageUrl)
+	if err != nil {
+		return nil, err
+	}
+	// Cached empty if it can't be shown, so it isn't fetched again
+	var scaled []byte
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(contents)); err == nil && cfg.Width*cfg.Height <= IMAGE_MAX_PIXELS {
+		if img, _, err := image.Decode(bytes.NewReader(contents)); err == nil {
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, scaleImage(img, IMAGE_WIDTH, IMAGE_HEIGHT)); err == nil {
+				scaled = buf.Bytes()
+			}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return scaled, ioutil.WriteFile(path, scaled, 0644)
+}
+
+func fetchImage(ctx context.Context, imageUrl string) ([]byte, error) {
+	ctxTimeout, timeoutCancel := context.WithTimeout(ctx, FETCH_TIMEOUT)
+	defer timeoutCancel()
+
+	req, err := http.NewRequest("GET", imageUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent())
+	req = req.WithContext(ctxTimeout)
+
+	release, err := limiter.acquire(ctxTimeout, req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// Cache as an image that can't be shown
+		return nil, nil
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, IMAGE_MAX_BYTES))
+}
+
+// Shrink img to fit in width x height keeping its aspect ratio, averaging the
+// pixels each new one covers. Smaller images are left as they are.
+func scaleImage(img image.Image, width int, height int) image.Image {
+	b := img.Bounds()
+	if b.Dx() <= width && b.Dy() <= height {
+		return img
+	}
+	w, h := width, b.Dy()*width/b.Dx()
+	if h > height {
+		w, h = b.Dx()*height/b.Dy(), height
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	scaled := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/h, b.Min.Y+(y+1)*b.Dy()/h
+		for x := 0; x < w; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/w, b.Min.X+(x+1)*b.Dx()/w
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+pr, g+pg, bl+pb, a+pa, n+1
+				}
+			}
+			if n > 0 {
+				scaled.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+			}
+		}
+	}
+	return scaled
+}
+
+// Escapes drawing the png in contents at the cursor, IMAGE_COLUMNS x
+// IMAGE_ROWS cells for the protocols that can size it
+func terminalImage(contents []byte, protocol string) (string, error) {
+	encoded := base64.StdEncoding.EncodeToString(contents)
+	switch protocol {
+	case "kitty":
+		// Sent in chunks of at most 4096 bytes
+		var b strings.Builder
+		for i := 0; i < len(encoded); i += 4096 {
+			end := i + 4096
+			more := 1
+			if end >= len(encoded) {
+				end = len(encoded)
+				more = 0
+			}
+			if i == 0 {
+				fmt.Fprintf(&b, "\x1b_Ga=T,f=100,c=%d,r=%d,m=%d;%s\x1b\\", IMAGE_COLUMNS, IMAGE_ROWS, more, encoded[i:end])
+			} else {
+				fmt.Fprintf(&b, "\x1b_Gm=%d;%s\x1b\\", more, encoded[i:end])
+			}
+		}
+		return b.String(), nil
+	case "iterm":
+		return fmt.Sprintf("\x1b]1337;File=inline=1;size=%d;width=%d;height=%d;preserveAspectRatio=1:%s\a", len(contents), IMAGE_COLUMNS, IMAGE_ROWS, encoded), nil
+	default:
+		img, err := png.Decode(bytes.NewReader(contents))
+		if err != nil {
+			return "", err
+		}
+		return sixel(img), nil
+	}
+}
+
+// Encode img as sixel, dithered to the 216 web safe colors
+func sixel(img image.Image) string {
+	b := img.Bounds()
+	paletted := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), palette.WebSafe)
+	draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), img, b.Min)
+	w, h := paletted.Bounds().Dx(), paletted.Bounds().Dy()
+
+	var s strings.Builder
+	fmt.Fprintf(&s, "\x1bPq\"1;1;%d;%d", w, h)
+	for i, c := range paletted.Palette {
+		r, g, bl, _ := c.RGBA()
+		fmt.Fprintf(&s, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, bl*100/0xffff)
+	}
+
+	// Six rows at a time, a pass over the band per color in it
+	for top := 0; top < h; top += 6 {
+		used := map[uint8]bool{}
+		order := []uint8{}
+		for y := top; y < top+6 && y < h; y++ {
+			for x := 0; x < w; x++ {
+				if i := paletted.ColorIndexAt(x, y); !used[i] {
+					used[i] = true
+					order = append(order, i)
+				}
+			}
+		}
+		for n, i := range order {
+			if n > 0 {
+				s.WriteString("$")
+			}
+			fmt.Fprintf(&s, "#%d", i)
+			var run byte
+			count := 0
+			flush := func() {
+				if count > 3 {
+					fmt.Fprintf(&s, "!%d%c", count, run)
+				} else {
+					s.WriteString(strings.Repeat(string(run), count))
+				}
+			}
+			for x := 0; x < w; x++ {
+				var bits byte
+				for dy := 0; dy < 6 && top+dy < h; dy++ {
+					if paletted.ColorIndexAt(x, top+dy) == i {
+						bits |= 1 << uint(dy)
+					}
+				}
+				c := bits + 63
+				if count > 0 && c != run {
+					flush()
+					count = 0
+				}
+				run = c
+				count++
+			}
+			flush()
+		}
+		s.WriteString("-")
+	}
+	s.WriteString("\x1b\\")
+	return s.String()
+}
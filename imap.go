@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	gohtml "html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/pkg/errors"
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// Newest messages read from the folder per fetch
+const IMAP_MAX_MESSAGES = 100
+
+// How far back messages are read when --since doesn't say
+const IMAP_SINCE = 90 * 24 * time.Hour
+
+// A mail folder of newsletters, read with IMAP. Each message is a post under
+// its sender's name, linked to its "view in browser" link or the first link in
+// it:
+//
+//	imaps://me@imap.example.com/Newsletters
+//
+// with the password in the url's config section or the keyring under
+// me@imap.example.com. Plain imap:// urls are for local bridges only, the
+// password is sent unencrypted. Messages are read without marking them seen.
+func isImap(u *url.URL) bool {
+	return u.Scheme == "imaps" || u.Scheme == "imap"
+}
+
+var imapLiteralRegex = regexp.MustCompile(`\{(\d+)\}$`)
+
+// Links to the newsletter on the web, preferred over its other links
+var webVersionRegex = regexp.MustCompile(`(?i)(view|read|open)( (it|this( email)?|the newsletter))? (in|on) (your |a |the )?(web ?)?browser|(view|read) (it )?online|web version`)
+
+type imapConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// An untagged response, with the literals ({n} and n bytes) in it
+type imapResponse struct {
+	text     string
+	literals [][]byte
+}
+
+func fetchImapFeed(ctx context.Context, feedUrl *url.URL, fc *FeedConfig) (*gofeed.Feed, error) {
+	if *offline {
+		return nil, errors.New("IMAP feeds aren't cached, and --offline")
+	}
+	user := feedUrl.User.Username()
+	if user == "" {
+		return nil, errors.New("No IMAP user, put it in the url like imaps://user@host/folder")
+	}
+	password := fc.Password
+	if password == "" {
+		var err error
+		if password, err = keyringSecret(user + "@" + feedUrl.Hostname()); err != nil {
+			return nil, errors.Wrapf(err, "No password for %q in its config section", feedUrl.String())
+		}
+	}
+	folder := strings.TrimPrefix(feedUrl.Path, "/")
+	if folder == "" {
+		folder = "INBOX"
+	}
+
+	release, err := limiter.acquire(ctx, feedUrl.Host)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	c, err := dialImap(ctx, feedUrl, fc)
+	if err != nil {
+		return nil, err
+	}
+	defer c.conn.Close()
+	stop := context.AfterFunc(ctx, func() { c.conn.Close() })
+	defer stop()
+
+	if _, err := c.command("LOGIN %s %s", imapQuote(user), imapQuote(password)); err != nil {
+		return nil, err
+	}
+	defer c.command("LOGOUT")
+	// Read-only, so messages aren't marked seen
+	if _, err := c.command("EXAMINE %s", imapQuote(folder)); err != nil {
+		return nil, err
+	}
+
+	since := time.Now().Add(-IMAP_SINCE)
+	if cutoff != nil {
+		since = cutoff.time()
+	}
+	found, err := c.command("UID SEARCH SINCE %s", since.Format("2-Jan-2006"))
+	if err != nil {
+		return nil, err
+	}
+	uids := []string{}
+	for _, r := range found {
+		if strings.HasPrefix(r.text, "SEARCH") {
+			uids = append(uids, strings.Fields(r.text)[1:]...)
+		}
+	}
+
+	feed := &gofeed.Feed{Title: folder, Link: feedUrl.String(), Items: []*gofeed.Item{}}
+	if len(uids) == 0 {
+		return feed, nil
+	}
+	if len(uids) > IMAP_MAX_MESSAGES {
+		uids = uids[len(uids)-IMAP_MAX_MESSAGES:]
+	}
+	messages, err := c.command("UID FETCH %s BODY.PEEK[]", strings.Join(uids, ","))
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range messages {
+		if len(m.literals) == 0 {
+			continue
+		}
+		item, err := imapMessageItem(m.literals[0])
+		if err != nil {
+			continue
+		}
+		feed.Items = append(feed.Items, item)
+	}
+	return feed, nil
+}
+
+func dialImap(ctx context.Context, u *url.URL, fc *FeedConfig) (*imapConn, error) {
+	port := u.Port()
+	if port == "" && u.Scheme == "imaps" {
+		port = "993"
+	} else if port == "" {
+		port = "143"
+	}
+	conn, err := dialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return nil, err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(fc.timeout())
+	}
+	conn.SetDeadline(deadline)
+	if u.Scheme == "imaps" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	c := &imapConn{conn: conn, r: bufio.NewReader(conn)}
+	greeting, err := c.r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(greeting, "* OK") {
+		conn.Close()
+		return nil, fmt.Errorf("%s isn't an IMAP server: %q", u.Host, strings.TrimSpace(greeting))
+	}
+	return c, nil
+}
+
+// Send a command, returning its untagged responses once it's completed OK
+func (c *imapConn) command(format string, args ...interface{}) ([]*imapResponse, error) {
+	c.tag++
+	tag := fmt.Sprintf("p%d", c.tag)
+	cmd := fmt.Sprintf(format, args...)
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, cmd); err != nil {
+		return nil, err
+	}
+
+	responses := []*imapResponse{}
+	for {
+		r := &imapResponse{}
+		for {
+			line, err := c.r.ReadString('\n')
+			if err != nil {
+				return nil, err
+			}
+			line = strings.TrimRight(line, "\r\n")
+			r.text += line
+			m := imapLiteralRegex.FindStringSubmatch(line)
+			if m == nil {
+				break
+			}
+			n, _ := strconv.Atoi(m[1])
+			literal := make([]byte, n)
+			if _, err := io.ReadFull(c.r, literal); err != nil {
+				return nil, err
+			}
+			r.literals = append(r.literals, literal)
+		}
+
+		switch {
+		case strings.HasPrefix(r.text, tag+" "):
+			status := strings.TrimPrefix(r.text, tag+" ")
+			if !strings.HasPrefix(status, "OK") {
+				// Not the command, which has the password in it
+				verb := strings.Fields(cmd)[0]
+				return nil, fmt.Errorf("IMAP %s failed: %s", verb, status)
+			}
+			return responses, nil
+		case strings.HasPrefix(r.text, "* "):
+			r.text = strings.TrimPrefix(r.text, "* ")
+			// FETCH responses start with the message's sequence number
+			if fields := strings.SplitN(r.text, " ", 2); len(fields) == 2 {
+				if _, err := strconv.Atoi(fields[0]); err == nil {
+					r.text = fields[1]
+				}
+			}
+			responses = append(responses, r)
+		}
+	}
+}
+
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// A message as a post, under its sender's name
+func imapMessageItem(raw []byte) (*gofeed.Item, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	decoder := &mime.WordDecoder{CharsetReader: charset.NewReaderLabel}
+	subject, err := decoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+	date, err := msg.Header.Date()
+	if err != nil {
+		return nil, err
+	}
+	sender := msg.Header.Get("From")
+	parser := &mail.AddressParser{WordDecoder: decoder}
+	if from, err := parser.Parse(sender); err == nil {
+		sender = from.Name
+		if sender == "" {
+			sender = from.Address
+		}
+	}
+
+	html, text := messageBodies(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	content := html
+	if content == "" {
+		content = markdownParagraphs(text)
+	}
+	link := messageLink(html, text)
+	id := strings.Trim(msg.Header.Get("Message-Id"), "<> ")
+	if link == "" && id != "" {
+		link = "mid:" + url.PathEscape(id)
+	}
+
+	return &gofeed.Item{
+		Title:           subject,
+		Link:            link,
+		GUID:            id,
+		Content:         content,
+		PublishedParsed: &date,
+		Author:          &gofeed.Person{Name: sender},
+		Custom:          map[string]string{"feed_title": sender},
+	}, nil
+}
+
+// The html and plain text bodies of a message or part, found in any
+// multipart parts and decoded to utf-8
+func messageBodies(contentType string, encoding string, body io.Reader) (string, string) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		html, text := "", ""
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			h, t := messageBodies(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if html == "" {
+				html = h
+			}
+			if text == "" {
+				text = t
+			}
+		}
+		return html, text
+	}
+	if mediaType != "text/html" && mediaType != "text/plain" {
+		return "", ""
+	}
+
+	switch strings.ToLower(encoding) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	if cs := params["charset"]; cs != "" {
+		if r, err := charset.NewReaderLabel(cs, body); err == nil {
+			body = r
+		}
+	}
+	contents, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", ""
+	}
+	if mediaType == "text/html" {
+		return string(contents), ""
+	}
+	return "", string(contents)
+}
+
+// The newsletter's web version, or its first link that isn't to unsubscribe
+func messageLink(html string, text string) string {
+	first := ""
+	if html != "" {
+		z := xhtml.NewTokenizer(strings.NewReader(html))
+		href := ""
+		for {
+			tt := z.Next()
+			if tt == xhtml.ErrorToken {
+				break
+			}
+			name, hasAttr := z.TagName()
+			if string(name) != "a" {
+				if tt == xhtml.TextToken && href != "" && webVersionRegex.Match(z.Text()) {
+					return href
+				}
+				continue
+			}
+			if tt == xhtml.EndTagToken {
+				href = ""
+				continue
+			}
+			href = ""
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				if string(key) == "href" {
+					href = gohtml.UnescapeString(strings.TrimSpace(string(val)))
+				}
+			}
+			if !strings.HasPrefix(href, "http") {
+				href = ""
+			} else if first == "" && !strings.Contains(strings.ToLower(href), "unsubscribe") {
+				first = href
+			}
+		}
+		return first
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		for _, word := range strings.Fields(line) {
+			word = strings.Trim(word, "<>()[]")
+			if !strings.HasPrefix(word, "http://") && !strings.HasPrefix(word, "https://") {
+				continue
+			}
+			if webVersionRegex.MatchString(line) {
+				return word
+			}
+			if first == "" && !strings.Contains(strings.ToLower(word), "unsubscribe") {
+				first = word
+			}
+		}
+	}
+	return first
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Service name secrets are stored under in the keyring
+const KEYRING_SERVICE = "picofeed"
+
+// Secret stored in the system keyring for account: the macOS login keychain,
+// or the Secret Service (GNOME Keyring, KWallet) elsewhere. Store one with
+//
+//	security add-generic-password -s picofeed -a <account> -w '<secret>'
+//	secret-tool store --label picofeed service picofeed account <account>
+func keyringSecret(account string) (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", KEYRING_SERVICE, "-a", account, "-w")
+	case "windows":
+		return "", fmt.Errorf("Reading the keyring isn't supported on windows")
+	default:
+		cmd = exec.Command("secret-tool", "lookup", "service", KEYRING_SERVICE, "account", account)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("No %s secret for %q in the keyring (%s failed: %v)", KEYRING_SERVICE, account, cmd.Path, err)
+	}
+	secret := strings.TrimRight(string(out), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("No %s secret for %q in the keyring", KEYRING_SERVICE, account)
+	}
+	return secret, nil
+}
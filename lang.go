@@ -0,0 +1,134 @@
+package main
+
+import (
+	gohtml "html"
+	"strings"
+	"unicode"
+)
+
+// Words of a post's text looked at to tell its language
+const LANG_SAMPLE_WORDS = 200
+
+// Stopwords a latin script language needs at least this many of, and more than
+// any other language, to be told apart
+const LANG_MIN_MATCHES = 3
+
+// Common short words of languages written in latin script, by ISO 639-1 code
+var langStopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "in", "that", "it", "for", "with", "this", "you", "are", "was", "on", "be", "have", "not", "but", "what"},
+	"de": {"der", "die", "und", "das", "ist", "nicht", "ein", "eine", "ich", "mit", "sich", "auf", "für", "den", "dem", "auch", "es", "zu", "von", "wir"},
+	"fr": {"le", "la", "les", "et", "est", "des", "une", "un", "que", "pour", "dans", "pas", "qui", "sur", "du", "au", "avec", "ce", "sont", "nous"},
+	"es": {"el", "la", "los", "las", "y", "es", "que", "de", "en", "un", "una", "por", "para", "con", "no", "se", "del", "lo", "como", "más"},
+	"it": {"il", "di", "che", "e", "la", "è", "per", "un", "una", "non", "con", "sono", "del", "della", "gli", "le", "si", "da", "come", "anche"},
+	"pt": {"o", "a", "os", "as", "e", "é", "de", "que", "não", "um", "uma", "para", "com", "do", "da", "em", "no", "na", "mais", "por"},
+	"nl": {"de", "het", "een", "en", "van", "is", "dat", "niet", "op", "te", "zijn", "met", "voor", "ik", "je", "die", "er", "ook", "maar", "wat"},
+	"sv": {"och", "att", "det", "är", "som", "en", "på", "för", "med", "inte", "av", "till", "har", "den", "jag", "om", "ett", "de", "men", "var"},
+	"pl": {"i", "w", "nie", "na", "się", "z", "to", "jest", "że", "do", "jak", "o", "co", "ale", "tak", "od", "po", "dla", "czy", "są"},
+	"tr": {"ve", "bir", "bu", "için", "ile", "da", "de", "ne", "çok", "gibi", "daha", "olarak", "değil", "ama", "ben", "var", "mi", "o", "en", "sonra"},
+}
+
+// Languages told by their script alone
+var langScripts = []struct {
+	lang   string
+	script *unicode.RangeTable
+}{
+	{"ko", unicode.Hangul},
+	{"el", unicode.Greek},
+	{"ar", unicode.Arabic},
+	{"he", unicode.Hebrew},
+	{"hi", unicode.Devanagari},
+	{"th", unicode.Thai},
+}
+
+// The language of a post's title and content as an ISO 639-1 code, or "" if
+// it can't be told (e.g. there's too little text)
+func detectLanguage(title string, content string) string {
+	text := gohtml.UnescapeString(tagRegex.ReplaceAllString(content, " "))
+	words := strings.Fields(strings.ToLower(title + " " + text))
+	if len(words) > LANG_SAMPLE_WORDS {
+		words = words[:LANG_SAMPLE_WORDS]
+	}
+
+	// Scripts other than latin mostly give the language away
+	letters, latin, han, kana, cyrillic, ukrainian := 0, 0, 0, 0, 0, 0
+	scripts := map[string]int{}
+	for _, w := range words {
+		for _, r := range w {
+			if !unicode.IsLetter(r) {
+				continue
+			}
+			letters++
+			switch {
+			case unicode.Is(unicode.Latin, r):
+				latin++
+			case unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r):
+				kana++
+			case unicode.Is(unicode.Han, r):
+				han++
+			case unicode.Is(unicode.Cyrillic, r):
+				cyrillic++
+				if strings.ContainsRune("іїєґ", r) {
+					ukrainian++
+				}
+			default:
+				for _, s := range langScripts {
+					if unicode.Is(s.script, r) {
+						scripts[s.lang]++
+					}
+				}
+			}
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	switch {
+	case kana > 0 && (kana+han)*2 > letters:
+		return "ja"
+	case han*2 > letters:
+		return "zh"
+	case cyrillic*2 > letters && ukrainian > 0:
+		return "uk"
+	case cyrillic*2 > letters:
+		return "ru"
+	}
+	for lang, n := range scripts {
+		if n*2 > letters {
+			return lang
+		}
+	}
+	if latin*2 <= letters {
+		return ""
+	}
+
+	counts := map[string]int{}
+	for _, w := range words {
+		w = strings.TrimFunc(w, func(r rune) bool { return !unicode.IsLetter(r) })
+		for lang, stopwords := range langStopwords {
+			if contains(stopwords, w) {
+				counts[lang]++
+			}
+		}
+	}
+	best, bestCount, second := "", 0, 0
+	for lang, n := range counts {
+		if n > bestCount {
+			best, bestCount, second = lang, n, bestCount
+		} else if n > second {
+			second = n
+		}
+	}
+	if bestCount < LANG_MIN_MATCHES || bestCount == second {
+		return ""
+	}
+	return best
+}
+
+// A feed's language tag (en-US, de_DE...) as an ISO 639-1 code
+func feedLanguage(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if i := strings.IndexAny(language, "-_"); i >= 0 {
+		language = language[:i]
+	}
+	return language
+}
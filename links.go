@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	gohtml "html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const LINK_CONCURRENCY = 8
+const LINK_MAX_AGE = 30 * 24 * time.Hour
+
+// Match <link rel="canonical" href="..."> with attributes in either order
+var canonicalRegexes = []*regexp.Regexp{
+	regexp.MustCompile(`<link[^>]*rel=["']canonical["'][^>]*href=["']([^"']*)["']`),
+	regexp.MustCompile(`<link[^>]*href=["']([^"']*)["'][^>]*rel=["']canonical["']`),
+}
+
+// Resolve links per --canonical, or otherwise unshorten them unless
+// --no-unshorten
+func fixLinks(ctx context.Context, posts []*Post) {
+	if ctx.Err() != nil {
+		return
+	}
+	if *canonical {
+		resolveLinks(ctx, posts)
+	} else if !*noUnshorten {
+		unshortenLinks(ctx, posts)
+	}
+}
+
+// Hosts whose links only redirect elsewhere: url shorteners and click trackers
+var shorteners = map[string]bool{
+	"bit.ly":               true,
+	"bitly.com":            true,
+	"buff.ly":              true,
+	"cutt.ly":              true,
+	"dlvr.it":              true,
+	"feedproxy.google.com": true,
+	"fb.me":                true,
+	"goo.gl":               true,
+	"is.gd":                true,
+	"j.mp":                 true,
+	"lnkd.in":              true,
+	"ow.ly":                true,
+	"rebrand.ly":           true,
+	"shorturl.at":          true,
+	"t.co":                 true,
+	"t.ly":                 true,
+	"tiny.cc":              true,
+	"tinyurl.com":          true,
+	"trib.al":              true,
+	"wp.me":                true,
+}
+
+// Replace links to shorteners with where they redirect to, cached on disk for
+// LINK_MAX_AGE
+func unshortenLinks(ctx context.Context, posts []*Post) {
+	short := []*Post{}
+	for _, p := range posts {
+		if u, err := url.Parse(p.Link); err == nil && shorteners[strings.ToLower(u.Hostname())] {
+			short = append(short, p)
+		}
+	}
+	if len(short) == 0 {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Unshortening %d links\n", len(short))
+	resolveEach(ctx, short, "unshortened", redirectLink)
+}
+
+// Replace each post's link with its canonical url: where it redirects to (e.g.
+// out of feedburner), or its rel=canonical if the page has one. At most
+// LINK_CONCURRENCY pages are fetched at once, and resolved links are cached on
+// disk for LINK_MAX_AGE.
+func resolveLinks(ctx context.Context, posts []*Post) {
+	fmt.Fprintf(os.Stderr, "Resolving links for %d posts\n", len(posts))
+	resolveEach(ctx, posts, "links", canonicalLink)
+}
+
+// Replace each post's link with resolve's, caching results in cacheDir
+func resolveEach(ctx context.Context, posts []*Post, cacheDir string, resolve func(context.Context, string) (string, error)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, LINK_CONCURRENCY)
+	for _, p := range posts {
+		wg.Add(1)
+		go func(p *Post) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			link, err := cachedLink(ctx, p.Link, cacheDir, resolve)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed resolving %q: %v\n", p.Link, err)
+				return
+			}
+			p.Link = link
+		}(p)
+	}
+	wg.Wait()
+}
+
+type cachedLinkEntry struct {
+	Url string `json:"url"`
+}
+
+func cachedLink(ctx context.Context, link string, cacheDir string, resolve func(context.Context, string) (string, error)) (string, error) {
+	sum := sha1.Sum([]byte(link))
+	path, err := cachePath(cacheDir, hex.EncodeToString(sum[:])+".json")
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(path)
+	if err == nil && (*offline || time.Since(info.ModTime()) < LINK_MAX_AGE) {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		entry := &cachedLinkEntry{}
+		return entry.Url, json.Unmarshal(contents, entry)
+	}
+	if *offline {
+		return link, nil
+	}
+
+	resolved, err := resolve(ctx, link)
+	if err != nil {
+		return "", err
+	}
+
+	contents, err := json.Marshal(&cachedLinkEntry{Url: resolved})
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	return resolved, ioutil.WriteFile(path, contents, 0644)
+}
+
+// Request link to see where it ends up, without reading the page
+func redirectLink(ctx context.Context, link string) (string, error) {
+	ctxTimeout, timeoutCancel := context.WithTimeout(ctx, FETCH_TIMEOUT)
+	defer timeoutCancel()
+
+	final := link
+	// Not every shortener allows HEAD, then try GET
+	for _, method := range []string{"HEAD", "GET"} {
+		req, err := http.NewRequest(method, link, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("User-Agent", defaultUserAgent())
+		req = req.WithContext(ctxTimeout)
+
+		release, err := limiter.acquire(ctxTimeout, req.URL.Host)
+		if err != nil {
+			return "", err
+		}
+		resp, err := doRequest(req)
+		release()
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+
+		final = resp.Request.URL.String()
+		if resp.StatusCode != http.StatusMethodNotAllowed && resp.StatusCode != http.StatusNotImplemented {
+			break
+		}
+	}
+	return final, nil
+}
+
+func canonicalLink(ctx context.Context, link string) (string, error) {
+	ctxTimeout, timeoutCancel := context.WithTimeout(ctx, FETCH_TIMEOUT)
+	defer timeoutCancel()
+
+	req, err := http.NewRequest("GET", link, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent())
+	req = req.WithContext(ctxTimeout)
+
+	release, err := limiter.acquire(ctxTimeout, req.URL.Host)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// Keep the link as is, it's the best there is
+		return link, nil
+	}
+
+	// After redirects
+	final := resp.Request.URL
+
+	// rel=canonical is in the <head>, same as OpenGraph tags
+	contents, err := ioutil.ReadAll(io.LimitReader(resp.Body, CARD_MAX_BYTES))
+	if err != nil {
+		return "", err
+	}
+	for _, re := range canonicalRegexes {
+		matches := re.FindStringSubmatch(string(contents))
+		if len(matches) < 2 {
+			continue
+		}
+		u, err := url.Parse(gohtml.UnescapeString(matches[1]))
+		if err == nil {
+			return final.ResolveReference(u).String(), nil
+		}
+	}
+	return final.String(), nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	gohtml "html"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/pkg/errors"
+)
+
+// Extensions of the files read from directory sources
+var localPostExts = []string{".md", ".markdown", ".html", ".htm"}
+
+var htmlTitleRegex = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>|<h1[^>]*>(.*?)</h1>`)
+
+var htmlBodyRegex = regexp.MustCompile(`(?is)<body[^>]*>(.*)</body>`)
+
+// Date formats front matter uses
+var frontMatterDates = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05 -0700", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// A local directory is a source too, given as a path or a file:// url, each
+// Markdown or HTML file in it (and the directories in it) being a post. Titles,
+// dates and tags come from YAML or TOML front matter, falling back to the first
+// heading and the file's modification time. Drafts are posts like any other.
+func isLocalDir(u *url.URL) bool {
+	return u.Scheme == "file"
+}
+
+// Whether an argument or feeds file line is written as a path (/dir, ./dir,
+// ../dir or ~/dir) rather than an alias or url, so it's read as a directory
+func looksLikePath(s string) bool {
+	return filepath.IsAbs(s) || strings.HasPrefix(s, "~/") || strings.HasPrefix(s, ".")
+}
+
+// A directory as a file:// url, false if path isn't one
+func localDirUrl(path string) (*url.URL, bool) {
+	path, err := expandHome(path)
+	if err != nil {
+		return nil, false
+	}
+	if f, err := os.Stat(path); err != nil || !f.IsDir() {
+		return nil, false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, false
+	}
+	return &url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}, true
+}
+
+func fetchLocalDirFeed(feedUrl *url.URL) (*gofeed.Feed, error) {
+	root := filepath.FromSlash(feedUrl.Path)
+	if f, err := os.Stat(root); err != nil {
+		return nil, err
+	} else if !f.IsDir() {
+		return nil, errors.Errorf("%q isn't a directory", root)
+	}
+
+	feed := &gofeed.Feed{Title: filepath.Base(root), Link: feedUrl.String(), Items: []*gofeed.Item{}}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".") && path != root {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || !contains(localPostExts, strings.ToLower(filepath.Ext(path))) {
+			return nil
+		}
+		item, err := localPostItem(path, info)
+		if err != nil {
+			return errors.Wrapf(err, "Failed reading %q", path)
+		}
+		feed.Items = append(feed.Items, item)
+		return nil
+	})
+	return feed, err
+}
+
+func localPostItem(path string, info os.FileInfo) (*gofeed.Item, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	meta, body := splitFrontMatter(string(contents))
+	isHtml := strings.HasPrefix(strings.ToLower(filepath.Ext(path)), ".htm")
+
+	title := meta["title"]
+	if title == "" && isHtml {
+		if m := htmlTitleRegex.FindStringSubmatch(body); m != nil {
+			title = strings.TrimSpace(gohtml.UnescapeString(tagRegex.ReplaceAllString(m[1]+m[2], "")))
+		}
+	} else if title == "" {
+		scanner := bufio.NewScanner(strings.NewReader(body))
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "# ") {
+				title = strings.TrimSpace(strings.TrimPrefix(line, "# "))
+				break
+			}
+		}
+	}
+	if title == "" {
+		title = strings.TrimSuffix(info.Name(), filepath.Ext(path))
+	}
+
+	modified := info.ModTime()
+	published := modified
+	for _, layout := range frontMatterDates {
+		if t, err := time.ParseInLocation(layout, meta["date"], time.Local); err == nil {
+			published = t
+			break
+		}
+	}
+
+	content := markdownParagraphs(body)
+	if isHtml {
+		content = body
+		if m := htmlBodyRegex.FindStringSubmatch(body); m != nil {
+			content = m[1]
+		}
+	}
+	link := &url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	item := &gofeed.Item{
+		Title:           title,
+		Link:            link.String(),
+		GUID:            link.String(),
+		Content:         content,
+		Description:     meta["description"],
+		PublishedParsed: &published,
+	}
+	if modified.After(published) {
+		item.UpdatedParsed = &modified
+	}
+	if tags := meta["tags"]; tags != "" {
+		for _, t := range strings.Split(strings.Trim(tags, "[]"), ",") {
+			if t = strings.Trim(strings.TrimSpace(t), `"'`); t != "" {
+				item.Categories = append(item.Categories, t)
+			}
+		}
+	}
+	return item, nil
+}
+
+// Split off YAML (---) or TOML (+++) front matter, reading its top level
+// "key: value" or "key = value" lines
+func splitFrontMatter(contents string) (map[string]string, string) {
+	meta := map[string]string{}
+	contents = strings.TrimPrefix(contents, "\ufeff")
+	fence := ""
+	for _, f := range []string{"---", "+++"} {
+		if strings.HasPrefix(contents, f+"\n") || strings.HasPrefix(contents, f+"\r\n") {
+			fence = f
+		}
+	}
+	if fence == "" {
+		return meta, contents
+	}
+
+	lines := strings.Split(contents, "\n")
+	for i := 1; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r")
+		if line == fence {
+			return meta, strings.Join(lines[i+1:], "\n")
+		}
+		sep := ":"
+		if fence == "+++" {
+			sep = "="
+		}
+		kv := strings.SplitN(line, sep, 2)
+		if len(kv) != 2 || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		meta[key] = strings.Trim(strings.TrimSpace(kv[1]), `"'`)
+	}
+	// Never closed, so not front matter
+	return map[string]string{}, contents
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var errLocked = errors.New("locked")
+
+// Take an exclusive lock on the state directory so overlapping runs (e.g. cron
+// and a manual run) don't clobber each other's cache and state. If another
+// run holds it, waits for it when wait is set, otherwise returns errLocked.
+// Returns a func to release the lock.
+func lockState(wait bool) (func(), error) {
+	path, err := statePath("lock")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	unlock, err := lockFile(path, false)
+	if err == errLocked && wait {
+		fmt.Fprintf(os.Stderr, "Waiting for another picofeed to finish\n")
+		unlock, err = lockFile(path, true)
+	}
+	return unlock, err
+}
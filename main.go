@@ -2,19 +2,25 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	gohtml "html"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
 	"github.com/pkg/browser"
 	"github.com/pkg/errors"
 	flag "github.com/spf13/pflag"
@@ -22,21 +28,144 @@ import (
 
 const VERSION = "1.1"
 const FETCH_TIMEOUT = 10 * time.Second
+const FAVICON_MAX_AGE = 7 * 24 * time.Hour
+const READING_WPM = 200
 
 var (
-	html = flag.Bool("html", false, "Render feed as html to stdout")
-	web  = flag.Bool("web", false, "Display feed in browser")
+	output    = flag.String("output", "text", "Output format: text, html, json, jsonl, org, ics or gemtext")
+	html      = flag.Bool("html", false, "Render feed as html to stdout, same as --output html")
+	web       = flag.Bool("web", false, "Display feed in browser")
+	jsonOut   = flag.Bool("json", false, "Render feed as json to stdout, same as --output json")
+	long      = flag.Bool("long", false, "Show feed, author, categories and reading time under each post")
+	print0    = flag.Bool("print0", false, "Write each post's link (or --columns) ended by a NUL instead of text output, for xargs -0")
+	fieldSep  = flag.String("field-sep", `\t`, "Separator between --columns for --print0 or --record-sep, with \\0 \\t and \\n escapes")
+	recordSep = flag.String("record-sep", `\n`, "Write each post's link (or --columns) ended by this instead of text output, with \\0 \\t and \\n escapes")
+	columns   = flag.String("columns", "", "Columns of text output and their order, from date, time, feed, title, link, author, categories, lang and minutes, each with an optional :width, e.g. date,feed:12,title,link")
+	images    = flag.Bool("images", false, "Show each post's image inline in text output, in terminals with kitty, iTerm2 or sixel graphics")
+	pick      = flag.Bool("pick", false, "Pick posts to open in the browser from a searchable list")
+	openFlag  = flag.String("open", "", "Open the newest N posts in the browser instead of showing them, or all of them with \"all\", e.g. --open 5 --feed hn")
+	saveTo    = flag.String("save-to", "", "Service for save and --pick to save posts to: instapaper, linkding, shiori or wallabag")
+	notesDir  = flag.String("notes-dir", "", "Directory for save and --pick to write posts to as Markdown notes, e.g. ~/notes/feeds")
+
+	since      = flag.String("since", "90d", "Hide posts older than this, e.g. 2w or 2024-01-01, or \"all\" to show every post however old")
+	allPosts   = flag.Bool("all", false, "Show every post however old, same as --since all")
+	feedFilter = flag.StringSlice("feed", nil, "Only show posts from these feeds, by alias, host or url")
+	skip       = flag.StringSlice("skip", nil, "Don't fetch these feeds this run, by alias, host or url")
+	minWords   = flag.Int("min-words", 0, "Hide posts with fewer words, posts without content are always shown")
+	maxMinutes = flag.Int("max-minutes", 0, "Hide posts taking longer to read, posts without content are always shown")
+	langs      = flag.StringSlice("lang", nil, "Only show posts in these languages, e.g. en,de, posts whose language can't be told are always shown")
+	authors    = flag.StringSlice("author", nil, "Only show posts by these authors, matching any part of their name, posts without an author are always shown")
+	skipAuthor = flag.StringSlice("skip-author", nil, "Hide posts by these authors, matching any part of their name")
+	categories = flag.StringSlice("category", nil, "Only show posts in one of these categories, ignoring case, posts without categories are always shown")
+	skipCat    = flag.StringSlice("skip-category", nil, "Hide posts in any of these categories, ignoring case")
+	minScore   = flag.Int("min-score", 0, "Hide posts scoring less than this by the config's score rules")
+	rank       = flag.Bool("rank", false, "Sort posts by the config's score rules, highest first, instead of by date")
+	maxPerFeed = flag.Int("max-items-per-feed", 0, "Only read each feed's newest posts, this many at most, 0 for all, so feeds shipping their whole archive stay small. --since still hides older ones")
+	collapse   = flag.Bool("collapse-similar", false, "Collapse posts from different feeds with near identical titles into one, listing where else they were published")
+	whereFlag  = flag.String("where", "", "Only show posts matching an expression, e.g. 'feed.host == \"lobste.rs\" && age < 48h'")
+
+	pageSize   = flag.Int("page-size", 100, "Posts per page in html output and build's json, 0 to render all at once")
+	groupSize  = flag.Int("group-size", 0, "Posts shown under each date in html output before the rest are behind \"show more\", 0 to show them all")
+	foldFeed   = flag.Int("fold-feed", 10, "Fold a feed's posts under each date in html output after this many, 0 to never fold")
+	theme      = flag.String("theme", "auto", "Html color theme: auto, light, dark, solarized or solarized-dark")
+	css        = flag.String("css", "", "Css file to inline into html output, or url of a stylesheet to link")
+	cards      = flag.Bool("cards", false, "Fetch each post's OpenGraph image and description to show preview cards in html output")
+	thumbnails = flag.Bool("thumbnails", false, "Show each post's image as a thumbnail in html output")
+	bundlesDir = flag.String("bundles-dir", "", "With --output hugo-data, also write each post as a Hugo page bundle in this directory, e.g. content/blogroll")
+
+	summarize      = flag.Bool("summarize", false, "Show a two sentence summary of each post from a language model in --long and html output, see --summarize-url")
+	summarizeUrl   = flag.String("summarize-url", "http://localhost:11434/api/generate", "Ollama /api/generate or OpenAI style /v1/chat/completions endpoint for --summarize")
+	summarizeModel = flag.String("summarize-model", "llama3.2", "Model for --summarize")
+
+	noUnshorten = flag.Bool("no-unshorten", false, "Don't expand links to url shorteners like bit.ly and t.co")
+	canonical   = flag.Bool("canonical", false, "Resolve post links to their canonical url, following redirects and rel=canonical")
+
+	alias          = flag.String("alias", "", "Short name for add to give the feed")
+	title          = flag.String("title", "", "Display name for add to give the feed, instead of its own title")
+	saveDiscovered = flag.Bool("save-discovered", false, "Replace page urls in feeds files with the feeds autodiscovery found for them")
+	bookmarks      = flag.String("bookmarks", "", "Browser bookmarks export (bookmarks.html) for discover to look for feeds for")
+	allFeeds       = flag.Bool("all-feeds", false, "Use every feed a page links to, rather than just its main one")
+
+	hostConcurrency = flag.Int("host-concurrency", 4, "Max requests in flight to each host, 0 for unlimited")
+	hostRate        = flag.Float64("host-rate", 0, "Max requests per second to each host, 0 for unlimited")
+	userAgent       = flag.String("user-agent", "", "User-Agent to fetch with, instead of picofeed/VERSION. Feeds can override it in the config")
+	doh             = flag.String("doh", "", "Resolve feed hosts with this DNS-over-HTTPS server, e.g. https://cloudflare-dns.com/dns-query")
+	ipv4            = flag.Bool("ipv4", false, "Only connect to feeds over IPv4")
+	ipv6            = flag.Bool("ipv6", false, "Only connect to feeds over IPv6")
+	maxRate         = flag.String("max-rate", "", "Max download rate across all fetches in bytes per second, e.g. 500k or 2M")
+
+	listen      = flag.String("listen", "localhost:8080", "Address for serve or proxy to listen on, empty to disable http")
+	auth        = flag.String("auth", "", "Require basic auth for serve and proxy, as user:password or just user to read the password from the keyring's serve account")
+	tlsDomains  = flag.StringSlice("tls-domain", nil, "Serve https with a Let's Encrypt certificate for these domains, e.g. feeds.example.com, with --listen :443")
+	tlsCert     = flag.String("tls-cert", "", "Certificate file for serve to serve https with, along with --tls-key")
+	tlsKey      = flag.String("tls-key", "", "Private key file for --tls-cert")
+	gemini      = flag.String("gemini", "", "Address for serve to also listen for gemini requests on, e.g. :1965")
+	interval    = flag.Duration("interval", 15*time.Minute, "How often serve refetches feeds, and proxy at most fetches each feed")
+	maxInterval = flag.Duration("max-interval", 24*time.Hour, "Longest serve waits to refetch a feed that rarely posts, --interval to refetch every feed as often")
+
+	apiDir  = flag.String("api", "", "Directory for build to write a static JSON API of the posts to, e.g. ./public/api")
+	perFeed = flag.Bool("per-feed", false, "Show heatmap's grid for each feed instead of all posts together")
+	rounds  = flag.Int("rounds", 3, "How many times bench fetches each feed")
+
+	maxAge  = flag.Duration("max-age", 0, "Use cached feeds fetched within this long without a request, e.g. 1h")
+	offline = flag.Bool("offline", false, "Render only from cache, without any network requests")
+	wait    = flag.Bool("wait", false, "Wait for another running picofeed to finish instead of failing")
+	noLock  = flag.Bool("no-lock", false, "Don't lock the cache and state against other running picofeeds")
+
+	dryRun       = flag.Bool("dry-run", false, "List the feeds that would be fetched and their settings from the config, without fetching anything")
+	retryFailing = flag.Bool("retry-failing", false, "Fetch feeds that have failed several runs in a row, rather than waiting to retry them")
+
+	stale        = flag.Bool("stale", false, "List feeds without posts since the --since cutoff after the posts, in text and html output")
+	quietIfEmpty = flag.Bool("quiet-if-empty", false, "Print nothing at all if there are no posts since the last run, e.g. for cron")
+	verbose      = flag.BoolP("verbose", "v", false, "Show a live table of each feed's status and time taken while fetching, instead of a line per feed fetched")
 )
 
 func init() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, `Usage:
-  picofeed takes feed urls or files of newline separated urls
+  picofeed takes feed urls or files of newline separated urls. With no
+  arguments it reads ~/.config/picofeed/feeds
 
   Examples:
+	picofeed --web
 	picofeed feeds.txt --web
 	picofeed http://seenaburns.com/feed.xml
 	picofeed http://seenaburns.com/feed.xml feeds.txt http://example.com/feed.xml
+	picofeed serve feeds.txt --listen localhost:8080
+	picofeed serve feeds.txt --listen :8080 --auth me
+	picofeed serve feeds.txt --listen :443 --tls-domain feeds.example.com
+	picofeed proxy --listen :8081 --auth me
+	picofeed heatmap feeds.txt --per-feed
+	picofeed build feeds.txt --api ./public/api
+	picofeed bench feeds.txt --rounds 3
+	picofeed validate http://seenaburns.com/feed.xml
+	picofeed snapshot feeds.txt > old.json
+	picofeed diff old.json
+	picofeed add http://seenaburns.com/feed.xml --alias seena
+	picofeed remove seena
+	picofeed restore
+	picofeed discover https://example.com/blogroll
+	picofeed discover --bookmarks bookmarks.html
+	picofeed backup picofeed.tar.zst
+	picofeed restore picofeed.tar.zst
+	picofeed mute seena
+	picofeed --skip seena
+	picofeed --images
+	picofeed --long --summarize
+	picofeed --rank --min-score 5
+	picofeed --collapse-similar
+	picofeed --since 2w
+	picofeed --since all
+	picofeed --all
+	picofeed --stale
+	picofeed --pick
+	picofeed --pick --save-to wallabag
+	picofeed save https://example.com/article --save-to instapaper
+	picofeed --pick --notes-dir ~/notes/feeds
+	picofeed --max-age 1h
+	picofeed --quiet-if-empty
+	picofeed --dry-run
+	picofeed completion bash|zsh|fish
 
   Flags:
 `)
@@ -50,30 +179,560 @@ func main() {
 	ctx := context.Background()
 
 	flag.Parse()
+	if err := applyEnvFlags(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
 
 	feedsList := flag.Args()
-	if len(feedsList) == 0 {
-		fmt.Fprintf(os.Stderr, "ERROR: No feed provided\n\n")
-		flag.Usage()
+	if len(feedsList) > 0 && feedsList[0] == "version" {
+		fmt.Fprintf(os.Stderr, "%s\n", VERSION)
+		return
+	}
+
+	var err error
+	config, err = loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
 		os.Exit(1)
 	}
 
-	if feedsList[0] == "version" {
-		fmt.Fprintf(os.Stderr, "%s\n", VERSION)
+	if len(feedsList) > 0 && feedsList[0] == "completion" {
+		if len(feedsList) != 2 {
+			fmt.Fprintf(os.Stderr, "ERROR: Expected a shell: picofeed completion bash|zsh|fish\n")
+			os.Exit(1)
+		}
+		if err := renderCompletion(os.Stdout, feedsList[1]); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
 		return
 	}
 
-	feeds := []*url.URL{}
-	for _, f := range feedsList {
-		newFeeds, err := parseFeedArg(f)
+	if len(feedsList) > 0 && contains([]string{"add", "remove", "mute", "unmute"}, feedsList[0]) {
+		if len(feedsList) != 2 {
+			fmt.Fprintf(os.Stderr, "ERROR: Expected one feed: picofeed %s <url>\n", feedsList[0])
+			os.Exit(1)
+		}
+		switch feedsList[0] {
+		case "add":
+			err = addFeed(ctx, feedsList[1], *alias, *title)
+		case "remove":
+			err = removeFeed(feedsList[1])
+		default:
+			err = muteFeed(feedsList[1], feedsList[0] == "mute")
+		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Couldn't parse %q as a url or a file of newline separated urls: %v\n", f, err)
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(feedsList) > 0 && feedsList[0] == "backup" {
+		if len(feedsList) != 2 {
+			fmt.Fprintf(os.Stderr, "ERROR: Expected where to back up to: picofeed backup out.tar.zst\n")
+			os.Exit(1)
+		}
+		// Don't archive state another picofeed is halfway through saving
+		unlock, err := lockState(*wait)
+		if err == nil {
+			err = writeBackup(feedsList[1])
+			unlock()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(feedsList) > 0 && feedsList[0] == "restore" {
+		if len(feedsList) > 2 {
+			fmt.Fprintf(os.Stderr, "ERROR: Expected at most one backup: picofeed restore [backup.opml|backup.tar.zst]\n")
+			os.Exit(1)
+		}
+		path := ""
+		if len(feedsList) == 2 {
+			path = feedsList[1]
+		}
+		if isBackupArchive(path) {
+			unlock, err := lockState(*wait)
+			if err == nil {
+				err = restoreBackup(path)
+				unlock()
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		if err := restoreFeeds(path); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(feedsList) > 0 && feedsList[0] == "discover" {
+		if *bookmarks != "" && len(feedsList) == 1 {
+			limiter = newHostLimiter(*hostConcurrency, *hostRate)
+			if err := discoverBookmarkFeeds(ctx, os.Stdout, *bookmarks); err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		if len(feedsList) != 2 {
+			fmt.Fprintf(os.Stderr, "ERROR: Expected a page of links or bookmarks: picofeed discover <url> or --bookmarks bookmarks.html\n")
+			os.Exit(1)
+		}
+		u, err := url.Parse(feedsList[1])
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			fmt.Fprintf(os.Stderr, "ERROR: %q is not an http url\n", feedsList[1])
+			os.Exit(1)
+		}
+		limiter = newHostLimiter(*hostConcurrency, *hostRate)
+		if err := discoverLinkedFeeds(ctx, os.Stdout, u); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(feedsList) > 0 && feedsList[0] == "save" {
+		if len(feedsList) != 2 || (*saveTo == "" && *notesDir == "") {
+			fmt.Fprintf(os.Stderr, "ERROR: Expected a link and where to save it: picofeed save <link> --save-to %s or --notes-dir <dir>\n", strings.Join(saveServiceNames(), "|"))
+			os.Exit(1)
+		}
+		if err := savePost(ctx, &Post{Link: feedsList[1]}); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *saveTo != "" && !contains(saveServiceNames(), *saveTo) {
+		fmt.Fprintf(os.Stderr, "ERROR: Unknown service %q, expected one of %s\n", *saveTo, strings.Join(saveServiceNames(), ", "))
+		os.Exit(1)
+	}
+
+	serveMode := false
+	if len(feedsList) > 0 && feedsList[0] == "serve" {
+		serveMode = true
+		feedsList = feedsList[1:]
+	}
+	proxyMode := false
+	if len(feedsList) > 0 && feedsList[0] == "proxy" {
+		proxyMode = true
+		feedsList = feedsList[1:]
+	}
+	heatmapMode := false
+	if len(feedsList) > 0 && feedsList[0] == "heatmap" {
+		heatmapMode = true
+		feedsList = feedsList[1:]
+	}
+	benchMode := false
+	if len(feedsList) > 0 && feedsList[0] == "bench" {
+		benchMode = true
+		feedsList = feedsList[1:]
+	}
+	validateMode := false
+	if len(feedsList) > 0 && feedsList[0] == "validate" {
+		validateMode = true
+		feedsList = feedsList[1:]
+	}
+	buildMode := false
+	if len(feedsList) > 0 && feedsList[0] == "build" {
+		buildMode = true
+		feedsList = feedsList[1:]
+		if *apiDir == "" {
+			fmt.Fprintf(os.Stderr, "ERROR: Expected where to build to: picofeed build --api ./public/api\n")
+			os.Exit(1)
+		}
+	}
+	snapshotMode := false
+	if len(feedsList) > 0 && feedsList[0] == "snapshot" {
+		snapshotMode = true
+		feedsList = feedsList[1:]
+	}
+	// Snapshot to compare the feeds now against
+	var diffFrom *snapshot
+	if len(feedsList) > 0 && feedsList[0] == "diff" {
+		if len(feedsList) != 2 && len(feedsList) != 3 {
+			fmt.Fprintf(os.Stderr, "ERROR: Expected snapshots: picofeed diff old.json [new.json]\n")
+			os.Exit(1)
+		}
+		snaps := []*snapshot{}
+		for _, path := range feedsList[1:] {
+			snap, err := readSnapshot(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+				os.Exit(1)
+			}
+			snaps = append(snaps, snap)
+		}
+		if len(snaps) == 2 {
+			renderDiff(os.Stdout, snaps[0], snaps[1])
+			return
+		}
+		// Refetch the same feeds
+		diffFrom = snaps[0]
+		feedsList = diffFrom.Feeds
+	}
+
+	if len(feedsList) == 0 && !proxyMode {
+		feedsList = envFeeds()
+	}
+	if len(feedsList) == 0 && !proxyMode {
+		// Fall back to the default feeds file if there is one
+		path, err := configPath("feeds")
+		if _, statErr := os.Stat(path); err != nil || statErr != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: No feed provided, and no default feeds file %q\n\n", path)
+			flag.Usage()
+			os.Exit(1)
+		}
+		feedsList = []string{path}
+	}
+
+	limiter = newHostLimiter(*hostConcurrency, *hostRate)
+	if *maxRate != "" {
+		rate, err := parseRate(*maxRate)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		bandwidth = newRateLimiter(rate)
+	}
+	dohUrl = *doh
+	if *ipv4 && *ipv6 {
+		fmt.Fprintf(os.Stderr, "ERROR: Only one of --ipv4 and --ipv6 can be used\n")
+		os.Exit(1)
+	} else if *ipv4 {
+		ipVersion = "4"
+	} else if *ipv6 {
+		ipVersion = "6"
+	}
+
+	if !*noLock {
+		unlock, err := lockState(*wait)
+		if err == errLocked {
+			fmt.Fprintf(os.Stderr, "ERROR: Another picofeed is running, wait for it with --wait or skip locking with --no-lock\n")
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		defer unlock()
+	}
+
+	state, err = loadState()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+
+	hooks, err = loadHooks()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	plugins, err = loadPlugins()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+
+	if proxyMode {
+		proxyAuth, err := parseServeAuth(*auth)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		if err := proxy(ctx, *listen, proxyAuth); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	format := *output
+	if *html {
+		format = "html"
+	} else if *jsonOut {
+		format = "json"
+	}
+	if !contains(outputFormats, format) {
+		fmt.Fprintf(os.Stderr, "ERROR: Unknown output format %q\n", format)
+		os.Exit(1)
+	}
+
+	cutoff, err = parseSince(*since)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	// The heatmap covers a year, not just recent posts
+	if *allPosts || (heatmapMode && !flag.CommandLine.Changed("since")) {
+		cutoff = nil
+	}
+
+	textColumns, err = parseColumns(*columns)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	// Feeds files can follow others' OPML, which is only read from the cache
+	if *dryRun {
+		*offline = true
+	}
+	openCount, err = parseOpen(*openFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *whereFlag != "" {
+		where, err = compileWhere(*whereFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if _, ok := themes[*theme]; !ok && *theme != "auto" {
+		fmt.Fprintf(os.Stderr, "ERROR: Unknown theme %q\n", *theme)
+		os.Exit(1)
+	}
+
+	opts := htmlOptions{PageSize: *pageSize, Theme: *theme, Thumbnails: *thumbnails, GroupSize: *groupSize, FoldFeed: *foldFeed}
+	if *css != "" {
+		if u, err := url.Parse(*css); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			opts.CssLink = *css
+		} else {
+			contents, err := ioutil.ReadFile(*css)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: Couldn't read css: %v\n", err)
+				os.Exit(1)
+			}
+			opts.Css = string(contents)
+		}
+	}
+
+	feeds, err := parseFeedArgs(feedsList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if *dryRun {
+		// follow-opml lines as last fetched
+		feeds = followOpmls(withCacheOnly(ctx), feeds)
+		kept := skipFeeds(feeds)
+		renderDryRun(os.Stdout, kept)
+		fmt.Fprintf(os.Stderr, "%d feeds, %d skipped by --skip or mute, nothing fetched with --dry-run\n", len(kept), len(feeds)-len(kept))
+		return
+	}
+
+	if serveMode {
+		serveAuth, err := parseServeAuth(*auth)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		tlsConfig, err := serveTlsConfig(*tlsDomains, *tlsCert, *tlsKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		err = serve(ctx, feeds, opts, serveOptions{
+			Listen:      *listen,
+			Gemini:      *gemini,
+			Interval:    *interval,
+			MaxInterval: *maxInterval,
+			Cards:       *cards,
+			Summarize:   *summarize,
+			Canonical:   *canonical,
+			Unshorten:   !*noUnshorten,
+			FeedArgs:    feedsList,
+			Auth:        serveAuth,
+			TLS:         tlsConfig,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// On the first interrupt stop fetching and render what's been fetched so
+	// far, a second one exits as usual
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		signal.Stop(interrupts)
+		cancel()
+	}()
+	feeds = skipFeeds(followOpmls(ctx, feeds))
+
+	if format == "jsonl" {
+		streamJsonl(ctx, os.Stdout, feeds)
+		if err := state.save(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: failed saving state: %v\n", err)
+		}
+		return
+	}
+
+	if validateMode {
+		problems := 0
+		for i, f := range feeds {
+			if i > 0 {
+				fmt.Printf("\n")
+			}
+			n, err := validateFeed(ctx, os.Stdout, f)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed validating %q: %v\n", f, err)
+				n = 1
+			}
+			problems += n
+		}
+		if err := state.save(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: failed saving state: %v\n", err)
+		}
+		if problems > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if benchMode {
+		renderBench(os.Stdout, benchFeeds(ctx, feeds, *rounds))
+		return
+	}
+
+	if snapshotMode || diffFrom != nil {
+		posts := filterPosts(transformPosts(fetchAll(ctx, feeds)))
+		if err := state.save(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: failed saving state: %v\n", err)
+		}
+		snap := newSnapshot(feeds, posts, time.Now())
+		if diffFrom != nil {
+			renderDiff(os.Stdout, diffFrom, snap)
+		} else {
+			writeSnapshot(os.Stdout, snap)
+		}
+		return
+	}
+
+	if buildMode {
+		posts := filterPosts(transformPosts(fetchAll(ctx, feeds)))
+		if err := state.save(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: failed saving state: %v\n", err)
+		}
+		dir, err := expandHome(*apiDir)
+		if err == nil {
+			err = buildApi(dir, posts, *pageSize)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if heatmapMode {
+		posts := filterPosts(transformPosts(fetchAll(ctx, feeds)))
+		if err := state.save(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: failed saving state: %v\n", err)
+		}
+		maps := heatmaps(posts, *perFeed, time.Now())
+		if *web {
+			f, err := ioutil.TempFile("", "picoweb.*.html")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to make temp file: %v", err)
+				os.Exit(1)
+			}
+			defer f.Close()
+
+			renderHeatmapsHtml(f, maps, opts)
+			_ = browser.OpenFile(f.Name())
+		} else if format == "html" {
+			renderHeatmapsHtml(os.Stdout, maps, opts)
+		} else {
+			renderHeatmaps(os.Stdout, maps)
+		}
+		return
+	}
+
+	release := func(bool) {}
+	if *quietIfEmpty {
+		release, err = holdStderr()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
 			os.Exit(1)
 		}
-		feeds = append(feeds, newFeeds...)
 	}
 
 	posts := fetchAll(ctx, feeds)
+	fixLinks(ctx, posts)
+	posts = filterPosts(transformPosts(posts))
+	unseen := state.markSeen(posts)
+	if err := state.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: failed saving state: %v\n", err)
+	}
+	if *saveDiscovered {
+		if err := saveDiscoveredFeeds(feedsList); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: failed saving discovered feeds: %v\n", err)
+		}
+	}
+	hooks.onPosts(unseen)
+
+	release(len(unseen) > 0)
+	if *quietIfEmpty && len(unseen) == 0 {
+		return
+	}
+	// Marked read in TT-RSS only once they've been shown, so not when
+	// picofeed exits on an error first or is interrupted. Every post shown,
+	// not only unseen ones: TT-RSS sources only give unread articles, so any
+	// left unread by an earlier run are marked now.
+	defer func() {
+		if ctx.Err() == nil {
+			ttrssMarkRead(ctx, posts)
+		}
+	}()
+
+	// Don't start fetching favicons and cards after an interrupt
+	if (*web || format == "html") && ctx.Err() == nil {
+		opts.Favicons = fetchFavicons(ctx, posts)
+		if *cards {
+			opts.Cards = fetchCards(ctx, posts)
+		}
+	}
+	if *stale {
+		opts.Stale, opts.StaleSince = staleFeeds(feeds)
+	}
+	if *summarize && !*pick && (*web || format == "html" || (format == "text" && *long)) && ctx.Err() == nil {
+		opts.Summaries = fetchSummaries(ctx, posts)
+	}
+
+	if *pick {
+		if err := pickPosts(ctx, posts); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if openCount != 0 {
+		if err := openPosts(posts, openCount); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if *web {
 		f, err := ioutil.TempFile("", "picoweb.*.html")
 		if err != nil {
@@ -82,79 +741,203 @@ func main() {
 		}
 		defer f.Close()
 
-		renderHtml(f, posts, "Jan 2006")
+		renderHtml(f, posts, "Jan 2006", opts)
 
 		_ = browser.OpenFile(f.Name())
-	} else if *html {
-		renderHtml(os.Stdout, posts, "Jan 2006")
-	} else {
-		render(posts, "Jan 2006")
+		return
+	}
+
+	switch format {
+	case "html":
+		renderHtml(os.Stdout, posts, "Jan 2006", opts)
+	case "json":
+		renderJson(os.Stdout, posts)
+	case "org":
+		renderOrg(os.Stdout, posts, "Jan 2006")
+	case "ics":
+		renderIcs(os.Stdout, posts)
+	case "gemtext":
+		renderGemtext(os.Stdout, posts, "Jan 2006")
+	case "hugo-data":
+		renderHugoData(os.Stdout, posts)
+		if *bundlesDir != "" {
+			for _, p := range posts {
+				if _, err := writeBundle(*bundlesDir, p); err != nil {
+					fmt.Fprintf(os.Stderr, "ERROR: failed writing bundle for %s: %v\n", p.Link, err)
+				}
+			}
+		}
+	default:
+		if *print0 || flag.CommandLine.Changed("record-sep") || flag.CommandLine.Changed("field-sep") {
+			recordEnd := unescapeSeparator(*recordSep)
+			if *print0 {
+				recordEnd = "\x00"
+			}
+			renderDelimited(os.Stdout, posts, textColumns, unescapeSeparator(*fieldSep), recordEnd)
+			return
+		}
+		var termImages map[string]string
+		if *images && ctx.Err() == nil {
+			termImages = fetchTerminalImages(ctx, posts)
+		}
+		render(posts, "Jan 2006", *long, termImages, opts.Summaries)
+		if len(opts.Stale) > 0 {
+			fmt.Printf("\n")
+			renderStale(os.Stdout, opts.Stale, opts.StaleSince)
+		}
+	}
+}
+
+var outputFormats = []string{"text", "html", "json", "jsonl", "org", "ics", "gemtext", "hugo-data"}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
 	}
+	return false
 }
 
-func render(posts []*Post, dateFormat string) {
+// Images are escapes drawing a post's image and summaries its --summarize
+// summary, by its link
+func render(posts []*Post, dateFormat string, long bool, images map[string]string, summaries map[string]string) {
 	grouped := groupByDate(posts, dateFormat)
 
+	headings := !hasDateColumn(textColumns)
 	for _, group := range grouped {
 		for i, p := range group {
-			if i == 0 {
-				fmt.Printf("%s\n", p.Timestamp.Format(dateFormat))
+			if i == 0 && headings {
+				fmt.Printf("%s\n", groupHeading(p, dateFormat))
 			}
-			if len(p.Title) > 70 {
-				fmt.Printf("    %v\n", p.Title)
+			title := p.displayTitle()
+			if textColumns != nil {
+				indent := ""
+				if headings {
+					indent = "    "
+				}
+				fmt.Printf("%s%s\n", indent, formatColumns(p, textColumns))
+			} else if len(title) > 70 {
+				fmt.Printf("    %v\n", title)
 				fmt.Printf("    %70v %s\n", "", p.Link)
 			} else {
-				fmt.Printf("    %-70v %s\n", p.Title, p.Link)
+				fmt.Printf("    %-70v %s\n", title, p.Link)
+			}
+			if long {
+				fmt.Printf("        %s\n", strings.Join(p.details(), " · "))
+				if summary, ok := summaries[p.Link]; ok {
+					for _, line := range wrapWords(paneBlock{words: paneText(summary, "")}, 72) {
+						fmt.Printf("        %s\n", line)
+					}
+				}
+			}
+			for _, a := range p.Alternates {
+				fmt.Printf("        also %s %s\n", a.FeedTitle, a.Link)
+			}
+			if image, ok := images[p.Link]; ok {
+				fmt.Printf("        %s\n", image)
 			}
 		}
 	}
 }
 
-func renderHtml(f io.Writer, posts []*Post, dateFormat string) {
-	fmt.Fprintf(f, `<!DOCTYPE html>
-<head>
-<title>Picofeed</title>
-<style>
-body {
-	margin: 0 auto;
-	padding: 2em 0px;
-	max-width: 800px;
-	color: #888;
-	font-family: -apple-system,system-ui,BlinkMacSystemFont,"Segoe UI",Roboto,"Helvetica Neue",Arial,sans-serif;
-	font-size: 14px;
-	line-height: 1.4em;
+func renderJson(f io.Writer, posts []*Post) {
+	sortPosts(posts)
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(posts)
 }
-h4   {color: #000;}
-a {color: #000;}
-a:visited {color: #888;}
-</style>
-</head>
-<body>
-<h4 style="padding-bottom: 2em">Picofeed</h4>
-`)
 
-	grouped := groupByDate(posts, dateFormat)
+type Post struct {
+	Title     string     `json:"title"`
+	Link      string     `json:"link"`
+	Timestamp *time.Time `json:"timestamp"`
+	FeedLink  string     `json:"feed_link"`
+	FeedTitle string     `json:"feed_title"`
+	FeedAlias string     `json:"feed_alias,omitempty"`
+	GUID      string     `json:"guid,omitempty"`
+	// The item's author, if the feed names them
+	Author string `json:"author,omitempty"`
+	// The item's own categories (tags), unlike Tags which are its feed's
+	Categories []string `json:"categories,omitempty"`
+	// Seen on a previous run with different content
+	Updated bool `json:"updated,omitempty"`
+	// The item's own updated time if it's after Timestamp, otherwise when
+	// Updated was noticed
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	// The feed's tags from its config, or its own categories, given to
+	// bookmarks of the post
+	Tags []string `json:"tags,omitempty"`
+	// ISO 639-1 code told from the post's text, or the feed's language, see
+	// detectLanguage
+	Lang string `json:"lang,omitempty"`
+	// Near duplicates from other feeds, with --collapse-similar
+	Alternates []*Alternate `json:"alternates,omitempty"`
 
-	for _, group := range grouped {
-		for i, p := range group {
-			if i == 0 {
-				fmt.Fprintf(f, "<h4>%s</h4>\n", p.Timestamp.Format(dateFormat))
-			}
-			fmt.Fprintf(f, "<div><a href=\"%s\">%s</a> (%s)</div>\n", p.Link, p.Title, p.shortFeedLink())
-		}
+	// Item content, or its summary if the feed doesn't include content
+	Content        string `json:"-"`
+	Words          int    `json:"words,omitempty"`
+	ReadingMinutes int    `json:"reading_minutes,omitempty"`
+
+	// Image to show next to the post, see itemThumbnail
+	Thumbnail string `json:"thumbnail,omitempty"`
+
+	// From the RSS event module (ev:startdate etc.) when present
+	EventStart    *time.Time `json:"event_start,omitempty"`
+	EventEnd      *time.Time `json:"event_end,omitempty"`
+	EventLocation string     `json:"event_location,omitempty"`
+}
+
+var tagRegex = regexp.MustCompile(`<[^>]*>`)
+
+// Title with a marker if it's been edited since it was first seen
+func (p *Post) displayTitle() string {
+	if p.Updated {
+		return p.Title + " (updated)"
 	}
+	return p.Title
+}
 
-	fmt.Fprintf(f, `</body>
-</html>
-`)
+// Set Words and ReadingMinutes from Content
+func (p *Post) countWords() {
+	text := gohtml.UnescapeString(tagRegex.ReplaceAllString(p.Content, " "))
+	p.Words = len(strings.Fields(text))
+	if p.Words > 0 {
+		// Round up, nothing takes 0 minutes
+		p.ReadingMinutes = (p.Words + READING_WPM - 1) / READING_WPM
+	}
 }
 
-type Post struct {
-	Title     string
-	Link      string
-	Timestamp *time.Time
-	FeedLink  string
-	FeedTitle string
+// Feed title and reading time, whichever are known
+func (p *Post) details() []string {
+	details := []string{p.feedName()}
+	if p.Author != "" && p.Author != p.feedName() {
+		details = append(details, "by "+p.Author)
+	}
+	if len(p.Categories) > 0 {
+		details = append(details, strings.Join(p.Categories, ", "))
+	}
+	if p.Words > 0 {
+		details = append(details, fmt.Sprintf("%d words", p.Words), fmt.Sprintf("%d min read", p.ReadingMinutes))
+	}
+	return details
+}
+
+// Feed title, or host if the feed doesn't have one
+func (p *Post) feedName() string {
+	if p.FeedTitle != "" {
+		return p.FeedTitle
+	}
+	return p.shortFeedName()
+}
+
+// Feed alias, or host if it doesn't have one
+func (p *Post) shortFeedName() string {
+	if p.FeedAlias != "" {
+		return p.FeedAlias
+	}
+	return p.shortFeedLink()
 }
 
 func (p *Post) shortFeedLink() string {
@@ -162,6 +945,26 @@ func (p *Post) shortFeedLink() string {
 	if err != nil {
 		return ""
 	}
+	// Nostr pubkeys by the start of their npub
+	if strings.HasPrefix(u.Opaque, "npub1") && len(u.Opaque) > 16 {
+		return u.Opaque[:16] + "…"
+	}
+	// Bluesky custom feeds by their name
+	if strings.HasPrefix(u.Opaque, "at://") {
+		return u.Opaque[strings.LastIndex(u.Opaque, "/")+1:]
+	}
+	// Local directories by their name
+	if u.Scheme == "file" {
+		return filepath.Base(filepath.FromSlash(u.Path))
+	}
+	// Sources wrapping a url, like git:https://..., by its host
+	if inner, err := url.Parse(u.Opaque); err == nil && inner.Host != "" {
+		return inner.Host
+	}
+	// Accounts like ap:user@host by their host, bsky:@handle by the handle
+	if u.Host == "" && u.Opaque != "" {
+		return u.Opaque[strings.LastIndex(u.Opaque, "@")+1:]
+	}
 
 	return u.Host
 }
@@ -178,17 +981,18 @@ func (posts ByTimestamp) Less(i, j int) bool {
 }
 
 // Return list of lists of posts, where each given list has the same date
-// E.g. [Dec 2018 -> []*Post, Nov 2018 -> []*Post, ...]
+// E.g. [Dec 2018 -> []*Post, Nov 2018 -> []*Post, ...], or the same score
+// with --rank (see groupHeading)
 // Mutates posts (sorts) before running
 func groupByDate(posts []*Post, dateFormat string) [][]*Post {
-	sort.Sort(ByTimestamp{posts})
+	sortPosts(posts)
 
 	// Initialize with 1 list
 	grouped := [][]*Post{[]*Post{}}
 
 	lastDate := ""
 	for _, p := range posts {
-		date := p.Timestamp.Format(dateFormat)
+		date := groupHeading(p, dateFormat)
 		if date != lastDate {
 			// New date, make new list
 			grouped = append(grouped, []*Post{})
@@ -200,59 +1004,259 @@ func groupByDate(posts []*Post, dateFormat string) [][]*Post {
 	return grouped
 }
 
-// Fetch list of feeds in parallel, aggregate results
+// Fetch list of feeds in parallel, aggregate results. If ctx is cancelled,
+// returns the posts of feeds fetched so far.
 func fetchAll(ctx context.Context, feeds []*url.URL) []*Post {
-	ctxTimeout, timeoutCancel := context.WithTimeout(ctx, FETCH_TIMEOUT)
-	defer timeoutCancel()
+	posts := []*Post{}
+	fetchEach(ctx, feeds, func(feedPosts []*Post) {
+		posts = append(posts, feedPosts...)
+	})
+	return posts
+}
 
+// Fetch list of feeds in parallel, calling onFeed with each feed's posts as
+// soon as it's fetched. Calls to onFeed aren't concurrent.
+func fetchEach(ctx context.Context, feeds []*url.URL, onFeed func([]*Post)) {
 	var wg sync.WaitGroup
-	postChan := make(chan *Post, 10000)
+	var mu sync.Mutex
+	var skipped int32
+	failing := []string{}
+	due := []*url.URL{}
 	for _, f := range feeds {
+		h := state.health(f.String())
+		if retry, ok := h.retryAt(); ok && time.Now().Before(retry) && !*retryFailing {
+			failing = append(failing, fmt.Sprintf("  %s: failed %d runs in a row, retrying %s, last error: %s", f, h.Failures, retry.Format("Jan 2 15:04"), h.LastError))
+			continue
+		}
+		due = append(due, f)
+	}
+
+	var progress *fetchProgress
+	logError := func(format string, args ...interface{}) {
+		fmt.Fprintf(os.Stderr, format, args...)
+	}
+	if *verbose {
+		progress = newFetchProgress(due)
+		logError = progress.logf
+	}
+	for i, f := range due {
 		wg.Add(1)
-		go func(feed *url.URL) {
+		go func(i int, feed *url.URL) {
 			defer wg.Done()
 
-			feedData, err := fetchFeed(ctxTimeout, feed, 0)
+			fc := config.feed(feed)
+			ctxTimeout, timeoutCancel := context.WithTimeout(ctx, fc.timeout())
+			defer timeoutCancel()
+			posts := []*Post{}
+			var err error
+			if progress != nil {
+				ctxTimeout = progress.track(ctxTimeout, i)
+				defer func() { progress.finish(i, len(posts), err) }()
+			}
+
+			feedData, err := fetchFeed(ctxTimeout, feed, fc, 0)
+			if err != nil && ctx.Err() != nil {
+				atomic.AddInt32(&skipped, 1)
+				return
+			}
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "ERROR: failed fetching feed %q: %v\n", feed, err)
+				logError("ERROR: failed fetching feed %q: %v\n", feed, err)
+				hooks.onFeedError(feed.String(), err)
+				if !*offline && !cacheOnly(ctx) {
+					state.recordFetch(feed.String(), nil, err)
+				}
 				return
 			}
 
-			posts, err := parseFeed(feed, feedData)
+			posts, err = parseFeed(feed, feedData, fc)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "ERROR: failed reading feed data %q: %v\n", feed, err)
+				logError("ERROR: failed reading feed data %q: %v\n", feed, err)
+				hooks.onFeedError(feed.String(), err)
 			}
-
-			for _, p := range posts {
-				postChan <- p
+			if !*offline && !cacheOnly(ctx) {
+				state.recordFetch(feed.String(), posts, err)
 			}
-		}(f)
+
+			mu.Lock()
+			defer mu.Unlock()
+			onFeed(posts)
+		}(i, f)
 	}
 	wg.Wait()
-	close(postChan)
+	if progress != nil {
+		progress.close()
+	}
 
-	posts := []*Post{}
-	for p := range postChan {
-		posts = append(posts, p)
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "Interrupted, skipped %d of %d feeds\n", skipped, len(feeds))
+	}
+	if len(failing) > 0 {
+		fmt.Fprintf(os.Stderr, "Skipped %d failing feeds, fetch them anyway with --retry-failing:\n%s\n", len(failing), strings.Join(failing, "\n"))
 	}
-	return posts
 }
 
 // Fetch a single feed into a list of posts
-func fetchFeed(ctx context.Context, feedUrl *url.URL, depth int) (*gofeed.Feed, error) {
+func fetchFeed(ctx context.Context, feedUrl *url.URL, fc *FeedConfig, depth int) (*gofeed.Feed, error) {
+	if feedUrl.Scheme == "gemini" {
+		if *offline {
+			return nil, errors.New("Gemini feeds aren't cached, and --offline")
+		}
+		return fetchGeminiFeed(ctx, feedUrl)
+	}
+	if isTtrss(feedUrl) {
+		return fetchTtrssFeed(ctx, feedUrl, fc)
+	}
+	if isActivityPub(feedUrl) {
+		return fetchActivityPubFeed(ctx, feedUrl, fc)
+	}
+	if isBluesky(feedUrl) {
+		return fetchBlueskyFeed(ctx, feedUrl, fc)
+	}
+	if isNostr(feedUrl) {
+		return fetchNostrFeed(ctx, feedUrl, fc)
+	}
+	if isImap(feedUrl) {
+		return fetchImapFeed(ctx, feedUrl, fc)
+	}
+	if isGit(feedUrl) {
+		return fetchGitFeed(ctx, feedUrl, fc)
+	}
+	if isLocalDir(feedUrl) {
+		return fetchLocalDirFeed(feedUrl)
+	}
+	if isChangelog(feedUrl) {
+		return fetchChangelogFeed(ctx, feedUrl, fc)
+	}
+	if bridged, err := config.bridged(feedUrl); err != nil {
+		return nil, err
+	} else if bridged != nil {
+		return fetchFeed(ctx, bridged, fc, depth)
+	}
+
+	if discovered, ok := state.discovered(feedUrl.String()); ok && depth == 0 && !*allFeeds {
+		feed, err := fetchFeed(ctx, discovered, fc, 1)
+		if err == nil {
+			return feed, nil
+		}
+		// Maybe it moved, look again
+		state.setDiscovered(feedUrl.String(), nil)
+	}
+
 	feedParser := gofeed.NewParser()
 
-	client := &http.Client{}
-	req, _ := http.NewRequest("GET", feedUrl.String(), nil)
-	req.Header.Set("User-Agent", fmt.Sprintf("picofeed/%s", VERSION))
+	if feedUrl.Scheme == "webcal" {
+		https := *feedUrl
+		https.Scheme = "https"
+		feedUrl = &https
+	}
+	contents, err := fetchUrl(ctx, feedUrl, fc)
+	if err != nil {
+		return nil, err
+	}
+	if isIcs(contents) {
+		return parseIcs(feedUrl, contents), nil
+	}
+
+	feed, err := feedParser.ParseString(string(contents))
+	if err == gofeed.ErrFeedTypeNotDetected && depth == 0 && !fc.NoAutodiscover {
+		// User possibly tried to pass in a non-feed page, try to look for link to feed in header
+		// If found, recurse
+		candidates := feedLinks(feedUrl, string(contents))
+		if len(candidates) > 1 && *allFeeds {
+			return fetchDiscoveredFeeds(ctx, feedUrl, candidates, fc)
+		}
+		if len(candidates) > 0 {
+			newFeed := candidates[0].URL
+			if len(candidates) > 1 {
+				fmt.Fprintf(os.Stderr, "Autodiscovering feed %q for %q, the first of %d it links to, see --all-feeds\n", newFeed, feedUrl, len(candidates))
+			} else {
+				fmt.Fprintf(os.Stderr, "Autodiscovering feed %q for %q\n", newFeed, feedUrl)
+			}
+			feed, err := fetchFeed(ctx, newFeed, fc, 1)
+			if err == nil {
+				state.setDiscovered(feedUrl.String(), newFeed)
+			}
+			return feed, err
+		}
+		probed, feed := probeFeedPaths(ctx, feedUrl, fc)
+		if feed == nil {
+			return nil, fmt.Errorf("Feed type not recognized, could not extract feed from <head> or find one at %s", strings.Join(WELL_KNOWN_FEED_PATHS, ", "))
+		}
+		fmt.Fprintf(os.Stderr, "Found feed %q for %q\n", probed, feedUrl)
+		state.setDiscovered(feedUrl.String(), probed)
+		return feed, nil
+	}
+
+	return feed, err
+}
+
+// GET u with fc's headers, returning the response body. Responses are cached
+// and reused without a request until their Cache-Control max-age, otherwise
+// revalidated with a conditional request. After a 429 or 503 the host's
+// Retry-After is respected, across runs, serving the cached body if there is
+// one in the meantime. --max-age and --offline reuse cached bodies regardless.
+func fetchUrl(ctx context.Context, u *url.URL, fc *FeedConfig) ([]byte, error) {
+	now := time.Now()
+	entry := readCache(u.String())
+	if entry != nil && (*offline || cacheOnly(ctx) || now.Before(entry.Expires) || now.Sub(entry.Fetched) < *maxAge) {
+		return entry.Body, nil
+	}
+	if *offline {
+		return nil, errors.New("Not cached, and --offline")
+	}
+	if until, ok := state.backoff(u.String()); ok {
+		if entry != nil {
+			fmt.Fprintf(os.Stderr, "Using cached %q, backing off until %s\n", u, until.Format(time.Kitchen))
+			return entry.Body, nil
+		}
+		return nil, fmt.Errorf("Backing off until %s", until.Format(time.Kitchen))
+	}
+
+	req, _ := http.NewRequest("GET", u.String(), nil)
+	for name, values := range fc.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("User-Agent", fc.userAgent())
+	if entry != nil {
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
 	req = req.WithContext(ctx)
 
-	resp, err := client.Do(req)
+	release, err := limiter.acquire(ctx, u.Host)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	resp, err := doRequest(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		until := retryAfter(resp, now)
+		state.setBackoff(u.String(), until)
+		if entry != nil {
+			fmt.Fprintf(os.Stderr, "Using cached %q, %s until %s\n", u, resp.Status, until.Format(time.Kitchen))
+			return entry.Body, nil
+		}
+		return nil, fmt.Errorf("Unexpected status code: %s, backing off until %s", resp.Status, until.Format(time.Kitchen))
+	}
+	state.setBackoff(u.String(), time.Time{})
+
+	if resp.StatusCode == http.StatusNotModified && entry != nil {
+		if entry.update(resp, now) {
+			_ = writeCache(u.String(), entry)
+		}
+		return entry.Body, nil
+	}
+
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		return nil, fmt.Errorf("Unexpected status code: %s", resp.Status)
 	}
@@ -262,53 +1266,26 @@ func fetchFeed(ctx context.Context, feedUrl *url.URL, depth int) (*gofeed.Feed,
 		return nil, errors.Wrapf(err, "Failed reading response body")
 	}
 
-	feed, err := feedParser.ParseString(string(contents))
-	if err == gofeed.ErrFeedTypeNotDetected && depth == 0 {
-		// User possibly tried to pass in a non-feed page, try to look for link to feed in header
-		// If found, recurse
-		newFeed := extractFeedLink(feedUrl, string(contents))
-		if newFeed == nil {
-			return nil, errors.New("Feed type not recognized, could not extract feed from <head>")
+	entry = &cacheEntry{Body: contents}
+	if entry.update(resp, now) {
+		if err := writeCache(u.String(), entry); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed caching %q: %v\n", u, err)
 		}
-		fmt.Fprintf(os.Stderr, "Autodiscovering feed %q for %q\n", newFeed, feedUrl)
-		return fetchFeed(ctx, newFeed, 1)
 	}
-
-	return feed, err
+	return contents, nil
 }
 
-func extractFeedLink(baseUrl *url.URL, contents string) *url.URL {
-	regexes := []string{
-		`\s*<link.*type="application/rss\+xml.*href="([^"]*)"`,
-		`\s*<link.*type="application/atom\+xml.*href="([^"]*)"`,
-	}
-
-	for _, r := range regexes {
-		re := regexp.MustCompile(r)
-		matches := re.FindStringSubmatch(contents)
-		if len(matches) > 1 {
-			if strings.HasPrefix(matches[1], "/") {
-				// relative path
-				newUrl := *baseUrl
-				newUrl.Path = matches[1]
-				return &newUrl
-			}
-
-			u, err := url.Parse(matches[1])
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Autodetected %q for %q but could not parse url", matches[1], baseUrl)
-				continue
-			}
-			return u
-		}
+func parseFeed(feedUrl *url.URL, feed *gofeed.Feed, fc *FeedConfig) ([]*Post, error) {
+	items := feed.Items
+	if fc.MaxItems > 0 && len(items) > fc.MaxItems {
+		items = newestItems(items, fc.MaxItems)
+	}
+	if *maxPerFeed > 0 && len(items) > *maxPerFeed {
+		items = newestItems(items, *maxPerFeed)
 	}
 
-	return nil
-}
-
-func parseFeed(feedUrl *url.URL, feed *gofeed.Feed) ([]*Post, error) {
 	posts := []*Post{}
-	for _, i := range feed.Items {
+	for _, i := range items {
 		t := i.PublishedParsed
 		if i.PublishedParsed == nil {
 			if i.UpdatedParsed != nil {
@@ -319,27 +1296,144 @@ func parseFeed(feedUrl *url.URL, feed *gofeed.Feed) ([]*Post, error) {
 			}
 		}
 
-		posts = append(posts, &Post{
-			Title:     i.Title,
-			Link:      i.Link,
-			Timestamp: t,
-			FeedTitle: feed.Title,
-			FeedLink:  feedUrl.String(),
-		})
+		content := i.Content
+		if content == "" {
+			content = i.Description
+		}
+
+		tags := fc.Tags
+		if tags == nil {
+			tags = feed.Categories
+		}
+
+		feedTitle := feed.Title
+		if fc.Title != "" {
+			feedTitle = fc.Title
+		}
+		// Items from a reader like TT-RSS name the feed they're from
+		if t := i.Custom["feed_title"]; t != "" {
+			feedTitle = t
+		}
+
+		p := &Post{
+			Title:      i.Title,
+			Link:       i.Link,
+			Timestamp:  t,
+			FeedTitle:  feedTitle,
+			FeedLink:   feedUrl.String(),
+			FeedAlias:  fc.Alias,
+			Tags:       tags,
+			GUID:       i.GUID,
+			Author:     itemAuthor(i),
+			Categories: itemCategories(i),
+			Content:    content,
+		}
+		if i.PublishedParsed != nil && i.UpdatedParsed != nil && i.UpdatedParsed.After(*i.PublishedParsed) {
+			p.UpdatedAt = i.UpdatedParsed
+		}
+		p.countWords()
+		if p.Lang = detectLanguage(i.Title, content); p.Lang == "" {
+			p.Lang = feedLanguage(feed.Language)
+		}
+		p.Thumbnail = itemThumbnail(i, content)
+		p.EventStart = parseEventTime(extensionValue(i.Extensions, "ev", "startdate"))
+		p.EventEnd = parseEventTime(extensionValue(i.Extensions, "ev", "enddate"))
+		p.EventLocation = extensionValue(i.Extensions, "ev", "location")
+		posts = append(posts, p)
 	}
 
-	fmt.Fprintf(os.Stderr, "Fetched %q: %d posts\n", feedUrl, len(feed.Items))
+	if !*verbose {
+		fmt.Fprintf(os.Stderr, "Fetched %q: %d posts\n", feedUrl, len(feed.Items))
+	}
 
 	return posts, nil
 }
 
+// The n most recently published (or updated) items, for feeds shipping their
+// whole archive. Items without a date go last.
+func newestItems(items []*gofeed.Item, n int) []*gofeed.Item {
+	date := func(i *gofeed.Item) *time.Time {
+		if i.PublishedParsed != nil {
+			return i.PublishedParsed
+		}
+		return i.UpdatedParsed
+	}
+	sorted := append([]*gofeed.Item{}, items...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := date(sorted[i]), date(sorted[j])
+		return a != nil && (b == nil || a.After(*b))
+	})
+	return sorted[:n]
+}
+
+// The item's author's name, or their email if it's all the feed gives
+func itemAuthor(i *gofeed.Item) string {
+	if i.Author == nil {
+		return ""
+	}
+	if name := strings.TrimSpace(i.Author.Name); name != "" {
+		return name
+	}
+	return strings.TrimSpace(i.Author.Email)
+}
+
+// The item's categories, without blanks or repeats
+func itemCategories(i *gofeed.Item) []string {
+	categories := []string{}
+	for _, c := range i.Categories {
+		if c = strings.TrimSpace(c); c != "" && !contains(categories, c) {
+			categories = append(categories, c)
+		}
+	}
+	if len(categories) == 0 {
+		return nil
+	}
+	return categories
+}
+
+// Value of the first <prefix:name> element on an item, "" if missing
+func extensionValue(extensions ext.Extensions, prefix string, name string) string {
+	values := extensions[prefix][name]
+	if len(values) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(values[0].Value)
+}
+
+func parseEventTime(s string) *time.Time {
+	if s == "" {
+		return nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+func parseFeedArgs(feedsList []string) ([]*url.URL, error) {
+	feeds := []*url.URL{}
+	for _, f := range feedsList {
+		newFeeds, err := parseFeedArg(f)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't parse %q as a url or a file of newline separated urls: %v", f, err)
+		}
+		feeds = append(feeds, newFeeds...)
+	}
+	return feeds, nil
+}
+
 // If feed is a path to a file, attempt to read it as a newline separated list of urls
 // Otherwise try parsing as a url itself
 func parseFeedArg(feed string) ([]*url.URL, error) {
+	if u, ok := localDirUrl(feed); ok && looksLikePath(feed) {
+		return []*url.URL{u}, nil
+	}
 	f, err := os.Stat(feed)
 	if os.IsNotExist(err) || (err == nil && !f.Mode().IsRegular()) {
-		// feed is not a file, treat as url
-		u, err := url.Parse(feed)
+		// feed is not a file, treat as url or alias
+		u, err := config.resolve(feed)
 		if err != nil {
 			return nil, errors.Wrapf(err, "%q is not a file, url.Parse() failed", feed)
 		}
@@ -347,18 +1441,81 @@ func parseFeedArg(feed string) ([]*url.URL, error) {
 	}
 
 	// feed is a file, read as newline separated urls
-	contents, err := ioutil.ReadFile(feed)
+	return parseFeedsFile(feed, map[string]bool{})
+}
+
+// Read a file of newline separated urls. Lines of the form "include <path>"
+// read another feeds file, relative to this one, and may be a glob pattern.
+// "follow-opml <url>" lines subscribe to every feed in a published OPML, and
+// are resolved by followOpmls when fetching. visited holds files already read
+// to stop include cycles.
+func parseFeedsFile(path string, visited map[string]bool) ([]*url.URL, error) {
+	abs, err := filepath.Abs(path)
 	if err != nil {
-		return nil, errors.Wrapf(err, "ReadFile(%q)", feed)
+		return nil, err
+	}
+	if visited[abs] {
+		return nil, nil
+	}
+	visited[abs] = true
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ReadFile(%q)", path)
 	}
 	lines := strings.Split(string(contents), "\n")
 
 	urls := []*url.URL{}
 	for _, l := range lines {
+		l = strings.TrimSpace(l)
 		if l == "" {
 			continue
 		}
-		u, err := url.Parse(l)
+
+		if strings.HasPrefix(l, "include ") {
+			pattern := strings.TrimSpace(strings.TrimPrefix(l, "include "))
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(filepath.Dir(abs), pattern)
+			}
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Bad include pattern %q in %q", pattern, path)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("Include %q in %q matched no files", pattern, path)
+			}
+			for _, m := range matches {
+				included, err := parseFeedsFile(m, visited)
+				if err != nil {
+					return nil, err
+				}
+				urls = append(urls, included...)
+			}
+			continue
+		}
+
+		if strings.HasPrefix(l, "follow-opml ") {
+			u, err := url.Parse(strings.TrimSpace(strings.TrimPrefix(l, "follow-opml ")))
+			if err != nil {
+				return nil, errors.Wrapf(err, "Bad follow-opml url in %q", path)
+			}
+			urls = append(urls, followOpmlUrl(u))
+			continue
+		}
+
+		// Directories, relative to this file
+		if looksLikePath(l) {
+			dir := l
+			if !filepath.IsAbs(dir) && !strings.HasPrefix(dir, "~/") {
+				dir = filepath.Join(filepath.Dir(abs), dir)
+			}
+			if u, ok := localDirUrl(dir); ok {
+				urls = append(urls, u)
+				continue
+			}
+		}
+
+		u, err := config.resolve(l)
 		if err != nil {
 			return nil, errors.Wrapf(err, "url.Parse(%q)", l)
 		}
@@ -367,3 +1524,38 @@ func parseFeedArg(feed string) ([]*url.URL, error) {
 
 	return urls, nil
 }
+
+// Path within picofeed's config directory ($XDG_CONFIG_HOME/picofeed),
+// parent directories are not created
+func configPath(elem ...string) (string, error) {
+	return xdgPath("XDG_CONFIG_HOME", ".config", "config", elem)
+}
+
+// Path within picofeed's cache directory ($XDG_CACHE_HOME/picofeed)
+func cachePath(elem ...string) (string, error) {
+	return xdgPath("XDG_CACHE_HOME", ".cache", "cache", elem)
+}
+
+// Path within picofeed's state directory ($XDG_STATE_HOME/picofeed)
+func statePath(elem ...string) (string, error) {
+	return xdgPath("XDG_STATE_HOME", filepath.Join(".local", "state"), "state", elem)
+}
+
+// Path within the picofeed directory of an XDG base directory, falling back
+// to the spec's default under $HOME when env is unset. $PICOFEED_DATA_DIR
+// replaces them all, with a subdirectory named dataDir for each.
+func xdgPath(env string, fallback string, dataDir string, elem []string) (string, error) {
+	if dir := os.Getenv(ENV_PREFIX + "DATA_DIR"); dir != "" {
+		return filepath.Join(append([]string{dir, dataDir}, elem...)...), nil
+	}
+	dir := os.Getenv(env)
+	// The spec says relative paths are invalid and should be ignored
+	if dir == "" || !filepath.IsAbs(dir) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.Wrapf(err, "Could not find $%s or home directory", env)
+		}
+		dir = filepath.Join(home, fallback)
+	}
+	return filepath.Join(append([]string{dir, "picofeed"}, elem...)...), nil
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	gohtml "html"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/pkg/errors"
+	"golang.org/x/net/websocket"
+)
+
+// NIP-01 profile metadata and NIP-23 long-form articles
+const NOSTR_KIND_METADATA = 0
+const NOSTR_KIND_ARTICLE = 30023
+
+const NOSTR_LIMIT = 50
+
+// Articles are linked to on a web gateway, by their naddr
+const NOSTR_GATEWAY = "https://njump.me/"
+
+// Relays asked when the source doesn't name any
+var nostrRelays = []string{"wss://relay.damus.io", "wss://nos.lol", "wss://relay.nostr.band"}
+
+// Nostr pubkeys' long-form articles, asked of the relays in the query or the
+// default ones:
+//
+//	nostr:npub1...
+//	nostr:npub1...?relay=wss://relay.example.com&relay=wss://nos.lol
+//
+// Events are checked against their ids and signatures, so relays can't pass
+// off articles as the pubkey's.
+func isNostr(u *url.URL) bool {
+	return u.Scheme == "nostr"
+}
+
+type nostrEvent struct {
+	ID        string     `json:"id"`
+	Pubkey    string     `json:"pubkey"`
+	CreatedAt int64      `json:"created_at"`
+	Kind      int        `json:"kind"`
+	Tags      [][]string `json:"tags"`
+	Content   string     `json:"content"`
+	Sig       string     `json:"sig"`
+}
+
+// Whether the event's id is the hash of its contents, and it's signed by its
+// pubkey
+func (e *nostrEvent) verify() bool {
+	id := sha256.Sum256([]byte(e.serialize()))
+	if hex.EncodeToString(id[:]) != strings.ToLower(e.ID) {
+		return false
+	}
+	return schnorrVerify(mustHex(e.Pubkey), id[:], mustHex(e.Sig))
+}
+
+// NIP-01's serialization the id is hashed from, [0,pubkey,created_at,kind,tags,content]
+// as compact JSON with only its few escapes, which encoding/json doesn't keep to
+func (e *nostrEvent) serialize() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "[0,%s,%d,%d,[", nostrString(e.Pubkey), e.CreatedAt, e.Kind)
+	for i, t := range e.Tags {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteByte('[')
+		for j, v := range t {
+			if j > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(nostrString(v))
+		}
+		sb.WriteByte(']')
+	}
+	sb.WriteString("]," + nostrString(e.Content) + "]")
+	return sb.String()
+}
+
+func nostrString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\f':
+			sb.WriteString(`\f`)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// The first value of the event's tag named name
+func (e *nostrEvent) tag(name string) string {
+	for _, t := range e.Tags {
+		if len(t) >= 2 && t[0] == name {
+			return t[1]
+		}
+	}
+	return ""
+}
+
+func fetchNostrFeed(ctx context.Context, feedUrl *url.URL, fc *FeedConfig) (*gofeed.Feed, error) {
+	if *offline {
+		return nil, errors.New("Nostr feeds aren't cached, and --offline")
+	}
+	pubkey, err := nostrPubkey(feedUrl.Opaque)
+	if err != nil {
+		return nil, err
+	}
+	relays := feedUrl.Query()["relay"]
+	if len(relays) == 0 {
+		relays = nostrRelays
+	}
+
+	// Ask every relay, an article is on any of them
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	latest := map[string]*nostrEvent{}
+	var profile *nostrEvent
+	var errs []string
+	for _, relay := range relays {
+		wg.Add(1)
+		go func(relay string) {
+			defer wg.Done()
+			events, err := nostrQuery(ctx, relay, pubkey, fc)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", relay, err))
+				return
+			}
+			// Articles are replaceable, the newest version of each is kept
+			for _, e := range events {
+				if e.Kind == NOSTR_KIND_METADATA {
+					if profile == nil || e.CreatedAt > profile.CreatedAt {
+						profile = e
+					}
+					continue
+				}
+				d := e.tag("d")
+				if prev, ok := latest[d]; !ok || e.CreatedAt > prev.CreatedAt {
+					latest[d] = e
+				}
+			}
+		}(relay)
+	}
+	wg.Wait()
+	if len(errs) == len(relays) {
+		return nil, fmt.Errorf("No relay answered: %s", strings.Join(errs, ", "))
+	}
+
+	npub, _ := bech32Encode("npub", mustHex(pubkey))
+	feed := &gofeed.Feed{Title: npub, Link: NOSTR_GATEWAY + npub, Items: []*gofeed.Item{}}
+	if profile != nil {
+		metadata := struct {
+			Name        string `json:"name"`
+			DisplayName string `json:"display_name"`
+		}{}
+		if json.Unmarshal([]byte(profile.Content), &metadata) == nil {
+			if metadata.DisplayName != "" {
+				feed.Title = metadata.DisplayName
+			} else if metadata.Name != "" {
+				feed.Title = metadata.Name
+			}
+		}
+	}
+	for d, e := range latest {
+		published := time.Unix(e.CreatedAt, 0)
+		if at, err := strconv.ParseInt(e.tag("published_at"), 10, 64); err == nil {
+			published = time.Unix(at, 0)
+		}
+		updated := time.Unix(e.CreatedAt, 0)
+		item := &gofeed.Item{
+			Title:           e.tag("title"),
+			Link:            NOSTR_GATEWAY + nostrAddr(d, pubkey, relays[0]),
+			GUID:            fmt.Sprintf("%d:%s:%s", NOSTR_KIND_ARTICLE, pubkey, d),
+			Description:     e.tag("summary"),
+			Content:         markdownParagraphs(e.Content),
+			PublishedParsed: &published,
+			UpdatedParsed:   &updated,
+		}
+		if item.Title == "" {
+			item.Title = noteTitle(item.Content)
+		}
+		if image := e.tag("image"); image != "" {
+			item.Image = &gofeed.Image{URL: image}
+		}
+		feed.Items = append(feed.Items, item)
+	}
+	return feed, nil
+}
+
+// Articles and the profile of pubkey (hex) on a relay, read until it's sent
+// all it has stored
+func nostrQuery(ctx context.Context, relay string, pubkey string, fc *FeedConfig) ([]*nostrEvent, error) {
+	ws, err := nostrDial(ctx, relay, fc)
+	if err != nil {
+		return nil, err
+	}
+	defer ws.Close()
+	stop := context.AfterFunc(ctx, func() { ws.Close() })
+	defer stop()
+
+	sub := "picofeed"
+	req := []interface{}{"REQ", sub, map[string]interface{}{
+		"kinds":   []int{NOSTR_KIND_ARTICLE},
+		"authors": []string{pubkey},
+		"limit":   NOSTR_LIMIT,
+	}, map[string]interface{}{
+		"kinds":   []int{NOSTR_KIND_METADATA},
+		"authors": []string{pubkey},
+		"limit":   1,
+	}}
+	if err := websocket.JSON.Send(ws, req); err != nil {
+		return nil, err
+	}
+	defer websocket.JSON.Send(ws, []interface{}{"CLOSE", sub})
+
+	events := []*nostrEvent{}
+	for {
+		var msg []json.RawMessage
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			return nil, err
+		}
+		var typ string
+		if len(msg) == 0 || json.Unmarshal(msg[0], &typ) != nil {
+			continue
+		}
+		switch typ {
+		case "EVENT":
+			e := &nostrEvent{}
+			if len(msg) < 3 || json.Unmarshal(msg[2], e) != nil {
+				continue
+			}
+			if (e.Kind == NOSTR_KIND_ARTICLE || e.Kind == NOSTR_KIND_METADATA) && e.Pubkey == pubkey && e.verify() {
+				events = append(events, e)
+			}
+		case "EOSE":
+			return events, nil
+		case "CLOSED":
+			var reason string
+			if len(msg) > 2 {
+				json.Unmarshal(msg[2], &reason)
+			}
+			return nil, fmt.Errorf("Subscription closed: %s", reason)
+		}
+	}
+}
+
+// Open a websocket to the relay over dialContext, so proxies and DoH apply
+func nostrDial(ctx context.Context, relay string, fc *FeedConfig) (*websocket.Conn, error) {
+	u, err := url.Parse(relay)
+	if err != nil {
+		return nil, err
+	}
+	port := u.Port()
+	switch {
+	case u.Scheme == "wss" && port == "":
+		port = "443"
+	case u.Scheme == "ws" && port == "":
+		port = "80"
+	case u.Scheme != "wss" && u.Scheme != "ws":
+		return nil, fmt.Errorf("Expected a ws:// or wss:// relay, got %q", relay)
+	}
+	wsConfig, err := websocket.NewConfig(relay, "https://"+u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	wsConfig.Header.Set("User-Agent", fc.userAgent())
+
+	conn, err := dialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return nil, err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(fc.timeout())
+	}
+	conn.SetDeadline(deadline)
+	if u.Scheme == "wss" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	ws, err := websocket.NewClient(wsConfig, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ws, nil
+}
+
+// A pubkey as hex, from an npub or hex
+func nostrPubkey(s string) (string, error) {
+	if strings.HasPrefix(s, "npub1") {
+		hrp, data, err := bech32Decode(s)
+		if err != nil || hrp != "npub" || len(data) != 32 {
+			return "", fmt.Errorf("Bad npub %q", s)
+		}
+		return hex.EncodeToString(data), nil
+	}
+	if b, err := hex.DecodeString(s); err == nil && len(b) == 32 {
+		return strings.ToLower(s), nil
+	}
+	return "", fmt.Errorf("Expected an npub or hex pubkey like nostr:npub1..., got %q", s)
+}
+
+// The article's NIP-19 naddr, its d tag, relay, author and kind as TLV
+func nostrAddr(d string, pubkey string, relay string) string {
+	tlv := []byte{}
+	add := func(t byte, v []byte) {
+		tlv = append(tlv, t, byte(len(v)))
+		tlv = append(tlv, v...)
+	}
+	add(0, []byte(d))
+	add(1, []byte(relay))
+	add(2, mustHex(pubkey))
+	kind := make([]byte, 4)
+	binary.BigEndian.PutUint32(kind, NOSTR_KIND_ARTICLE)
+	add(3, kind)
+	naddr, _ := bech32Encode("naddr", tlv)
+	return naddr
+}
+
+func mustHex(s string) []byte {
+	b, _ := hex.DecodeString(s)
+	return b
+}
+
+// Markdown as escaped html paragraphs, enough for it to read in the html and
+// text renders
+func markdownParagraphs(s string) string {
+	paragraphs := []string{}
+	for _, p := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			paragraphs = append(paragraphs, "<p>"+gohtml.EscapeString(p)+"</p>")
+		}
+	}
+	return strings.Join(paragraphs, "\n")
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+func bech32Polymod(values []byte) uint32 {
+	gen := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HrpExpand(hrp string) []byte {
+	out := []byte{}
+	for _, c := range hrp {
+		out = append(out, byte(c>>5))
+	}
+	out = append(out, 0)
+	for _, c := range hrp {
+		out = append(out, byte(c&31))
+	}
+	return out
+}
+
+// Regroup bits, between bytes and bech32's 5 bit groups
+func convertBits(data []byte, from, to uint, pad bool) ([]byte, error) {
+	acc, bits := uint32(0), uint(0)
+	out := []byte{}
+	maxv := uint32(1)<<to - 1
+	for _, b := range data {
+		acc = acc<<from | uint32(b)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad && bits > 0 {
+		out = append(out, byte(acc<<(to-bits)&maxv))
+	} else if !pad && (bits >= from || acc<<(to-bits)&maxv != 0) {
+		return nil, errors.New("Bad padding")
+	}
+	return out, nil
+}
+
+func bech32Decode(s string) (string, []byte, error) {
+	s = strings.ToLower(s)
+	sep := strings.LastIndex(s, "1")
+	if sep < 1 || sep+7 > len(s) {
+		return "", nil, errors.New("Bad bech32")
+	}
+	hrp := s[:sep]
+	data := []byte{}
+	for _, c := range s[sep+1:] {
+		i := strings.IndexRune(bech32Charset, c)
+		if i < 0 {
+			return "", nil, errors.New("Bad bech32 character")
+		}
+		data = append(data, byte(i))
+	}
+	if bech32Polymod(append(bech32HrpExpand(hrp), data...)) != 1 {
+		return "", nil, errors.New("Bad bech32 checksum")
+	}
+	decoded, err := convertBits(data[:len(data)-6], 5, 8, false)
+	return hrp, decoded, err
+}
+
+func bech32Encode(hrp string, data []byte) (string, error) {
+	values, err := convertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	polymod := bech32Polymod(append(append(bech32HrpExpand(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	for i := 0; i < 6; i++ {
+		values = append(values, byte(polymod>>uint(5*(5-i))&31))
+	}
+	var sb strings.Builder
+	sb.WriteString(hrp + "1")
+	for _, v := range values {
+		sb.WriteByte(bech32Charset[v])
+	}
+	return sb.String(), nil
+}
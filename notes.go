@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// Longest slug of a post's title in its note's file name
+const NOTE_SLUG_LENGTH = 60
+
+var slugRegex = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+var whitespaceRegex = regexp.MustCompile(`\s+`)
+
+// Write the post as a Markdown note in dir, named by its date and title, with
+// YAML front matter for Obsidian and the like. An existing note for the post
+// is overwritten. Returns the note's path.
+func writeNote(dir string, p *Post) (string, error) {
+	dir, err := expandHome(dir)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, postSlug(p)+".md")
+	return path, ioutil.WriteFile(path, []byte(postMarkdown(p, "url")), 0644)
+}
+
+// Write the post as a Hugo page bundle in dir, a directory named like its note
+// holding index.md. Its link goes in "link", Hugo takes "url" as the page's
+// own path.
+func writeBundle(dir string, p *Post) (string, error) {
+	dir, err := expandHome(dir)
+	if err != nil {
+		return "", err
+	}
+	bundle := filepath.Join(dir, postSlug(p))
+	if err := os.MkdirAll(bundle, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(bundle, "index.md")
+	return path, ioutil.WriteFile(path, []byte(postMarkdown(p, "link")), 0644)
+}
+
+// Paths starting ~/ are in the home directory, for when the shell didn't
+// expand them (e.g. --notes-dir=~/notes)
+func expandHome(path string) (string, error) {
+	if !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[2:]), nil
+}
+
+// The post's date and title as a file name, or a hash of its link if it has
+// no title
+func postSlug(p *Post) string {
+	slug := strings.Trim(slugRegex.ReplaceAllString(strings.ToLower(p.Title), "-"), "-")
+	slug = strings.TrimRight(truncate(slug, NOTE_SLUG_LENGTH), "-")
+	if slug == "" {
+		sum := sha1.Sum([]byte(p.Link))
+		slug = hex.EncodeToString(sum[:])[:12]
+	}
+	if p.Timestamp != nil {
+		slug = p.Timestamp.Format("2006-01-02") + "-" + slug
+	}
+	return slug
+}
+
+// Strings as JSON, which YAML reads as double quoted strings
+func yamlQuote(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// The post's content as Markdown under YAML front matter, with its link under
+// linkKey
+func postMarkdown(p *Post, linkKey string) string {
+	var b strings.Builder
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "title: %s\n", yamlQuote(p.Title))
+	fmt.Fprintf(&b, "%s: %s\n", linkKey, yamlQuote(p.Link))
+	if p.Timestamp != nil {
+		fmt.Fprintf(&b, "date: %s\n", p.Timestamp.Format("2006-01-02T15:04:05Z07:00"))
+	}
+	if p.FeedLink != "" {
+		fmt.Fprintf(&b, "feed: %s\n", yamlQuote(p.feedName()))
+		fmt.Fprintf(&b, "feed_url: %s\n", yamlQuote(p.FeedLink))
+	}
+	fmt.Fprintf(&b, "tags: %s\n", yamlQuote(append([]string{}, p.Tags...)))
+	b.WriteString("---\n\n")
+
+	title := p.Title
+	if title == "" {
+		title = p.Link
+	}
+	fmt.Fprintf(&b, "# [%s](%s)\n", title, p.Link)
+	if content := htmlToMarkdown(p.Content); content != "" {
+		b.WriteString("\n" + content + "\n")
+	}
+	return b.String()
+}
+
+// Convert feed html to Markdown, keeping paragraphs, headings, lists, quotes,
+// code, emphasis, links and images. Other tags are dropped, keeping their text.
+func htmlToMarkdown(s string) string {
+	var b strings.Builder
+	// Open lists, "ul" or "ol", and the next number in each
+	lists := []string{}
+	olNumbers := []int{}
+	quoteDepth := 0
+	pre := false
+	links := []string{}
+
+	newline := func() {
+		b.WriteString("\n" + strings.Repeat("> ", quoteDepth))
+	}
+	block := func() {
+		newline()
+		newline()
+	}
+
+	z := xhtml.NewTokenizer(strings.NewReader(sanitizeHtml(s)))
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			break
+		}
+		name, hasAttr := z.TagName()
+		tag := string(name)
+		attrs := map[string]string{}
+		if hasAttr && (tt == xhtml.StartTagToken || tt == xhtml.SelfClosingTagToken) {
+			for {
+				k, v, more := z.TagAttr()
+				attrs[string(k)] = string(v)
+				if !more {
+					break
+				}
+			}
+		}
+
+		switch tt {
+		case xhtml.TextToken:
+			text := string(z.Text())
+			if !pre {
+				text = whitespaceRegex.ReplaceAllString(text, " ")
+				// Lines don't start with a space
+				if current := b.String(); current == "" || strings.HasSuffix(current, "\n") || strings.HasSuffix(current, "> ") || strings.HasSuffix(current, "- ") {
+					text = strings.TrimLeft(text, " ")
+				}
+			}
+			b.WriteString(text)
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			switch tag {
+			case "p", "div", "figure", "table":
+				block()
+			case "br", "tr":
+				newline()
+			case "hr":
+				block()
+				b.WriteString("---")
+				block()
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				block()
+				// One level down, the note's title is the only #
+				level := int(tag[1]-'0') + 1
+				if level > 6 {
+					level = 6
+				}
+				b.WriteString(strings.Repeat("#", level) + " ")
+			case "ul", "ol":
+				if len(lists) == 0 {
+					block()
+				}
+				lists = append(lists, tag)
+				olNumbers = append(olNumbers, 1)
+			case "li":
+				newline()
+				if len(lists) > 1 {
+					b.WriteString(strings.Repeat("  ", len(lists)-1))
+				}
+				if len(lists) > 0 && lists[len(lists)-1] == "ol" {
+					fmt.Fprintf(&b, "%d. ", olNumbers[len(olNumbers)-1])
+					olNumbers[len(olNumbers)-1]++
+				} else {
+					b.WriteString("- ")
+				}
+			case "blockquote":
+				quoteDepth++
+				block()
+			case "pre":
+				pre = true
+				block()
+				b.WriteString("```")
+				newline()
+			case "code":
+				if !pre {
+					b.WriteString("`")
+				}
+			case "strong", "b":
+				b.WriteString("**")
+			case "em", "i":
+				b.WriteString("_")
+			case "a":
+				b.WriteString("[")
+				links = append(links, attrs["href"])
+			case "img":
+				if src := attrs["src"]; src != "" {
+					fmt.Fprintf(&b, "![%s](%s)", attrs["alt"], src)
+				}
+			}
+		case xhtml.EndTagToken:
+			switch tag {
+			case "p", "div", "figure", "table", "h1", "h2", "h3", "h4", "h5", "h6":
+				block()
+			case "ul", "ol":
+				if len(lists) > 0 {
+					lists = lists[:len(lists)-1]
+					olNumbers = olNumbers[:len(olNumbers)-1]
+				}
+				if len(lists) == 0 {
+					block()
+				}
+			case "blockquote":
+				if quoteDepth > 0 {
+					quoteDepth--
+				}
+				block()
+			case "pre":
+				newline()
+				b.WriteString("```")
+				pre = false
+				block()
+			case "code":
+				if !pre {
+					b.WriteString("`")
+				}
+			case "strong", "b":
+				b.WriteString("**")
+			case "em", "i":
+				b.WriteString("_")
+			case "a":
+				if len(links) > 0 {
+					fmt.Fprintf(&b, "](%s)", links[len(links)-1])
+					links = links[:len(links)-1]
+				}
+			}
+		}
+	}
+
+	// Blocks meeting leave runs of blank lines (or blank quote lines), keep the
+	// least quoted one of each
+	lines := []string{}
+	blank := func(l string) bool { return strings.Trim(l, "> ") == "" }
+	for _, l := range strings.Split(b.String(), "\n") {
+		l = strings.TrimRight(l, " ")
+		if n := len(lines); n > 0 && blank(l) && blank(lines[n-1]) {
+			if len(l) < len(lines[n-1]) {
+				lines[n-1] = l
+			}
+			continue
+		}
+		lines = append(lines, l)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/browser"
+)
+
+// --open all asks before opening more tabs than this
+const OPEN_CONFIRM = 10
+
+// Parsed --open: how many posts to open, -1 for all, 0 not to
+var openCount int
+
+// Parse --open, a number of posts or "all"
+func parseOpen(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if s == "all" {
+		return -1, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("Invalid --open %q, expected a number of posts or \"all\"", s)
+	}
+	return n, nil
+}
+
+// Open the n newest posts in the browser, or all of them if n is -1, asking
+// first if that's more than OPEN_CONFIRM
+func openPosts(posts []*Post, n int) error {
+	sortPosts(posts)
+	links := []string{}
+	for _, p := range posts {
+		if p.Link != "" && !contains(links, p.Link) {
+			links = append(links, p.Link)
+		}
+	}
+	if n > 0 && len(links) > n {
+		links = links[:n]
+	}
+	if len(links) == 0 {
+		fmt.Fprintf(os.Stderr, "No posts to open\n")
+		return nil
+	}
+
+	if n < 0 && len(links) > OPEN_CONFIRM {
+		answer, err := promptTty(fmt.Sprintf("Open %d posts? [y/N] ", len(links)))
+		if err != nil {
+			return fmt.Errorf("Not opening %d posts without asking, narrow them down with --feed or --since: %v", len(links), err)
+		}
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			return nil
+		}
+	}
+	for _, link := range links {
+		fmt.Fprintf(os.Stderr, "Opening %s\n", link)
+		if err := browser.OpenURL(link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Backups of the feeds file kept in the state directory, older ones are
+// deleted
+const BACKUPS_KEPT = 20
+
+const BACKUP_TIME = "20060102-150405.000"
+
+type opml struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    opmlHead `xml:"head"`
+	Body    opmlBody `xml:"body"`
+}
+
+type opmlHead struct {
+	Title       string `xml:"title"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type opmlBody struct {
+	Outlines []*opmlOutline `xml:"outline"`
+}
+
+// A feed, or an include or follow-opml line of the feeds file (type
+// "include", with the line as its text)
+type opmlOutline struct {
+	Type   string `xml:"type,attr,omitempty"`
+	Text   string `xml:"text,attr"`
+	Title  string `xml:"title,attr,omitempty"`
+	XMLURL string `xml:"xmlUrl,attr,omitempty"`
+	// The feed's alias in the config, not part of OPML
+	Alias string `xml:"alias,attr,omitempty"`
+	// Folders have outlines inside them
+	Outlines []*opmlOutline `xml:"outline"`
+}
+
+// The default feeds file as OPML, with each feed's title and alias from the
+// config
+func feedsOpml(lines []string) *opml {
+	doc := &opml{
+		Version: "2.0",
+		Head:    opmlHead{Title: "Picofeed subscriptions", DateCreated: time.Now().Format(time.RFC1123Z)},
+	}
+	for _, l := range lines {
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+		if strings.HasPrefix(l, "include ") || strings.HasPrefix(l, "follow-opml ") {
+			doc.Body.Outlines = append(doc.Body.Outlines, &opmlOutline{Type: "include", Text: l})
+			continue
+		}
+		outline := &opmlOutline{Type: "rss", Text: l, XMLURL: l}
+		if u, err := config.resolve(l); err == nil {
+			fc := config.feed(u)
+			outline.XMLURL = u.String()
+			outline.Alias = fc.Alias
+			if fc.Title != "" {
+				outline.Text, outline.Title = fc.Title, fc.Title
+			}
+		}
+		doc.Body.Outlines = append(doc.Body.Outlines, outline)
+	}
+	return doc
+}
+
+// Write the default feeds file as OPML to the state directory before it's
+// changed, so it can be rolled back with restoreFeeds
+func backupFeeds() error {
+	path, err := configPath("feeds")
+	if err != nil {
+		return err
+	}
+	lines, err := readLines(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	dir, err := statePath("backups")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	contents, err := xml.MarshalIndent(feedsOpml(lines), "", "  ")
+	if err != nil {
+		return err
+	}
+	backup := filepath.Join(dir, "feeds-"+time.Now().Format(BACKUP_TIME)+".opml")
+	if err := ioutil.WriteFile(backup, append([]byte(xml.Header), contents...), 0644); err != nil {
+		return err
+	}
+
+	backups, err := feedsBackups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > BACKUPS_KEPT {
+		_ = os.Remove(backups[0])
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// Backups written by backupFeeds, oldest first
+func feedsBackups() ([]string, error) {
+	dir, err := statePath("backups")
+	if err != nil {
+		return nil, err
+	}
+	backups, err := filepath.Glob(filepath.Join(dir, "feeds-*.opml"))
+	sort.Strings(backups)
+	return backups, err
+}
+
+// Replace the default feeds file with an OPML file, or the newest backup if
+// path is "". Feeds with a title or alias that the config doesn't have get a
+// section for it again. The current feeds are backed up first.
+func restoreFeeds(path string) error {
+	if path == "" {
+		backups, err := feedsBackups()
+		if err != nil {
+			return err
+		}
+		if len(backups) == 0 {
+			return errors.New("No backups of the feeds file to restore")
+		}
+		path = backups[len(backups)-1]
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	doc := &opml{}
+	if err := xml.Unmarshal(contents, doc); err != nil {
+		return errors.Wrapf(err, "Failed reading %q", path)
+	}
+
+	lines := []string{}
+	sections := []string{}
+	for _, o := range flattenOutlines(doc.Body.Outlines) {
+		if o.Type == "include" {
+			lines = append(lines, o.Text)
+			continue
+		}
+		if o.XMLURL == "" {
+			continue
+		}
+		lines = append(lines, o.XMLURL)
+
+		u, err := url.Parse(o.XMLURL)
+		if err != nil || config.feed(u).URL != "" || (o.Alias == "" && o.Title == "") {
+			continue
+		}
+		if o.Alias != "" {
+			sections = append(sections, "", fmt.Sprintf("[feed %s]", o.Alias), "url = "+o.XMLURL)
+		} else {
+			sections = append(sections, "", fmt.Sprintf("[feed %s]", o.XMLURL))
+		}
+		if o.Title != "" {
+			sections = append(sections, "title = "+o.Title)
+		}
+	}
+
+	if err := backupFeeds(); err != nil {
+		return errors.Wrapf(err, "Failed backing up the current feeds")
+	}
+	feedsFile, err := configPath("feeds")
+	if err != nil {
+		return err
+	}
+	if err := writeLines(feedsFile, lines); err != nil {
+		return err
+	}
+	if len(sections) > 0 {
+		configFile, err := configPath("config")
+		if err != nil {
+			return err
+		}
+		if err := appendLines(configFile, sections); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Restored %d feeds from %q to %q\n", len(lines), path, feedsFile)
+	return nil
+}
+
+// Outlines with those nested in folders, which OPML from other readers has
+func flattenOutlines(outlines []*opmlOutline) []*opmlOutline {
+	flat := []*opmlOutline{}
+	for _, o := range outlines {
+		flat = append(flat, o)
+		flat = append(flat, flattenOutlines(o.Outlines)...)
+	}
+	return flat
+}
+
+// A follow-opml line stands in for its feeds as follow-opml:<url> until they're
+// fetched, so reading the feeds file doesn't fetch anything
+func followOpmlUrl(u *url.URL) *url.URL {
+	return &url.URL{Scheme: "follow-opml", Opaque: u.String()}
+}
+
+func isFollowOpml(u *url.URL) bool {
+	return u.Scheme == "follow-opml"
+}
+
+// Feeds with each follow-opml line replaced by the feeds in its OPML, fetched
+// (through the cache) each time so they follow its changes. Someone else's
+// list being down shouldn't stop the rest, so it's left out with an error.
+func followOpmls(ctx context.Context, feeds []*url.URL) []*url.URL {
+	followed := []*url.URL{}
+	for _, f := range feeds {
+		if !isFollowOpml(f) {
+			followed = append(followed, f)
+			continue
+		}
+		u, err := url.Parse(f.Opaque)
+		if err == nil {
+			var opmlFeeds []*url.URL
+			if opmlFeeds, err = followOpml(ctx, u); err == nil {
+				followed = append(followed, opmlFeeds...)
+				continue
+			}
+		}
+		fmt.Fprintf(os.Stderr, "ERROR: failed following OPML %q: %v\n", f.Opaque, err)
+	}
+	return followed
+}
+
+// Feeds in someone else's published OPML, e.g. a blogroll
+func followOpml(ctx context.Context, u *url.URL) ([]*url.URL, error) {
+	fc := config.feed(u)
+	ctx, cancel := context.WithTimeout(ctx, fc.timeout())
+	defer cancel()
+	contents, err := fetchUrl(ctx, u, fc)
+	if err != nil {
+		return nil, err
+	}
+	doc := &opml{}
+	if err := xml.Unmarshal(contents, doc); err != nil {
+		return nil, errors.Wrapf(err, "Failed reading OPML")
+	}
+
+	feeds := []*url.URL{}
+	for _, o := range flattenOutlines(doc.Body.Outlines) {
+		if o.XMLURL == "" || o.Type == "include" {
+			continue
+		}
+		// Relative to the OPML, though they rarely are
+		feed, err := u.Parse(o.XMLURL)
+		if err != nil || (feed.Scheme != "http" && feed.Scheme != "https") {
+			continue
+		}
+		feeds = append(feeds, feed)
+	}
+	return feeds, nil
+}
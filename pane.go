@@ -0,0 +1,377 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// Narrowest the picker's list and reading pane get side by side. In a narrower
+// terminal the pane takes the whole screen.
+const PANE_MIN_WIDTH = 30
+
+var sgrRegex = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// A word of rendered content, with the SGR parameters it's styled with ("1" for
+// bold etc) and whether there's a space before it
+type paneWord struct {
+	text  string
+	style string
+	space bool
+}
+
+// A paragraph, heading, list item etc of rendered content. Its first line
+// starts with prefix, and the lines it wraps onto with indent. Pre blocks keep
+// their lines as they are, one word each.
+type paneBlock struct {
+	prefix string
+	indent string
+	words  []paneWord
+	pre    bool
+	// Blank line before
+	gap bool
+}
+
+// The post's title, details and content as the reading pane's lines
+func paneLines(post *Post, width int, theme map[string]string) []string {
+	lines := []string{}
+	title := post.displayTitle()
+	if title == "" {
+		title = post.Link
+	}
+	lines = append(lines, wrapWords(paneBlock{words: paneText(title, theme["title"])}, width)...)
+	details := append(post.details(), post.Timestamp.Format("Jan 2 2006"))
+	lines = append(lines, wrapWords(paneBlock{words: paneText(strings.Join(details, " · "), theme["details"])}, width)...)
+	lines = append(lines, "")
+
+	content := renderPane(post.Content, width, theme)
+	if len(content) == 0 {
+		content = []string{styled("No content, open the post to read it", theme["details"])}
+	}
+	return append(lines, content...)
+}
+
+// Plain text as words for wrapping
+func paneText(s string, style string) []paneWord {
+	words := []paneWord{}
+	for _, w := range strings.Fields(s) {
+		words = append(words, paneWord{text: w, style: style, space: true})
+	}
+	return words
+}
+
+// Render feed html as lines of styled terminal text to read in the picker,
+// wrapped to width, with the links in it numbered [1] [2]... and listed at the
+// end
+func renderPane(s string, width int, theme map[string]string) []string {
+	if width < 1 {
+		return nil
+	}
+	blocks, links := paneBlocks(s, theme)
+
+	lines := []string{}
+	for _, block := range blocks {
+		if len(block.words) == 0 {
+			continue
+		}
+		if block.gap && len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		if block.pre {
+			words := block.words
+			for len(words) > 0 && strings.TrimSpace(words[0].text) == "" {
+				words = words[1:]
+			}
+			for len(words) > 0 && strings.TrimSpace(words[len(words)-1].text) == "" {
+				words = words[:len(words)-1]
+			}
+			room := width - visibleWidth(block.prefix)
+			if room < 0 {
+				room = 0
+			}
+			for _, w := range words {
+				lines = append(lines, block.prefix+styled(truncate(w.text, room), w.style))
+			}
+			continue
+		}
+		lines = append(lines, wrapWords(block, width)...)
+	}
+
+	if len(links) > 0 {
+		lines = append(lines, "", styled("Links:", theme["title"]))
+		for i, link := range links {
+			number := fmt.Sprintf("[%d] ", i+1)
+			lines = append(lines, truncateStyled([]paneWord{{text: number, style: theme["link"]}, {text: link}}, width))
+		}
+	}
+	return lines
+}
+
+// Wrap the block's words to width, styling each. Words without a space
+// between them (a link and its number, punctuation after a tag...) stay on the
+// same line.
+func wrapWords(block paneBlock, width int) []string {
+	lines := []string{}
+	var line strings.Builder
+	line.WriteString(block.prefix)
+	used := visibleWidth(block.prefix)
+	start := true
+	for i := 0; i < len(block.words); {
+		end := i + 1
+		n := utf8.RuneCountInString(block.words[i].text)
+		for end < len(block.words) && !block.words[end].space {
+			n += utf8.RuneCountInString(block.words[end].text)
+			end++
+		}
+
+		space := block.words[i].space && !start
+		if !start && used+1+n > width {
+			lines = append(lines, line.String())
+			line.Reset()
+			line.WriteString(block.indent)
+			used = visibleWidth(block.indent)
+			space = false
+		}
+		if space {
+			line.WriteString(" ")
+			used++
+		}
+		for _, w := range block.words[i:end] {
+			// Words longer than the pane (urls...) are cut
+			room := width - used
+			if room < 0 {
+				room = 0
+			}
+			text := truncate(w.text, room)
+			line.WriteString(styled(text, w.style))
+			used += utf8.RuneCountInString(text)
+		}
+		start = false
+		i = end
+	}
+	return append(lines, line.String())
+}
+
+// Columns s takes up, not counting its styling
+func visibleWidth(s string) int {
+	return utf8.RuneCountInString(sgrRegex.ReplaceAllString(s, ""))
+}
+
+func styled(s string, style string) string {
+	if style == "" || s == "" {
+		return s
+	}
+	return "\x1b[" + style + "m" + s + "\x1b[0m"
+}
+
+// Split feed html into blocks of styled words, and the links in it
+func paneBlocks(s string, theme map[string]string) ([]paneBlock, []string) {
+	blocks := []paneBlock{}
+	links := []string{}
+	hrefs := []string{}
+	quoteDepth := 0
+	lists := []string{}
+	olNumbers := []int{}
+	bold, italic, underline, code := 0, 0, 0, 0
+	pre := false
+	// Whitespace ended the last text, so the next word has a space before it
+	space := false
+
+	style := func() string {
+		codes := []string{}
+		if bold > 0 {
+			codes = append(codes, "1")
+		}
+		if italic > 0 {
+			codes = append(codes, "3")
+		}
+		if underline > 0 {
+			codes = append(codes, "4")
+		}
+		if (code > 0 || pre) && theme["code"] != "" {
+			codes = append(codes, theme["code"])
+		}
+		return strings.Join(codes, ";")
+	}
+	quote := func() string {
+		return strings.Repeat(styled("│", theme["quote"])+" ", quoteDepth)
+	}
+	push := func(b paneBlock) {
+		// Replacing an empty block, keeping its gap
+		if n := len(blocks); n > 0 && len(blocks[n-1].words) == 0 {
+			b.gap = b.gap || blocks[n-1].gap
+			blocks = blocks[:n-1]
+		}
+		blocks = append(blocks, b)
+		space = false
+	}
+	newBlock := func(gap bool) {
+		indent := quote() + strings.Repeat("  ", len(lists))
+		push(paneBlock{prefix: indent, indent: indent, gap: gap})
+	}
+	current := func() *paneBlock {
+		if len(blocks) == 0 {
+			newBlock(false)
+		}
+		return &blocks[len(blocks)-1]
+	}
+	add := func(w paneWord) {
+		b := current()
+		b.words = append(b.words, w)
+	}
+
+	z := xhtml.NewTokenizer(strings.NewReader(sanitizeHtml(s)))
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			break
+		}
+		name, hasAttr := z.TagName()
+		tag := string(name)
+		attrs := map[string]string{}
+		if hasAttr && (tt == xhtml.StartTagToken || tt == xhtml.SelfClosingTagToken) {
+			for {
+				k, v, more := z.TagAttr()
+				attrs[string(k)] = string(v)
+				if !more {
+					break
+				}
+			}
+		}
+
+		switch tt {
+		case xhtml.TextToken:
+			text := string(z.Text())
+			if pre {
+				for i, l := range strings.Split(text, "\n") {
+					b := current()
+					if i == 0 && len(b.words) > 0 {
+						b.words[len(b.words)-1].text += l
+						continue
+					}
+					add(paneWord{text: strings.Replace(l, "\t", "    ", -1), style: style()})
+				}
+				continue
+			}
+			words := strings.Fields(text)
+			if len(words) == 0 {
+				if text != "" {
+					space = true
+				}
+				continue
+			}
+			leading := strings.TrimLeft(text, " \t\r\n") != text
+			for i, w := range words {
+				add(paneWord{text: w, style: style(), space: i > 0 || leading || space})
+			}
+			space = strings.TrimRight(text, " \t\r\n") != text
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			switch tag {
+			case "p", "div", "figure", "table", "hr":
+				newBlock(true)
+			case "br", "tr":
+				newBlock(false)
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				newBlock(true)
+				bold++
+			case "ul", "ol":
+				lists = append(lists, tag)
+				olNumbers = append(olNumbers, 1)
+				newBlock(len(lists) == 1)
+			case "li":
+				depth := len(lists)
+				if depth == 0 {
+					depth = 1
+				}
+				indent := quote() + strings.Repeat("  ", depth-1)
+				bullet := "• "
+				if len(lists) > 0 && lists[len(lists)-1] == "ol" {
+					bullet = fmt.Sprintf("%d. ", olNumbers[len(olNumbers)-1])
+					olNumbers[len(olNumbers)-1]++
+				}
+				push(paneBlock{
+					prefix: indent + bullet,
+					indent: indent + strings.Repeat(" ", utf8.RuneCountInString(bullet)),
+				})
+			case "blockquote":
+				quoteDepth++
+				newBlock(true)
+			case "pre":
+				pre = true
+				newBlock(true)
+				current().pre = true
+			case "code":
+				code++
+			case "strong", "b":
+				bold++
+			case "em", "i":
+				italic++
+			case "a":
+				underline++
+				hrefs = append(hrefs, attrs["href"])
+			case "img":
+				if src := attrs["src"]; src != "" {
+					links = append(links, src)
+					alt := "image"
+					if attrs["alt"] != "" {
+						alt = "image: " + attrs["alt"]
+					}
+					add(paneWord{text: fmt.Sprintf("[%s][%d]", alt, len(links)), style: theme["link"], space: space})
+					space = false
+				}
+			}
+		case xhtml.EndTagToken:
+			switch tag {
+			case "p", "div", "figure", "table":
+				newBlock(true)
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				if bold > 0 {
+					bold--
+				}
+				newBlock(true)
+			case "ul", "ol":
+				if len(lists) > 0 {
+					lists = lists[:len(lists)-1]
+					olNumbers = olNumbers[:len(olNumbers)-1]
+				}
+				newBlock(len(lists) == 0)
+			case "blockquote":
+				if quoteDepth > 0 {
+					quoteDepth--
+				}
+				newBlock(true)
+			case "pre":
+				pre = false
+				newBlock(true)
+			case "code":
+				if code > 0 {
+					code--
+				}
+			case "strong", "b":
+				if bold > 0 {
+					bold--
+				}
+			case "em", "i":
+				if italic > 0 {
+					italic--
+				}
+			case "a":
+				if underline > 0 {
+					underline--
+				}
+				if len(hrefs) > 0 {
+					href := hrefs[len(hrefs)-1]
+					hrefs = hrefs[:len(hrefs)-1]
+					if href != "" {
+						links = append(links, href)
+						add(paneWord{text: fmt.Sprintf("[%d]", len(links)), style: theme["link"]})
+					}
+				}
+			}
+		}
+	}
+	return blocks, links
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/browser"
+)
+
+// Interactive list of posts, filtered fzf-style as you type, opening the
+// marked posts (or the one under the cursor) in the browser, or saving them with
+// --save-to and --notes-dir
+type picker struct {
+	posts []*Post
+	query string
+	// Indexes into posts matching query
+	matches []int
+	// Index into matches
+	cursor int
+	// Top of the visible window, index into matches
+	offset int
+	marked map[int]bool
+
+	// Keypress -> action, and each action's keys, see pickKeys
+	actions map[string]string
+	keys    map[string][]string
+	// Styles of the parts of the list and pane, see pickThemes
+	theme map[string]string
+
+	// Reading pane showing the post under the cursor, see paneLines
+	pane bool
+	// Top of the pane's visible lines
+	paneOffset int
+	// The rendered pane, and the post and width it was rendered for
+	paneLines []string
+	panePost  int
+	paneWidth int
+}
+
+func pickPosts(ctx context.Context, posts []*Post) error {
+	sortPosts(posts)
+	p := &picker{posts: posts, marked: map[int]bool{}, panePost: -1, theme: pickTheme(config)}
+	p.actions, p.keys = pickKeys(config)
+	p.filter()
+
+	tty, err := openTty()
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+
+	selected, err := p.run(tty)
+	if err != nil {
+		return err
+	}
+	// One failed save shouldn't lose the rest of the picks
+	failed := 0
+	for _, post := range selected {
+		if *saveTo != "" || *notesDir != "" {
+			if err := savePost(ctx, post); err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+				failed++
+			}
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Opening %s\n", post.Link)
+		if err := browser.OpenURL(post.Link); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("Failed saving %d of %d posts", failed, len(selected))
+	}
+	return nil
+}
+
+// Run until the user picks or quits, returning the picked posts
+func (p *picker) run(tty *tty) ([]*Post, error) {
+	// Alternate screen, so the scrollback is left as it was
+	fmt.Fprintf(tty, "\x1b[?1049h")
+	defer fmt.Fprintf(tty, "\x1b[?1049l")
+
+	r := bufio.NewReader(tty)
+	for {
+		rows, cols := tty.size()
+		p.render(tty, rows, cols)
+
+		key, err := readKey(r)
+		if err != nil {
+			return nil, err
+		}
+		switch p.actions[key] {
+		case "quit":
+			return nil, nil
+		case "open":
+			return p.selected(), nil
+		case "up":
+			p.move(-1, rows)
+		case "down":
+			p.move(1, rows)
+		case "page-up":
+			p.move(-(rows - 2), rows)
+		case "page-down":
+			p.move(rows-2, rows)
+		case "top":
+			p.move(-len(p.matches), rows)
+		case "bottom":
+			p.move(len(p.matches), rows)
+		case "read":
+			p.pane = true
+		case "close":
+			p.pane = false
+		case "scroll-down":
+			p.paneOffset += rows - 3
+		case "scroll-up":
+			p.paneOffset -= rows - 3
+		case "mark":
+			if len(p.matches) > 0 {
+				i := p.matches[p.cursor]
+				p.marked[i] = !p.marked[i]
+				p.move(1, rows)
+			}
+		case "delete":
+			if p.query != "" {
+				_, size := utf8.DecodeLastRuneInString(p.query)
+				p.query = p.query[:len(p.query)-size]
+				p.filter()
+			}
+		case "clear":
+			p.query = ""
+			p.filter()
+		default:
+			if utf8.RuneCountInString(key) == 1 {
+				p.query += key
+				p.filter()
+			}
+		}
+	}
+}
+
+// The first key of action as shown in the footer, with what it does
+func (p *picker) hint(action string, does string) string {
+	if len(p.keys[action]) == 0 {
+		return ""
+	}
+	return keyLabel(p.keys[action][0]) + " " + does
+}
+
+// Marked posts, or the one under the cursor if none are
+func (p *picker) selected() []*Post {
+	selected := []*Post{}
+	for i, post := range p.posts {
+		if p.marked[i] {
+			selected = append(selected, post)
+		}
+	}
+	if len(selected) == 0 && len(p.matches) > 0 {
+		selected = append(selected, p.posts[p.matches[p.cursor]])
+	}
+	return selected
+}
+
+func (p *picker) filter() {
+	p.matches = []int{}
+	for i, post := range p.posts {
+		if fuzzyMatch(p.query, post.Title+" "+post.shortFeedName()) {
+			p.matches = append(p.matches, i)
+		}
+	}
+	p.cursor = 0
+	p.offset = 0
+}
+
+// Move the cursor by n, scrolling to keep it in the list's rows-2 lines
+func (p *picker) move(n int, rows int) {
+	p.cursor += n
+	if p.cursor >= len(p.matches) {
+		p.cursor = len(p.matches) - 1
+	}
+	if p.cursor < 0 {
+		p.cursor = 0
+	}
+
+	height := rows - 2
+	if p.cursor < p.offset {
+		p.offset = p.cursor
+	} else if height > 0 && p.cursor >= p.offset+height {
+		p.offset = p.cursor - height + 1
+	}
+}
+
+func (p *picker) render(w io.Writer, rows int, cols int) {
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+
+	// With the pane open the list takes the left 2/5, or nothing if there
+	// isn't room for both
+	listWidth := cols
+	if p.pane && len(p.matches) > 0 {
+		listWidth = cols * 2 / 5
+		if listWidth < PANE_MIN_WIDTH {
+			listWidth = PANE_MIN_WIDTH
+		}
+		if cols-listWidth-2 < PANE_MIN_WIDTH {
+			listWidth = 0
+		}
+	}
+
+	for line := 0; line < rows-2 && p.offset+line < len(p.matches) && listWidth > 0; line++ {
+		i := p.matches[p.offset+line]
+		post := p.posts[i]
+
+		mark, markStyle := "  ", ""
+		if p.marked[i] {
+			mark, markStyle = "* ", p.theme["marked"]
+		}
+		parts := []paneWord{
+			{text: mark + post.displayTitle(), style: markStyle},
+			{text: "  "},
+			{text: post.shortFeedName(), style: p.theme["feed"]},
+			{text: " · "},
+			{text: post.Timestamp.Format("Jan 2"), style: p.theme["date"]},
+		}
+		if p.offset+line == p.cursor {
+			text := ""
+			for _, part := range parts {
+				text += part.text
+			}
+			parts = []paneWord{{text: text, style: p.theme["cursor"]}}
+		}
+		b.WriteString(truncateStyled(parts, listWidth) + "\r\n")
+	}
+
+	if listWidth < cols {
+		p.renderPane(&b, rows, cols, listWidth)
+	}
+
+	action := "open"
+	if *saveTo != "" {
+		action = "save to " + *saveTo
+	} else if *notesDir != "" {
+		action = "write notes"
+	}
+	hints := []string{fmt.Sprintf("%d/%d", len(p.matches), len(p.posts)), p.hint("mark", "mark")}
+	if listWidth < cols {
+		if len(p.keys["scroll-down"]) > 0 && len(p.keys["scroll-up"]) > 0 {
+			hints = append(hints, keyLabel(p.keys["scroll-down"][0])+"/"+keyLabel(p.keys["scroll-up"][0])+" scroll")
+		}
+		hints = append(hints, p.hint("close", "close"))
+	} else {
+		hints = append(hints, p.hint("read", "read"))
+	}
+	hints = append(hints, p.hint("open", action), p.hint("quit", "quit"))
+	footer := []string{}
+	for _, h := range hints {
+		if h != "" {
+			footer = append(footer, h)
+		}
+	}
+	fmt.Fprintf(&b, "\x1b[%d;1H%s", rows-1, styled(truncate(strings.Join(footer, " · "), cols), p.theme["footer"]))
+	fmt.Fprintf(&b, "\x1b[%d;1H> %s", rows, styled(p.query, p.theme["query"]))
+	_, _ = io.WriteString(w, b.String())
+}
+
+// Cut the styled parts of a line to width columns
+func truncateStyled(parts []paneWord, width int) string {
+	var b strings.Builder
+	for _, part := range parts {
+		if width <= 0 {
+			break
+		}
+		text := truncate(part.text, width)
+		width -= utf8.RuneCountInString(text)
+		b.WriteString(styled(text, part.style))
+	}
+	return b.String()
+}
+
+// Draw the reading pane for the post under the cursor right of the list,
+// scrolled to paneOffset
+func (p *picker) renderPane(b *strings.Builder, rows int, cols int, listWidth int) {
+	left := 1
+	width := cols
+	if listWidth > 0 {
+		left = listWidth + 3
+		width = cols - listWidth - 2
+	}
+	i := p.matches[p.cursor]
+	if i != p.panePost || width != p.paneWidth {
+		p.paneLines = paneLines(p.posts[i], width, p.theme)
+		p.panePost = i
+		p.paneWidth = width
+		p.paneOffset = 0
+	}
+
+	height := rows - 2
+	if p.paneOffset > len(p.paneLines)-height {
+		p.paneOffset = len(p.paneLines) - height
+	}
+	if p.paneOffset < 0 {
+		p.paneOffset = 0
+	}
+	for line := 0; line < height; line++ {
+		if listWidth > 0 {
+			fmt.Fprintf(b, "\x1b[%d;%dH\x1b[2m│\x1b[0m", line+1, listWidth+1)
+		}
+		if p.paneOffset+line < len(p.paneLines) {
+			fmt.Fprintf(b, "\x1b[%d;%dH%s", line+1, left, p.paneLines[p.paneOffset+line])
+		}
+	}
+}
+
+// Whether the characters of query appear in s in order, ignoring case
+func fuzzyMatch(query string, s string) bool {
+	s = strings.ToLower(s)
+	for _, r := range strings.ToLower(query) {
+		if r == ' ' {
+			continue
+		}
+		i := strings.IndexRune(s, r)
+		if i < 0 {
+			return false
+		}
+		s = s[i+utf8.RuneLen(r):]
+	}
+	return true
+}
+
+// Cut s to n runes
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// Read one keypress, named for special keys ("up", "enter", "ctrl-c", ...)
+func readKey(r *bufio.Reader) (string, error) {
+	c, _, err := r.ReadRune()
+	if err != nil {
+		return "", err
+	}
+
+	switch c {
+	case '\r', '\n':
+		return "enter", nil
+	case '\t':
+		return "tab", nil
+	case 127, 8:
+		return "backspace", nil
+	case 27:
+		// A lone escape, or the start of an escape sequence
+		if r.Buffered() == 0 {
+			return "esc", nil
+		}
+		next, _, _ := r.ReadRune()
+		if next != '[' && next != 'O' {
+			return "esc", nil
+		}
+		seq := ""
+		for r.Buffered() > 0 {
+			b, _ := r.ReadByte()
+			seq += string(b)
+			if b >= 0x40 && b <= 0x7e {
+				break
+			}
+		}
+		switch seq {
+		case "A":
+			return "up", nil
+		case "B":
+			return "down", nil
+		case "C":
+			return "right", nil
+		case "D":
+			return "left", nil
+		case "H", "1~", "7~":
+			return "home", nil
+		case "F", "4~", "8~":
+			return "end", nil
+		case "5~":
+			return "pgup", nil
+		case "6~":
+			return "pgdown", nil
+		}
+		return "", nil
+	}
+	if c < 32 {
+		return fmt.Sprintf("ctrl-%c", c+'a'-1), nil
+	}
+	return string(c), nil
+}
@@ -0,0 +1,255 @@
+//go:build plugins
+// +build plugins
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Max time a plugin can take per post, after which it's stopped for the run
+const PLUGIN_TIMEOUT = 5 * time.Second
+
+// 64MB of memory per plugin
+const PLUGIN_MEMORY_PAGES = 1024
+
+// WASM plugins from ~/.config/picofeed/plugins/*.wasm, run in order of their
+// file names. Plugins get WASI without any files, environment or network, and
+// export:
+//
+//	memory
+//	alloc(size i32) -> i32          buffer for picofeed to write a post into
+//	filter(ptr i32, len i32) -> i32 optional, 0 to drop the post
+//	transform(ptr i32, len i32) -> i64
+//	                                optional, the changed post as ptr<<32|len,
+//	                                or 0 to leave it unchanged
+//
+// Posts are passed as pluginPost json. Buffers only need to stay valid until
+// the next call into the plugin.
+type wasmPlugins struct {
+	runtime wazero.Runtime
+
+	// Modules aren't safe for concurrent use
+	mu      sync.Mutex
+	plugins []*wasmPlugin
+}
+
+type wasmPlugin struct {
+	name      string
+	mod       api.Module
+	alloc     api.Function
+	filter    api.Function
+	transform api.Function
+}
+
+// What plugins see of a post, the same fields as Lua hooks
+type pluginPost struct {
+	Title     string `json:"title"`
+	Link      string `json:"link"`
+	Content   string `json:"content"`
+	GUID      string `json:"guid"`
+	Timestamp int64  `json:"timestamp"`
+	Words     int    `json:"words"`
+	Updated   bool   `json:"updated"`
+	FeedLink  string `json:"feed_link"`
+	FeedTitle string `json:"feed_title"`
+	FeedAlias string `json:"feed_alias"`
+}
+
+// nil if there aren't any plugins
+var plugins *wasmPlugins
+
+func loadPlugins() (*wasmPlugins, error) {
+	dir, err := configPath("plugins")
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.wasm"))
+	if err != nil || len(paths) == 0 {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	ctx := context.Background()
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(PLUGIN_MEMORY_PAGES).
+		WithCloseOnContextDone(true))
+	wasi_snapshot_preview1.MustInstantiate(ctx, r)
+
+	w := &wasmPlugins{runtime: r}
+	for _, path := range paths {
+		p, err := w.load(ctx, path)
+		if err != nil {
+			r.Close(ctx)
+			return nil, fmt.Errorf("Failed loading plugin %q: %v", path, err)
+		}
+		w.plugins = append(w.plugins, p)
+	}
+	return w, nil
+}
+
+func (w *wasmPlugins) load(ctx context.Context, path string) (*wasmPlugin, error) {
+	code, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := w.runtime.CompileModule(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), ".wasm")
+	config := wazero.NewModuleConfig().
+		WithName(name).
+		WithStderr(os.Stderr).
+		// Reactor modules, as built with e.g. GOOS=wasip1 go build -buildmode=c-shared
+		WithStartFunctions("_initialize")
+	mod, err := w.runtime.InstantiateModule(ctx, compiled, config)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &wasmPlugin{
+		name:      name,
+		mod:       mod,
+		alloc:     mod.ExportedFunction("alloc"),
+		filter:    mod.ExportedFunction("filter"),
+		transform: mod.ExportedFunction("transform"),
+	}
+	if mod.Memory() == nil || p.alloc == nil {
+		return nil, errors.New("Plugin must export memory and alloc")
+	}
+	if p.filter == nil && p.transform == nil {
+		return nil, errors.New("Plugin must export filter or transform")
+	}
+	return p, nil
+}
+
+func (w *wasmPlugins) close() {
+	if w != nil {
+		w.runtime.Close(context.Background())
+	}
+}
+
+// Run each plugin over posts, returning those they keep. Posts are kept
+// unchanged by a plugin that fails.
+func (w *wasmPlugins) transformPosts(posts []*Post) []*Post {
+	if w == nil {
+		return posts
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, plugin := range w.plugins {
+		kept := []*Post{}
+		for _, p := range posts {
+			keep, err := plugin.run(p)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: plugin %s failed for %q: %v\n", plugin.name, p.Link, err)
+			}
+			if keep || err != nil {
+				kept = append(kept, p)
+			}
+		}
+		posts = kept
+	}
+	return posts
+}
+
+// Filter then transform p, returning whether to keep it
+func (plugin *wasmPlugin) run(p *Post) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), PLUGIN_TIMEOUT)
+	defer cancel()
+
+	in, err := json.Marshal(&pluginPost{
+		Title:     p.Title,
+		Link:      p.Link,
+		Content:   p.Content,
+		GUID:      p.GUID,
+		Timestamp: p.Timestamp.Unix(),
+		Words:     p.Words,
+		Updated:   p.Updated,
+		FeedLink:  p.FeedLink,
+		FeedTitle: p.FeedTitle,
+		FeedAlias: p.FeedAlias,
+	})
+	if err != nil {
+		return true, err
+	}
+
+	if plugin.filter != nil {
+		ptr, err := plugin.write(ctx, in)
+		if err != nil {
+			return true, err
+		}
+		ret, err := plugin.filter.Call(ctx, ptr, uint64(len(in)))
+		if err != nil {
+			return true, err
+		}
+		if uint32(ret[0]) == 0 {
+			return false, nil
+		}
+	}
+
+	if plugin.transform != nil {
+		ptr, err := plugin.write(ctx, in)
+		if err != nil {
+			return true, err
+		}
+		ret, err := plugin.transform.Call(ctx, ptr, uint64(len(in)))
+		if err != nil {
+			return true, err
+		}
+		if ret[0] == 0 {
+			return true, nil
+		}
+		out, ok := plugin.mod.Memory().Read(uint32(ret[0]>>32), uint32(ret[0]))
+		if !ok {
+			return true, errors.New("transform returned a buffer outside memory")
+		}
+		changed := &pluginPost{}
+		if err := json.Unmarshal(out, changed); err != nil {
+			return true, errors.Wrap(err, "transform returned invalid json")
+		}
+
+		p.Title = changed.Title
+		p.Link = changed.Link
+		p.FeedTitle = changed.FeedTitle
+		p.FeedAlias = changed.FeedAlias
+		if changed.Content != p.Content {
+			p.Content = changed.Content
+			p.countWords()
+		}
+		if changed.Timestamp != p.Timestamp.Unix() {
+			t := time.Unix(changed.Timestamp, 0)
+			p.Timestamp = &t
+		}
+	}
+	return true, nil
+}
+
+// Copy data into a buffer from the plugin's alloc
+func (plugin *wasmPlugin) write(ctx context.Context, data []byte) (uint64, error) {
+	ret, err := plugin.alloc.Call(ctx, uint64(len(data)))
+	if err != nil {
+		return 0, err
+	}
+	if !plugin.mod.Memory().Write(uint32(ret[0]), data) {
+		return 0, errors.New("alloc returned a buffer outside memory")
+	}
+	return ret[0], nil
+}
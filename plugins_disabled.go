@@ -0,0 +1,34 @@
+//go:build !plugins
+// +build !plugins
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Without -tags plugins there's no WASM runtime, so picofeed doesn't need
+// wazero or the go version it requires. See plugins.go.
+type wasmPlugins struct{}
+
+var plugins *wasmPlugins
+
+// Fails if there are plugins, rather than running without them
+func loadPlugins() (*wasmPlugins, error) {
+	dir, err := configPath("plugins")
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.wasm"))
+	if err != nil || len(paths) == 0 {
+		return nil, err
+	}
+	return nil, fmt.Errorf("Found plugins in %q, but picofeed was built without them, build it with -tags plugins", dir)
+}
+
+func (w *wasmPlugins) close() {}
+
+func (w *wasmPlugins) transformPosts(posts []*Post) []*Post {
+	return posts
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often the --verbose table is redrawn
+const PROGRESS_REDRAW = 200 * time.Millisecond
+
+// A feed's status in the --verbose table
+const (
+	FETCH_QUEUED   = "queued"
+	FETCH_FETCHING = "fetching"
+	FETCH_PARSED   = "parsed"
+	FETCH_ERROR    = "error"
+)
+
+// Order rows are listed in, what's still going on first
+var fetchStatusOrder = map[string]int{FETCH_FETCHING: 0, FETCH_QUEUED: 1, FETCH_ERROR: 2, FETCH_PARSED: 3}
+
+type fetchRow struct {
+	feed    string
+	status  string
+	queued  time.Time
+	started time.Time
+	ended   time.Time
+	posts   int
+	err     string
+}
+
+// How long the feed has been fetching, or took to fetch
+func (r *fetchRow) elapsed(now time.Time) time.Duration {
+	if r.started.IsZero() {
+		return now.Sub(r.queued)
+	}
+	if r.ended.IsZero() {
+		return now.Sub(r.started)
+	}
+	return r.ended.Sub(r.started)
+}
+
+// The --verbose table of each feed's status while fetching. On a terminal
+// it's redrawn in place, with fetchEach's errors held until it's done,
+// otherwise each change is printed as a line.
+type fetchProgress struct {
+	mu    sync.Mutex
+	w     io.Writer
+	live  bool
+	start time.Time
+	rows  []*fetchRow
+	// Lines drawn last redraw, to move back up over
+	drawn int
+	// Terminal size, read once when the table starts
+	height, width int
+	// Messages to write once the table is done
+	held []string
+
+	stop chan bool
+	done chan bool
+}
+
+type fetchRowKey struct{}
+
+func newFetchProgress(feeds []*url.URL) *fetchProgress {
+	p := &fetchProgress{w: os.Stderr, start: time.Now()}
+	for _, f := range feeds {
+		p.rows = append(p.rows, &fetchRow{feed: f.String(), status: FETCH_QUEUED, queued: p.start})
+	}
+	if fi, err := os.Stderr.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		p.live = true
+		p.height, p.width = (&tty{File: os.Stderr}).size()
+		p.stop, p.done = make(chan bool), make(chan bool)
+		go p.redrawEvery(PROGRESS_REDRAW)
+	}
+	return p
+}
+
+// Write a message to stderr, or hold it until the table's done if it's being
+// redrawn, so it isn't drawn over
+func (p *fetchProgress) logf(format string, args ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.live {
+		p.held = append(p.held, fmt.Sprintf(format, args...))
+		return
+	}
+	fmt.Fprintf(p.w, format, args...)
+}
+
+// A context fetches of the feed's row are tracked through
+func (p *fetchProgress) track(ctx context.Context, i int) context.Context {
+	return context.WithValue(ctx, fetchRowKey{}, &trackedRow{p, p.rows[i]})
+}
+
+type trackedRow struct {
+	p   *fetchProgress
+	row *fetchRow
+}
+
+// Mark the feed fetched through ctx as fetching, once it's past any limits
+// on its host. Nothing if it isn't tracked, or already fetching.
+func fetchStarted(ctx context.Context) {
+	if t, ok := ctx.Value(fetchRowKey{}).(*trackedRow); ok {
+		t.p.update(t.row, func(r *fetchRow) bool {
+			if r.status != FETCH_QUEUED {
+				return false
+			}
+			r.status, r.started = FETCH_FETCHING, time.Now()
+			return true
+		})
+	}
+}
+
+// Mark the feed done, parsed into posts or failed with err
+func (p *fetchProgress) finish(i int, posts int, err error) {
+	p.update(p.rows[i], func(r *fetchRow) bool {
+		r.ended = time.Now()
+		if r.started.IsZero() {
+			// Sources that don't go through the host limits
+			r.started = r.queued
+		}
+		r.status, r.posts = FETCH_PARSED, posts
+		if err != nil {
+			r.status, r.err = FETCH_ERROR, err.Error()
+		}
+		return true
+	})
+}
+
+func (p *fetchProgress) update(r *fetchRow, change func(*fetchRow) bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !change(r) || p.live {
+		return
+	}
+	fmt.Fprintln(p.w, p.line(r, time.Now(), 0))
+}
+
+func (p *fetchProgress) redrawEvery(every time.Duration) {
+	defer close(p.done)
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.redraw(false)
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// Stop redrawing, leaving every feed's final row, and write out the messages
+// held meanwhile
+func (p *fetchProgress) close() {
+	if !p.live {
+		return
+	}
+	close(p.stop)
+	<-p.done
+	p.redraw(true)
+	for _, m := range p.held {
+		fmt.Fprint(p.w, m)
+	}
+}
+
+// Draw the table over the last one. Only as many rows as fit on the terminal
+// are drawn until the final one.
+func (p *fetchProgress) redraw(final bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	height, width := p.height, p.width
+	rows := append([]*fetchRow{}, p.rows...)
+	sort.SliceStable(rows, func(i, j int) bool {
+		if fetchStatusOrder[rows[i].status] != fetchStatusOrder[rows[j].status] {
+			return fetchStatusOrder[rows[i].status] < fetchStatusOrder[rows[j].status]
+		}
+		return rows[i].elapsed(now) > rows[j].elapsed(now)
+	})
+
+	counts := map[string]int{}
+	for _, r := range rows {
+		counts[r.status]++
+	}
+	summary := []string{}
+	for _, status := range []string{FETCH_PARSED, FETCH_ERROR, FETCH_FETCHING, FETCH_QUEUED} {
+		if counts[status] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[status], status))
+		}
+	}
+	lines := []string{fmt.Sprintf("Fetching %d feeds, %s, %s", len(rows), strings.Join(summary, ", "), now.Sub(p.start).Round(100*time.Millisecond))}
+
+	more := 0
+	if fit := max(height-2, 2); !final && len(rows) > fit {
+		more, rows = len(rows)-fit+1, rows[:fit-1]
+	}
+	for _, r := range rows {
+		lines = append(lines, p.line(r, now, width))
+	}
+	if more > 0 {
+		lines = append(lines, fmt.Sprintf("  … %d more", more))
+	}
+
+	var b strings.Builder
+	if p.drawn > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", p.drawn)
+	}
+	b.WriteString("\r\x1b[J")
+	for _, l := range lines {
+		b.WriteString(l + "\n")
+	}
+	p.drawn = len(lines)
+	_, _ = io.WriteString(p.w, b.String())
+}
+
+// A row as "  status  elapsed  posts  feed: error", cut to width if it's set
+func (p *fetchProgress) line(r *fetchRow, now time.Time, width int) string {
+	posts := ""
+	if r.status == FETCH_PARSED {
+		posts = fmt.Sprint(r.posts)
+	}
+	l := fmt.Sprintf("  %-8s %7s %5s  %s", r.status, r.elapsed(now).Round(10*time.Millisecond), posts, r.feed)
+	if r.err != "" {
+		l += ": " + strings.ReplaceAll(r.err, "\n", " ")
+	}
+	if width > 0 && len([]rune(l)) > width {
+		l = string([]rune(l)[:width-1]) + "…"
+	}
+	return l
+}
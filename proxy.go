@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// How often the proxy saves the state, keeping any backoff from a 429 or 503
+const PROXY_SAVE_INTERVAL = time.Minute
+
+// Serves upstream feeds through the feed cache, so several readers asking for
+// the same feed only fetch it from its host once per --interval
+type feedProxy struct {
+	auth *serveAuth
+
+	// Url -> lock held while fetching it, so concurrent requests for a feed
+	// share one upstream fetch. Removed once nothing is waiting on it.
+	mu      sync.Mutex
+	fetches map[string]*proxyFetch
+}
+
+type proxyFetch struct {
+	sync.Mutex
+	waiting int
+}
+
+// Serve feeds at /?url=<feed url> (or /<feed url>) until SIGINT or SIGTERM. It
+// fetches any url it's asked for, so anywhere but loopback it needs auth.
+func proxy(ctx context.Context, addr string, auth *serveAuth) error {
+	if auth == nil && !loopbackAddr(addr) {
+		return fmt.Errorf("proxy fetches any url it's asked for, so listening on %q needs --auth (or listen on localhost)", addr)
+	}
+	// Reuse anything fetched within the interval without asking upstream,
+	// unless --max-age says otherwise
+	if *maxAge == 0 {
+		*maxAge = *interval
+	}
+
+	p := &feedProxy{auth: auth, fetches: map[string]*proxyFetch{}}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		<-signals
+		fmt.Fprintf(os.Stderr, "Shutting down\n")
+		sdNotify("STOPPING=1")
+		cancel()
+	}()
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Proxying feeds on http://%s/?url=<feed url>\n", addr)
+
+	srv := &http.Server{Handler: auth.wrap(p)}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+		defer shutdownCancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	saved := make(chan bool)
+	go func() {
+		saveStateEvery(ctx, PROXY_SAVE_INTERVAL)
+		close(saved)
+	}()
+
+	sdNotify("READY=1")
+	go sdWatchdog(ctx)
+	err = srv.Serve(l)
+	cancel()
+	<-saved
+	if err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Save the state every interval, and once more when ctx is done
+func saveStateEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+		if err := state.save(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: failed saving state: %v\n", err)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// Whether addr only listens on this machine
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (p *feedProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("url")
+	if target == "" {
+		target = strings.TrimPrefix(r.URL.Path, "/")
+		query := r.URL.RawQuery
+		if p.auth != nil {
+			// The ?token= auth.wrap took, not part of the feed's url
+			q := r.URL.Query()
+			q.Del("token")
+			query = q.Encode()
+		}
+		if query != "" {
+			target += "?" + query
+		}
+	}
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		http.Error(w, "Expected /?url=<http(s) feed url>", http.StatusBadRequest)
+		return
+	}
+
+	body, err := p.fetch(r.Context(), u)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed fetching %s: %v", u, err), http.StatusBadGateway)
+		return
+	}
+
+	// Only feeds, so this isn't an open proxy for anything else
+	var contentType string
+	switch gofeed.DetectFeedType(bytes.NewReader(body)) {
+	case gofeed.FeedTypeAtom:
+		contentType = "application/atom+xml"
+	case gofeed.FeedTypeRSS:
+		contentType = "application/rss+xml"
+	default:
+		http.Error(w, fmt.Sprintf("%s isn't a feed", u), http.StatusBadGateway)
+		return
+	}
+
+	sum := sha1.Sum(body)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(maxAge.Seconds())))
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	_, _ = w.Write(body)
+}
+
+func (p *feedProxy) fetch(ctx context.Context, u *url.URL) ([]byte, error) {
+	key := u.String()
+	p.mu.Lock()
+	f, ok := p.fetches[key]
+	if !ok {
+		f = &proxyFetch{}
+		p.fetches[key] = f
+	}
+	f.waiting++
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if f.waiting--; f.waiting == 0 {
+			delete(p.fetches, key)
+		}
+	}()
+
+	f.Lock()
+	defer f.Unlock()
+	fc := config.feed(u)
+	ctx, cancel := context.WithTimeout(ctx, fc.timeout())
+	defer cancel()
+	started := time.Now()
+	body, err := fetchUrl(ctx, u, fc)
+	if err == nil {
+		fmt.Fprintf(os.Stderr, "Proxied %q in %s\n", u, time.Since(started).Round(time.Millisecond))
+	}
+	return body, err
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// Hold back everything written to stderr until the returned func is called,
+// which writes it out if show is set, so a run can end without any output
+func holdStderr() (func(show bool), error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stderr := os.Stderr
+	os.Stderr = w
+	var buf bytes.Buffer
+	done := make(chan bool)
+	go func() {
+		_, _ = io.Copy(&buf, r)
+		close(done)
+	}()
+
+	return func(show bool) {
+		os.Stderr = stderr
+		w.Close()
+		<-done
+		r.Close()
+		if show {
+			_, _ = buf.WriteTo(stderr)
+		}
+	}, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits requests to each host, both how many are in flight at once and how
+// often new ones start, so many feeds on one host don't get us throttled
+type hostLimiter struct {
+	// Max requests in flight per host, 0 for unlimited
+	concurrency int
+	// Min time between request starts per host, 0 for unlimited
+	interval time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostLimit
+}
+
+type hostLimit struct {
+	inFlight chan struct{}
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+var limiter = newHostLimiter(0, 0)
+
+// perSecond is the max request rate per host, 0 for unlimited
+func newHostLimiter(concurrency int, perSecond float64) *hostLimiter {
+	l := &hostLimiter{
+		concurrency: concurrency,
+		hosts:       map[string]*hostLimit{},
+	}
+	if perSecond > 0 {
+		l.interval = time.Duration(float64(time.Second) / perSecond)
+	}
+	return l
+}
+
+func (l *hostLimiter) limit(host string) *hostLimit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	hl, ok := l.hosts[host]
+	if !ok {
+		hl = &hostLimit{}
+		if l.concurrency > 0 {
+			hl.inFlight = make(chan struct{}, l.concurrency)
+		}
+		l.hosts[host] = hl
+	}
+	return hl
+}
+
+// Wait until a request to host is allowed, the returned func must be called
+// when the request is done
+func (l *hostLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	hl := l.limit(host)
+
+	release := func() {}
+	if hl.inFlight != nil {
+		select {
+		case hl.inFlight <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		release = func() { <-hl.inFlight }
+	}
+
+	if l.interval > 0 {
+		hl.mu.Lock()
+		now := time.Now()
+		start := hl.next
+		if start.Before(now) {
+			start = now
+		}
+		hl.next = start.Add(l.interval)
+		hl.mu.Unlock()
+
+		select {
+		case <-time.After(start.Sub(now)):
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+
+	fetchStarted(ctx)
+	return release, nil
+}
+
+// Chunk to read at a time when the download rate is limited, small enough for
+// concurrent fetches to share the rate smoothly
+const RATE_CHUNK = 16 * 1024
+
+// Limits the download rate summed across all fetches
+type rateLimiter struct {
+	// Bytes per second
+	rate float64
+
+	mu sync.Mutex
+	// When the bytes read so far have been paid for
+	next time.Time
+}
+
+// Unlimited until set from --max-rate
+var bandwidth *rateLimiter
+
+func newRateLimiter(bytesPerSecond float64) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: bytesPerSecond}
+}
+
+// Wait until n more bytes are allowed
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Wrap r to read at no more than the limit, nil for unlimited
+func (l *rateLimiter) reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{ctx: ctx, r: r, limiter: l}
+}
+
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > RATE_CHUNK {
+		p = p[:RATE_CHUNK]
+	}
+	n, err := r.r.Read(p)
+	if waitErr := r.limiter.wait(r.ctx, n); waitErr != nil && err == nil {
+		err = waitErr
+	}
+	return n, err
+}
+
+// Parse a rate in bytes per second like 500k or 2M, suffixes are powers of
+// 1024
+func parseRate(s string) (float64, error) {
+	multiplier := 1.0
+	number := strings.ToLower(strings.TrimSpace(s))
+	switch {
+	case strings.HasSuffix(number, "k"):
+		multiplier = 1024
+	case strings.HasSuffix(number, "m"):
+		multiplier = 1024 * 1024
+	case strings.HasSuffix(number, "g"):
+		multiplier = 1024 * 1024 * 1024
+	}
+	if multiplier > 1 {
+		number = number[:len(number)-1]
+	}
+
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid rate %q, expected bytes per second like 500k or 2M", s)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	gohtml "html"
+	"net/url"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// Tags kept when sanitizing feed html, with the attributes they keep.
+// Anything else is dropped, keeping its text.
+var allowedTags = map[string][]string{
+	"a":          {"href", "title"},
+	"abbr":       {"title"},
+	"b":          nil,
+	"blockquote": nil,
+	"br":         nil,
+	"code":       nil,
+	"dd":         nil,
+	"del":        nil,
+	"dl":         nil,
+	"dt":         nil,
+	"em":         nil,
+	"figcaption": nil,
+	"figure":     nil,
+	"h1":         nil,
+	"h2":         nil,
+	"h3":         nil,
+	"h4":         nil,
+	"h5":         nil,
+	"h6":         nil,
+	"hr":         nil,
+	"i":          nil,
+	"img":        {"src", "alt", "title", "width", "height"},
+	"li":         nil,
+	"ol":         nil,
+	"p":          nil,
+	"pre":        nil,
+	"q":          nil,
+	"s":          nil,
+	"small":      nil,
+	"strong":     nil,
+	"sub":        nil,
+	"sup":        nil,
+	"table":      nil,
+	"tbody":      nil,
+	"td":         nil,
+	"th":         nil,
+	"thead":      nil,
+	"tr":         nil,
+	"u":          nil,
+	"ul":         nil,
+}
+
+// Tags dropped along with everything inside them
+var droppedTags = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"iframe":   true,
+	"object":   true,
+	"embed":    true,
+	"noscript": true,
+	"template": true,
+	"svg":      true,
+	"math":     true,
+}
+
+// Sanitize html from a feed to include in a page, so a malicious feed can't
+// run scripts there. Only allowedTags and their attributes survive, urls must
+// be http(s) (or mailto for links), and links open without a referrer.
+func sanitizeHtml(s string) string {
+	var buf bytes.Buffer
+	z := xhtml.NewTokenizer(strings.NewReader(s))
+	// Depth inside droppedTags
+	dropping := 0
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			// EOF, or unparseable and the rest is dropped
+			break
+		}
+
+		token := z.Token()
+		switch tt {
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			if droppedTags[token.Data] {
+				if tt == xhtml.StartTagToken {
+					dropping++
+				}
+				continue
+			}
+			attrs, ok := allowedTags[token.Data]
+			if !ok || dropping > 0 {
+				continue
+			}
+			buf.WriteString("<" + token.Data)
+			for _, attr := range token.Attr {
+				if attr.Namespace != "" || !contains(attrs, attr.Key) {
+					continue
+				}
+				if attr.Key == "href" || attr.Key == "src" {
+					u := safeUrl(attr.Val, attr.Key == "href")
+					if u == "" {
+						continue
+					}
+					attr.Val = u
+				}
+				buf.WriteString(" " + attr.Key + "=\"" + gohtml.EscapeString(attr.Val) + "\"")
+			}
+			if token.Data == "a" {
+				buf.WriteString(" rel=\"noopener noreferrer nofollow\"")
+			}
+			if tt == xhtml.SelfClosingTagToken {
+				buf.WriteString("/")
+			}
+			buf.WriteString(">")
+		case xhtml.EndTagToken:
+			if droppedTags[token.Data] {
+				if dropping > 0 {
+					dropping--
+				}
+				continue
+			}
+			if _, ok := allowedTags[token.Data]; ok && dropping == 0 {
+				buf.WriteString("</" + token.Data + ">")
+			}
+		case xhtml.TextToken:
+			if dropping == 0 {
+				buf.WriteString(gohtml.EscapeString(token.Data))
+			}
+		}
+	}
+	return buf.String()
+}
+
+// u if it's an http(s) url (or mailto if link is set), otherwise "". Relative
+// urls are dropped, there's no page for them to be relative to.
+func safeUrl(u string, link bool) string {
+	parsed, err := url.Parse(strings.TrimSpace(u))
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https":
+		return parsed.String()
+	case "mailto":
+		if link {
+			return parsed.String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Read later services and bookmark managers posts can be saved to, by
+// --save-to name. Each is given the credentials stored in the keyring under its
+// name, see keyringSecret.
+var saveServices = map[string]func(ctx context.Context, creds url.Values, p *Post) error{
+	"instapaper": saveToInstapaper,
+	"linkding":   saveToLinkding,
+	"shiori":     saveToShiori,
+	"wallabag":   saveToWallabag,
+}
+
+func saveServiceNames() []string {
+	names := []string{}
+	for name := range saveServices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Save the post's link to the service, with its title and tags if it has them
+// (a post with only a link lets the service find its title)
+func saveLink(ctx context.Context, service string, p *Post) error {
+	save, ok := saveServices[service]
+	if !ok {
+		return fmt.Errorf("Unknown service %q, expected one of %s", service, strings.Join(saveServiceNames(), ", "))
+	}
+	secret, err := keyringSecret(service)
+	if err != nil {
+		return err
+	}
+	creds, err := url.ParseQuery(secret)
+	if err != nil {
+		return errors.Wrapf(err, "Couldn't read the %s credentials in the keyring, expected key=value&key=value", service)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, FETCH_TIMEOUT)
+	defer cancel()
+	return errors.Wrapf(save(ctx, creds, p), "Failed saving to %s", service)
+}
+
+// Save the post with --save-to and write it to --notes-dir, whichever are set
+func savePost(ctx context.Context, p *Post) error {
+	if *saveTo != "" {
+		if err := saveLink(ctx, *saveTo, p); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Saved %s to %s\n", p.Link, *saveTo)
+	}
+	if *notesDir != "" {
+		path, err := writeNote(*notesDir, p)
+		if err != nil {
+			return errors.Wrapf(err, "Failed writing note for %s", p.Link)
+		}
+		fmt.Fprintf(os.Stderr, "Wrote %s\n", path)
+	}
+	return nil
+}
+
+// Credentials that must be in the keyring for a service
+func requireCreds(creds url.Values, keys ...string) error {
+	for _, k := range keys {
+		if creds.Get(k) == "" {
+			return fmt.Errorf("No %s in the keyring's credentials", k)
+		}
+	}
+	return nil
+}
+
+func saveJsonRequest(ctx context.Context, u string, body interface{}, header http.Header, result interface{}) error {
+	contents, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", u, bytes.NewReader(contents))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	return saveRequest(req.WithContext(ctx), result)
+}
+
+func saveRequest(req *http.Request, result interface{}) error {
+	req.Header.Set("User-Agent", defaultUserAgent())
+	resp, err := doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 200))
+		return fmt.Errorf("Unexpected status code: %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// Instapaper's simple API, with username and password
+func saveToInstapaper(ctx context.Context, creds url.Values, p *Post) error {
+	if err := requireCreds(creds, "username"); err != nil {
+		return err
+	}
+	form := url.Values{"url": {p.Link}}
+	if p.Title != "" {
+		form.Set("title", p.Title)
+	}
+	req, _ := http.NewRequest("POST", "https://www.instapaper.com/api/add", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(creds.Get("username"), creds.Get("password"))
+	return saveRequest(req.WithContext(ctx), nil)
+}
+
+// Wallabag's API at url, logging in with client_id, client_secret, username
+// and password
+func saveToWallabag(ctx context.Context, creds url.Values, p *Post) error {
+	if err := requireCreds(creds, "url", "client_id", "client_secret", "username", "password"); err != nil {
+		return err
+	}
+	base := strings.TrimSuffix(creds.Get("url"), "/")
+
+	form := url.Values{
+		"grant_type":    {"password"},
+		"client_id":     {creds.Get("client_id")},
+		"client_secret": {creds.Get("client_secret")},
+		"username":      {creds.Get("username")},
+		"password":      {creds.Get("password")},
+	}
+	req, _ := http.NewRequest("POST", base+"/oauth/v2/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	token := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := saveRequest(req.WithContext(ctx), &token); err != nil {
+		return errors.Wrapf(err, "Failed logging in")
+	}
+
+	entry := url.Values{"url": {p.Link}, "tags": {strings.Join(p.Tags, ",")}}
+	if p.Title != "" {
+		entry.Set("title", p.Title)
+	}
+	req, _ = http.NewRequest("POST", base+"/api/entries.json", strings.NewReader(entry.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	return saveRequest(req.WithContext(ctx), nil)
+}
+
+// Linkding's API at url, with an API token from its settings page
+func saveToLinkding(ctx context.Context, creds url.Values, p *Post) error {
+	if err := requireCreds(creds, "url", "token"); err != nil {
+		return err
+	}
+	body := map[string]interface{}{
+		"url":       p.Link,
+		"title":     p.Title,
+		"tag_names": append([]string{}, p.Tags...),
+	}
+	header := http.Header{"Authorization": {"Token " + creds.Get("token")}}
+	return saveJsonRequest(ctx, strings.TrimSuffix(creds.Get("url"), "/")+"/api/bookmarks/", body, header, nil)
+}
+
+// Shiori's API at url, logging in with username and password
+func saveToShiori(ctx context.Context, creds url.Values, p *Post) error {
+	if err := requireCreds(creds, "url", "username", "password"); err != nil {
+		return err
+	}
+	base := strings.TrimSuffix(creds.Get("url"), "/")
+
+	// Older versions return the session at the top level, newer ones in message
+	login := struct {
+		Session string `json:"session"`
+		Message struct {
+			Session string `json:"session"`
+		} `json:"message"`
+	}{}
+	body := map[string]interface{}{"username": creds.Get("username"), "password": creds.Get("password")}
+	if err := saveJsonRequest(ctx, base+"/api/login", body, nil, &login); err != nil {
+		return errors.Wrapf(err, "Failed logging in")
+	}
+	session := login.Session
+	if session == "" {
+		session = login.Message.Session
+	}
+
+	tags := []map[string]string{}
+	for _, t := range p.Tags {
+		tags = append(tags, map[string]string{"name": t})
+	}
+	body = map[string]interface{}{"url": p.Link, "title": p.Title, "tags": tags}
+	return saveJsonRequest(ctx, base+"/api/bookmarks", body, http.Header{"X-Session-Id": {session}}, nil)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// Gaps between a feed's newest posts are measured over this many
+const POLL_RECENT_POSTS = 10
+
+// Feeds are polled this many times in their typical gap between posts
+const POLLS_PER_GAP = 4
+
+// Feeds whose newest post is older than this are polled at --max-interval
+const POLL_DORMANT_AFTER = 30 * 24 * time.Hour
+
+// Polls are moved by up to this fraction of their interval either way, so
+// feeds fetched together drift apart
+const POLL_JITTER = 0.2
+
+type cacheOnlyKey struct{}
+
+// A context whose fetches reuse cached responses however old, fetching only
+// what isn't cached. Serve reads feeds that aren't due yet with it on start.
+func withCacheOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheOnlyKey{}, true)
+}
+
+func cacheOnly(ctx context.Context) bool {
+	only, _ := ctx.Value(cacheOnlyKey{}).(bool)
+	return only
+}
+
+// When to poll a feed next, an interval from now, moved by up to POLL_JITTER
+// of it. Feeds first fetched together at start are spread over half to one
+// and a half intervals instead.
+func nextPollTime(now time.Time, interval time.Duration, first bool) time.Time {
+	if first {
+		return now.Add(interval/2 + time.Duration(rand.Int63n(int64(interval)+1)))
+	}
+	spread := int64(float64(interval) * POLL_JITTER)
+	return now.Add(interval + time.Duration(rand.Int63n(2*spread+1)-spread))
+}
+
+// How long serve waits before polling the feed again: its interval in the
+// config if it has one, otherwise a fraction of the median gap between its
+// recent posts, between --interval and --max-interval. Busy feeds are polled
+// every --interval, dormant ones rarely.
+func (s *server) pollInterval(f *url.URL, posts []*Post, now time.Time) time.Duration {
+	if interval := config.feed(f).Interval; interval > 0 {
+		return interval
+	}
+	min, max := s.serveOpts.Interval, s.serveOpts.MaxInterval
+	if max <= min {
+		return min
+	}
+	if len(posts) < 2 {
+		return max
+	}
+
+	times := []time.Time{}
+	for _, p := range posts {
+		times = append(times, *p.Timestamp)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].After(times[j]) })
+	if now.Sub(times[0]) > POLL_DORMANT_AFTER {
+		return max
+	}
+	if len(times) > POLL_RECENT_POSTS {
+		times = times[:POLL_RECENT_POSTS]
+	}
+	gaps := []time.Duration{}
+	for i := 1; i < len(times); i++ {
+		gaps = append(gaps, times[i-1].Sub(times[i]))
+	}
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+
+	interval := gaps[len(gaps)/2] / POLLS_PER_GAP
+	if interval < min {
+		return min
+	}
+	if interval > max {
+		return max
+	}
+	return interval
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/sha256"
+	"math/big"
+)
+
+// BIP-340 Schnorr signatures over secp256k1, which Nostr events are signed
+// with. Only verifying is needed, so it's done with math/big rather than
+// pulling in a curve library: slow, but a feed's events are few.
+
+var (
+	secpP, _  = new(big.Int).SetString("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f", 16)
+	secpN, _  = new(big.Int).SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
+	secpGx, _ = new(big.Int).SetString("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", 16)
+	secpGy, _ = new(big.Int).SetString("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8", 16)
+)
+
+// A point on the curve, nil being the point at infinity
+type secpPoint struct {
+	x, y *big.Int
+}
+
+func secpAdd(a, b *secpPoint) *secpPoint {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	var lambda *big.Int
+	if a.x.Cmp(b.x) == 0 {
+		if a.y.Cmp(b.y) != 0 || a.y.Sign() == 0 {
+			return nil
+		}
+		// Doubling: 3x² / 2y
+		num := new(big.Int).Mul(a.x, a.x)
+		num.Mul(num, big.NewInt(3))
+		den := new(big.Int).Lsh(a.y, 1)
+		lambda = num.Mul(num, den.ModInverse(den, secpP))
+	} else {
+		num := new(big.Int).Sub(b.y, a.y)
+		den := new(big.Int).Sub(b.x, a.x)
+		den.Mod(den, secpP)
+		lambda = num.Mul(num, den.ModInverse(den, secpP))
+	}
+	lambda.Mod(lambda, secpP)
+	x := new(big.Int).Mul(lambda, lambda)
+	x.Sub(x, a.x).Sub(x, b.x).Mod(x, secpP)
+	y := new(big.Int).Sub(a.x, x)
+	y.Mul(y, lambda).Sub(y, a.y).Mod(y, secpP)
+	return &secpPoint{x, y}
+}
+
+func secpMul(p *secpPoint, k *big.Int) *secpPoint {
+	var r *secpPoint
+	for i := k.BitLen() - 1; i >= 0; i-- {
+		r = secpAdd(r, r)
+		if k.Bit(i) == 1 {
+			r = secpAdd(r, p)
+		}
+	}
+	return r
+}
+
+// The point with x and an even y, or nil if there's none
+func secpLiftX(x *big.Int) *secpPoint {
+	if x.Cmp(secpP) >= 0 {
+		return nil
+	}
+	c := new(big.Int).Exp(x, big.NewInt(3), secpP)
+	c.Add(c, big.NewInt(7)).Mod(c, secpP)
+	exp := new(big.Int).Add(secpP, big.NewInt(1))
+	y := new(big.Int).Exp(c, exp.Rsh(exp, 2), secpP)
+	if new(big.Int).Exp(y, big.NewInt(2), secpP).Cmp(c) != 0 {
+		return nil
+	}
+	if y.Bit(0) == 1 {
+		y.Sub(secpP, y)
+	}
+	return &secpPoint{x, y}
+}
+
+func taggedHash(tag string, parts ...[]byte) []byte {
+	t := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(t[:])
+	h.Write(t[:])
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// Whether sig is pubkey's BIP-340 signature of msg, all as bytes
+func schnorrVerify(pubkey, msg, sig []byte) bool {
+	if len(pubkey) != 32 || len(sig) != 64 {
+		return false
+	}
+	p := secpLiftX(new(big.Int).SetBytes(pubkey))
+	if p == nil {
+		return false
+	}
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if r.Cmp(secpP) >= 0 || s.Cmp(secpN) >= 0 {
+		return false
+	}
+	e := new(big.Int).SetBytes(taggedHash("BIP0340/challenge", sig[:32], pubkey, msg))
+	e.Mod(e, secpN)
+
+	// R = sG - eP
+	negE := new(big.Int).Sub(secpN, e)
+	R := secpAdd(secpMul(&secpPoint{secpGx, secpGy}, s), secpMul(p, negE))
+	return R != nil && R.y.Bit(0) == 0 && R.x.Cmp(r) == 0
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// A scoring rule from the config's [score] section, adding points to posts
+// matching all of its conditions:
+//
+//	+5 title:"go"
+//	-10 feed:"dealsite"
+//	+3 content:/\brust(lang)?\b/ feed:lobste.rs
+//
+// "Quoted" and bare values match anywhere in the field ignoring case, /slashed/
+// ones are regexps.
+type scoreRule struct {
+	points     int
+	conditions []scoreCondition
+}
+
+type scoreCondition struct {
+	field string
+	match func(string) bool
+}
+
+// Post text scoring rules can match, by field name
+var scoreFields = map[string]func(p *Post) []string{
+	"title":   func(p *Post) []string { return []string{p.Title} },
+	"content": func(p *Post) []string { return []string{p.Content} },
+	"link":    func(p *Post) []string { return []string{p.Link} },
+	"feed":    func(p *Post) []string { return []string{p.FeedAlias, p.FeedTitle, p.shortFeedLink(), p.FeedLink} },
+	"tag":     func(p *Post) []string { return p.Tags },
+}
+
+func parseScoreRule(line string) (*scoreRule, error) {
+	fields := strings.SplitN(line, " ", 2)
+	if len(fields) != 2 || (line[0] != '+' && line[0] != '-') {
+		return nil, fmt.Errorf("expected a rule like +5 title:\"go\", got %q", line)
+	}
+	points, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("bad points %q", fields[0])
+	}
+
+	rule := &scoreRule{points: points}
+	rest := strings.TrimSpace(fields[1])
+	for rest != "" {
+		colon := strings.Index(rest, ":")
+		if colon <= 0 {
+			return nil, fmt.Errorf("expected field:value, got %q", rest)
+		}
+		field := rest[:colon]
+		if _, ok := scoreFields[field]; !ok {
+			return nil, fmt.Errorf("unknown field %q, expected one of %s", field, strings.Join(scoreFieldNames(), ", "))
+		}
+		rest = rest[colon+1:]
+
+		// Quoted and slashed values run to their closing quote or slash,
+		// bare ones to the next space
+		var value string
+		end := strings.Index(rest, " ")
+		if end < 0 {
+			end = len(rest)
+		}
+		if rest != "" && (rest[0] == '"' || rest[0] == '/') {
+			end = strings.Index(rest[1:], rest[:1])
+			if end < 0 {
+				return nil, fmt.Errorf("missing closing %s in %q", rest[:1], line)
+			}
+			end += 2
+			value = rest[1 : end-1]
+		} else {
+			value = rest[:end]
+		}
+		if value == "" {
+			return nil, fmt.Errorf("no value for %s in %q", field, line)
+		}
+
+		condition := scoreCondition{field: field}
+		if rest[0] == '/' {
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return nil, err
+			}
+			condition.match = re.MatchString
+		} else {
+			lower := strings.ToLower(value)
+			condition.match = func(s string) bool { return strings.Contains(strings.ToLower(s), lower) }
+		}
+		rule.conditions = append(rule.conditions, condition)
+		rest = strings.TrimSpace(rest[end:])
+	}
+	if len(rule.conditions) == 0 {
+		return nil, fmt.Errorf("no conditions in %q", line)
+	}
+	return rule, nil
+}
+
+func scoreFieldNames() []string {
+	names := []string{}
+	for name := range scoreFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *scoreRule) matches(p *Post) bool {
+	for _, c := range r.conditions {
+		matched := false
+		for _, s := range scoreFields[c.field](p) {
+			if s != "" && c.match(s) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// Sum of the points of the config's rules the post matches
+func (p *Post) score() int {
+	score := 0
+	for _, r := range config.Scores {
+		if r.matches(p) {
+			score += r.points
+		}
+	}
+	return score
+}
+
+// --min-score, or the config's min-score if it isn't given, and whether either
+// is set
+func minPostScore() (int, bool) {
+	if flag.CommandLine.Changed("min-score") {
+		return *minScore, true
+	}
+	if config.MinScore != nil {
+		return *config.MinScore, true
+	}
+	return 0, false
+}
+
+// Sort posts newest first, or highest scoring first with --rank
+func sortPosts(posts []*Post) {
+	sort.Sort(ByTimestamp{posts})
+	if !*rank {
+		return
+	}
+	scores := map[*Post]int{}
+	for _, p := range posts {
+		scores[p] = p.score()
+	}
+	sort.SliceStable(posts, func(i, j int) bool {
+		return scores[posts[i]] > scores[posts[j]]
+	})
+}
+
+// Heading of the group the post is in: its date, or its score with --rank
+func groupHeading(p *Post, dateFormat string) string {
+	if *rank {
+		return fmt.Sprintf("Score %d", p.score())
+	}
+	return p.Timestamp.Format(dateFormat)
+}
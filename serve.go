@@ -0,0 +1,528 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const SSE_KEEPALIVE = 30 * time.Second
+
+// How long to wait for open requests on shutdown
+const SHUTDOWN_TIMEOUT = 5 * time.Second
+
+type serveOptions struct {
+	// Http address, "" to disable
+	Listen string
+	// Gemini address, "" to disable
+	Gemini   string
+	Interval time.Duration
+	// Longest feeds go between polls, see pollInterval
+	MaxInterval time.Duration
+	Cards       bool
+	// Summarize posts with fetchSummaries
+	Summarize bool
+	// Resolve post links with resolveLinks
+	Canonical bool
+	// Expand shortened links with unshortenLinks
+	Unshorten bool
+	// Feed urls and files, parsed again on SIGHUP
+	FeedArgs []string
+	// Credentials required for every request, nil to allow anyone
+	Auth *serveAuth
+	// Serve https with this, see serveTlsConfig, nil for plain http
+	TLS *tls.Config
+}
+
+// Serves the html render of feeds, refetching every interval and pushing new
+// posts to open pages over server-sent events
+type server struct {
+	// Feeds as parsed, and as fetched this poll with follow-opml lines
+	// resolved and --skip applied
+	sources   []*url.URL
+	feeds     []*url.URL
+	opts      htmlOptions
+	serveOpts serveOptions
+
+	// Reloads the config and feeds when sent to
+	reload chan bool
+
+	mu      sync.Mutex
+	posts   []*Post
+	seen    map[string]bool
+	clients map[chan []*Post]bool
+	// Feed url -> its posts from the last fetch, before filtering, and when
+	// it's next due to be fetched, for /feeds
+	feedPosts map[string][]*Post
+	nextPoll  map[string]time.Time
+}
+
+// Serves until SIGINT or SIGTERM, then shuts down cleanly. SIGHUP rereads the
+// config and feeds files. Under systemd (Type=notify) readiness, reloads and
+// the watchdog are reported with sd_notify.
+func serve(ctx context.Context, feeds []*url.URL, opts htmlOptions, serveOpts serveOptions) error {
+	if serveOpts.Listen == "" && serveOpts.Gemini == "" {
+		return errors.New("Nothing to serve, --listen and --gemini are both empty")
+	}
+	// Gemini has no basic auth, so it would serve everything --auth protects
+	if serveOpts.Auth != nil && serveOpts.Gemini != "" {
+		return errors.New("--gemini can't be used with --auth, gemini requests aren't authenticated")
+	}
+
+	opts.Live = true
+	s := &server{
+		sources:   feeds,
+		opts:      opts,
+		serveOpts: serveOpts,
+		reload:    make(chan bool, 1),
+		seen:      map[string]bool{},
+		clients:   map[chan []*Post]bool{},
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			if sig != syscall.SIGHUP {
+				fmt.Fprintf(os.Stderr, "Shutting down\n")
+				sdNotify("STOPPING=1")
+				cancel()
+				return
+			}
+			select {
+			case s.reload <- true:
+			default:
+				// Already reloading
+			}
+		}
+	}()
+
+	// Listen before reporting ready, so requests aren't refused once it is
+	var httpListener, geminiListener net.Listener
+	var err error
+	if serveOpts.Listen != "" {
+		httpListener, err = net.Listen("tcp", serveOpts.Listen)
+		if err != nil {
+			return err
+		}
+		if serveOpts.TLS != nil {
+			httpListener = tls.NewListener(httpListener, serveOpts.TLS)
+			fmt.Fprintf(os.Stderr, "Serving on https://%s\n", serveOpts.Listen)
+		} else {
+			fmt.Fprintf(os.Stderr, "Serving on http://%s\n", serveOpts.Listen)
+		}
+	}
+	if serveOpts.Gemini != "" {
+		geminiListener, err = listenGemini(serveOpts.Gemini)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Serving on gemini://%s\n", serveOpts.Gemini)
+	}
+
+	polled := make(chan bool)
+	go func() {
+		s.poll(ctx)
+		close(polled)
+	}()
+
+	errc := make(chan error, 2)
+	servers := 0
+	if httpListener != nil {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", s.handleIndex)
+		mux.HandleFunc("/events", s.handleEvents)
+		mux.HandleFunc("/feed.atom", s.handleFeed)
+		mux.HandleFunc("/feed.json", s.handleFeed)
+		mux.HandleFunc("/feeds", s.handleFeeds)
+		mux.HandleFunc("/manifest.json", handleManifest)
+		mux.HandleFunc("/icon.svg", handleIcon)
+
+		// Probes don't have credentials, and learn nothing from these
+		root := http.NewServeMux()
+		root.HandleFunc("/healthz", handleHealthz)
+		root.HandleFunc("/readyz", s.handleReadyz)
+		root.Handle("/", serveOpts.Auth.wrap(mux))
+
+		srv := &http.Server{Handler: root}
+		servers++
+		go func() {
+			err := srv.Serve(httpListener)
+			if err == http.ErrServerClosed {
+				err = nil
+			}
+			errc <- err
+		}()
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+			defer shutdownCancel()
+			_ = srv.Shutdown(shutdownCtx)
+		}()
+	}
+	if geminiListener != nil {
+		servers++
+		go func() {
+			errc <- s.serveGemini(ctx, geminiListener)
+		}()
+	}
+
+	sdNotify("READY=1")
+	go sdWatchdog(ctx)
+
+	for i := 0; i < servers; i++ {
+		if err := <-errc; err != nil && ctx.Err() == nil {
+			return err
+		}
+		cancel()
+	}
+	// Let an in progress poll save its state
+	<-polled
+	return nil
+}
+
+// Reread the config, feeds, hooks and plugins, keeping the current ones if any
+// fails
+func (s *server) reloadFeeds() {
+	sdNotify("RELOADING=1")
+	defer sdNotify("READY=1")
+
+	c, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: failed reloading config: %v\n", err)
+		return
+	}
+	h, err := loadHooks()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: failed reloading hooks: %v\n", err)
+		return
+	}
+	pl, err := loadPlugins()
+	if err != nil {
+		h.close()
+		fmt.Fprintf(os.Stderr, "ERROR: failed reloading plugins: %v\n", err)
+		return
+	}
+
+	// Handlers read the config under s.mu, and the feeds files name aliases
+	// from the new one
+	s.mu.Lock()
+	oldConfig := config
+	config = c
+	feeds, err := parseFeedArgs(s.serveOpts.FeedArgs)
+	if err != nil {
+		config = oldConfig
+		s.mu.Unlock()
+		h.close()
+		pl.close()
+		fmt.Fprintf(os.Stderr, "ERROR: failed reloading feeds: %v\n", err)
+		return
+	}
+	s.sources = feeds
+	s.mu.Unlock()
+
+	hooks.close()
+	hooks = h
+	plugins.close()
+	plugins = pl
+	fmt.Fprintf(os.Stderr, "Reloaded %d feeds\n", len(feeds))
+}
+
+// Fetch each feed when it's due, see pollInterval and nextPollTime,
+// broadcasting posts not previously seen. Feeds that aren't due keep their
+// last posts. Due times are kept in the state, feeds not yet due when serve
+// starts are read from the cache.
+func (s *server) poll(ctx context.Context) {
+	nextDue := map[string]time.Time{}
+	feedPosts := map[string][]*Post{}
+	for {
+		feeds := skipFeeds(followOpmls(ctx, s.sources))
+		s.mu.Lock()
+		s.feeds = feeds
+		s.mu.Unlock()
+
+		now := time.Now()
+		due := []*url.URL{}
+		// Feeds not due yet, but without posts since serve started, which are
+		// read from the cache
+		cached := []*url.URL{}
+		for _, f := range s.feeds {
+			next, ok := nextDue[f.String()]
+			if !ok {
+				next = state.nextPoll(f.String())
+			}
+			if !now.Before(next) {
+				due = append(due, f)
+			} else if _, ok := feedPosts[f.String()]; !ok {
+				nextDue[f.String()] = next
+				cached = append(cached, f)
+			}
+		}
+
+		fetched := map[string][]*Post{}
+		duePosts := fetchAll(ctx, due)
+		if len(cached) > 0 {
+			duePosts = append(duePosts, fetchAll(withCacheOnly(ctx), cached)...)
+		}
+		duePosts = transformPosts(duePosts)
+		if s.serveOpts.Canonical {
+			resolveLinks(ctx, duePosts)
+		} else if s.serveOpts.Unshorten {
+			unshortenLinks(ctx, duePosts)
+		}
+		for _, p := range duePosts {
+			fetched[p.FeedLink] = append(fetched[p.FeedLink], p)
+		}
+		if ctx.Err() == nil {
+			for _, f := range cached {
+				if posts, ok := fetched[f.String()]; ok {
+					feedPosts[f.String()] = posts
+				}
+			}
+			for _, f := range due {
+				// Keep the previous posts if the fetch failed
+				if posts, ok := fetched[f.String()]; ok {
+					feedPosts[f.String()] = posts
+				}
+				_, scheduled := nextDue[f.String()]
+				next := nextPollTime(now, s.pollInterval(f, feedPosts[f.String()], now), !scheduled)
+				nextDue[f.String()] = next
+				state.setNextPoll(f.String(), next)
+			}
+		}
+		if err := state.save(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: failed saving state: %v\n", err)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		all := []*Post{}
+		for _, f := range s.feeds {
+			all = append(all, feedPosts[f.String()]...)
+		}
+		posts := filterPosts(all)
+		favicons := fetchFavicons(ctx, posts)
+		var cards map[string]*Card
+		if s.serveOpts.Cards {
+			cards = fetchCards(ctx, posts)
+		}
+		var summaries map[string]string
+		if s.serveOpts.Summarize {
+			// Only new posts are summarized, the rest keep the summaries they
+			// had. s.opts is only changed by this loop, so it's read unlocked.
+			summaries = map[string]string{}
+			fresh := []*Post{}
+			for _, p := range posts {
+				if !s.seen[p.id()] {
+					fresh = append(fresh, p)
+				} else if summary, ok := s.opts.Summaries[p.Link]; ok {
+					summaries[p.Link] = summary
+				}
+			}
+			for link, summary := range fetchSummaries(ctx, fresh) {
+				summaries[link] = summary
+			}
+		}
+
+		s.mu.Lock()
+		s.opts.Favicons = favicons
+		s.opts.Cards = cards
+		s.opts.Summaries = summaries
+
+		newPosts := []*Post{}
+		for _, p := range posts {
+			if !s.seen[p.id()] {
+				s.seen[p.id()] = true
+				newPosts = append(newPosts, p)
+			}
+		}
+		// Everything is new on the first fetch, but there's nobody to tell
+		notify := s.posts != nil && len(newPosts) > 0
+		if notify {
+			for c := range s.clients {
+				select {
+				case c <- newPosts:
+				default:
+					// Client isn't keeping up, it'll see the posts on refresh
+				}
+			}
+		}
+		s.posts = posts
+		s.feedPosts = map[string][]*Post{}
+		s.nextPoll = map[string]time.Time{}
+		// Wake for the first feed due, checking at least every --interval
+		wake := time.Now().Add(s.serveOpts.Interval)
+		for _, f := range s.feeds {
+			s.feedPosts[f.String()] = feedPosts[f.String()]
+			next := nextDue[f.String()]
+			if next.Before(wake) {
+				wake = next
+			}
+			if retry, ok := state.health(f.String()).retryAt(); ok && retry.After(next) {
+				next = retry
+			}
+			s.nextPoll[f.String()] = next
+		}
+		s.mu.Unlock()
+		if notify {
+			hooks.onPosts(newPosts)
+			ttrssMarkRead(ctx, newPosts)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.reload:
+			s.reloadFeeds()
+		case <-time.After(time.Until(wake)):
+		}
+	}
+}
+
+func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	renderHtml(w, append([]*Post{}, s.posts...), "Jan 2006", s.opts)
+}
+
+// The merged posts as an Atom or JSON feed, for other readers to subscribe to
+func (s *server) handleFeed(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	posts := append([]*Post{}, s.posts...)
+	s.mu.Unlock()
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	selfUrl := scheme + "://" + r.Host + r.URL.Path
+	if r.URL.Path == "/feed.json" {
+		w.Header().Set("Content-Type", "application/feed+json; charset=utf-8")
+		renderJsonFeed(w, posts, selfUrl)
+		return
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	renderAtom(w, posts, selfUrl)
+}
+
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	c := make(chan []*Post, 10)
+	s.mu.Lock()
+	s.clients[c] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(SSE_KEEPALIVE):
+			fmt.Fprintf(w, ": keepalive\n\n")
+		case posts := <-c:
+			// Oldest first, so the page ends up with the newest post on top
+			sort.Sort(sort.Reverse(ByTimestamp{posts}))
+			for _, p := range posts {
+				s.writeEvent(w, p)
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func (s *server) writeEvent(w http.ResponseWriter, p *Post) {
+	s.mu.Lock()
+	opts := s.opts
+	s.mu.Unlock()
+
+	var buf bytes.Buffer
+	renderHtmlPost(&buf, p, opts)
+	data, _ := json.Marshal(map[string]string{
+		"date": p.Timestamp.Format("Jan 2006"),
+		"html": buf.String(),
+	})
+	fmt.Fprintf(w, "event: post\ndata: %s\n\n", data)
+}
+
+const manifest = `{
+	"name": "Picofeed",
+	"short_name": "Picofeed",
+	"start_url": ".",
+	"display": "standalone",
+	"background_color": "#fff",
+	"theme_color": "#000",
+	"icons": [{"src": "icon.svg", "sizes": "any", "type": "image/svg+xml"}]
+}
+`
+
+const icon = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 64 64">
+<rect width="64" height="64" rx="12" fill="#000"/>
+<circle cx="18" cy="46" r="5" fill="#fff"/>
+<path d="M13 28a23 23 0 0 1 23 23M13 14a37 37 0 0 1 37 37" stroke="#fff" stroke-width="6" fill="none"/>
+</svg>
+`
+
+// Up as long as it's serving
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, "ok\n")
+}
+
+// Ready once the first fetch of the feeds has finished
+func (s *server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	ready := s.posts != nil
+	s.mu.Unlock()
+
+	if !ready {
+		http.Error(w, "Fetching feeds", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprint(w, "ok\n")
+}
+
+func handleManifest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/manifest+json")
+	fmt.Fprint(w, manifest)
+}
+
+func handleIcon(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "image/svg+xml")
+	fmt.Fprint(w, icon)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Posts from different feeds this close together in time can be collapsed as
+// the same story
+const SIMILAR_WINDOW = 72 * time.Hour
+
+// Share of title words two posts need in common to be collapsed, see
+// titleSimilarity
+const SIMILAR_THRESHOLD = 0.75
+
+// Titles with fewer words aren't collapsed, "Weekly update" says little
+const SIMILAR_MIN_WORDS = 4
+
+// Where else a post collapsed by --collapse-similar was published
+type Alternate struct {
+	Title     string `json:"title"`
+	Link      string `json:"link"`
+	FeedTitle string `json:"feed_title"`
+	FeedLink  string `json:"feed_link"`
+}
+
+// Collapse posts from different feeds with near identical titles published
+// within SIMILAR_WINDOW of each other (syndicated news, press releases) into
+// the earliest of them, listing the rest as its Alternates. Posts given
+// Alternates are copies, serve keeps the originals across polls and renders
+// them concurrently.
+func collapseSimilar(posts []*Post) []*Post {
+	byTime := append([]*Post{}, posts...)
+	sort.SliceStable(byTime, func(i, j int) bool {
+		return byTime[i].Timestamp.Before(*byTime[j].Timestamp)
+	})
+
+	words := map[*Post][]string{}
+	for _, p := range byTime {
+		words[p] = titleWords(p.Title)
+	}
+
+	collapsed := map[*Post]bool{}
+	alternates := map[*Post][]*Alternate{}
+	for i, p := range byTime {
+		if collapsed[p] || len(words[p]) < SIMILAR_MIN_WORDS {
+			continue
+		}
+		for _, other := range byTime[i+1:] {
+			if other.Timestamp.Sub(*p.Timestamp) > SIMILAR_WINDOW {
+				break
+			}
+			if collapsed[other] || other.FeedLink == p.FeedLink || len(words[other]) < SIMILAR_MIN_WORDS {
+				continue
+			}
+			if titleSimilarity(words[p], words[other]) < SIMILAR_THRESHOLD {
+				continue
+			}
+			collapsed[other] = true
+			alternates[p] = append(alternates[p], &Alternate{
+				Title:     other.Title,
+				Link:      other.Link,
+				FeedTitle: other.feedName(),
+				FeedLink:  other.FeedLink,
+			})
+		}
+	}
+
+	kept := []*Post{}
+	for _, p := range posts {
+		if collapsed[p] {
+			continue
+		}
+		if alts, ok := alternates[p]; ok {
+			copied := *p
+			copied.Alternates = alts
+			p = &copied
+		}
+		kept = append(kept, p)
+	}
+	return kept
+}
+
+// Lowercased words of a title, without punctuation
+func titleWords(title string) []string {
+	return strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Dice coefficient of two titles' sets of words, 1 for the same words in any
+// order
+func titleSimilarity(a []string, b []string) float64 {
+	setA := map[string]bool{}
+	for _, w := range a {
+		setA[w] = true
+	}
+	setB := map[string]bool{}
+	for _, w := range b {
+		setB[w] = true
+	}
+	common := 0
+	for w := range setA {
+		if setB[w] {
+			common++
+		}
+	}
+	return 2 * float64(common) / float64(len(setA)+len(setB))
+}
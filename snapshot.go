@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The merged posts of some feeds at one point in time, written by snapshot
+// and compared by diff
+type snapshot struct {
+	Taken time.Time `json:"taken"`
+	// Feed urls, refetched by diff when it's given a single snapshot
+	Feeds []string        `json:"feeds"`
+	Posts []*snapshotPost `json:"posts"`
+}
+
+type snapshotPost struct {
+	*Post
+	// Content isn't kept, just enough to tell if it changed
+	ContentHash string `json:"content_hash"`
+}
+
+func newSnapshot(feeds []*url.URL, posts []*Post, now time.Time) *snapshot {
+	snap := &snapshot{Taken: now, Feeds: []string{}, Posts: []*snapshotPost{}}
+	for _, f := range feeds {
+		snap.Feeds = append(snap.Feeds, f.String())
+	}
+	for _, p := range posts {
+		snap.Posts = append(snap.Posts, &snapshotPost{Post: p, ContentHash: p.contentHash()})
+	}
+	return snap
+}
+
+func readSnapshot(path string) (*snapshot, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	snap := &snapshot{}
+	if err := json.Unmarshal(contents, snap); err != nil {
+		return nil, errors.Wrapf(err, "%q isn't a snapshot", path)
+	}
+	return snap, nil
+}
+
+func writeSnapshot(f io.Writer, snap *snapshot) {
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(snap)
+}
+
+type snapshotChange struct {
+	old *snapshotPost
+	new *snapshotPost
+	// What changed, for posts in both
+	fields []string
+}
+
+// Posts only in cur, only in old, and in both but edited in between
+func diffSnapshots(old *snapshot, cur *snapshot) (added, removed, changed []*snapshotChange) {
+	oldPosts := map[string]*snapshotPost{}
+	for _, p := range old.Posts {
+		oldPosts[p.id()] = p
+	}
+	newIds := map[string]bool{}
+	for _, p := range cur.Posts {
+		newIds[p.id()] = true
+		o, ok := oldPosts[p.id()]
+		if !ok {
+			added = append(added, &snapshotChange{new: p})
+			continue
+		}
+		fields := []string{}
+		if o.Title != p.Title {
+			fields = append(fields, "title")
+		}
+		if o.Link != p.Link {
+			fields = append(fields, "link")
+		}
+		if o.ContentHash != p.ContentHash {
+			fields = append(fields, "content")
+		}
+		if len(fields) > 0 {
+			changed = append(changed, &snapshotChange{old: o, new: p, fields: fields})
+		}
+	}
+	for _, p := range old.Posts {
+		if !newIds[p.id()] {
+			removed = append(removed, &snapshotChange{old: p})
+		}
+	}
+	return added, removed, changed
+}
+
+// Print posts added (+), removed (-) and changed (~) from old to cur, newest
+// first within each
+func renderDiff(f io.Writer, old *snapshot, cur *snapshot) {
+	added, removed, changed := diffSnapshots(old, cur)
+	fmt.Fprintf(f, "From %s to %s\n", old.Taken.Format(time.RFC3339), cur.Taken.Format(time.RFC3339))
+
+	list := func(mark string, changes []*snapshotChange, post func(*snapshotChange) *snapshotPost) {
+		sort.SliceStable(changes, func(i, j int) bool {
+			return post(changes[i]).Timestamp.After(*post(changes[j]).Timestamp)
+		})
+		for _, c := range changes {
+			p := post(c)
+			fmt.Fprintf(f, "%s %-70v %s\n", mark, p.Title, p.Link)
+			for _, field := range c.fields {
+				switch field {
+				case "title":
+					fmt.Fprintf(f, "    title was %q\n", c.old.Title)
+				case "link":
+					fmt.Fprintf(f, "    link was %s\n", c.old.Link)
+				case "content":
+					fmt.Fprintf(f, "    content changed\n")
+				}
+			}
+		}
+	}
+	oldPost := func(c *snapshotChange) *snapshotPost { return c.old }
+	newPost := func(c *snapshotChange) *snapshotPost { return c.new }
+	list("+", added, newPost)
+	list("-", removed, oldPost)
+	list("~", changed, newPost)
+
+	fmt.Fprintf(f, "%d added, %d removed, %d changed\n", len(added), len(removed), len(changed))
+}
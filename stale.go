@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	gohtml "html"
+	"io"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// With --since all, feeds are stale without a post in this long
+const STALE_AFTER = 90 * 24 * time.Hour
+
+// A feed with no posts since the cutoff, see staleFeeds
+type staleFeed struct {
+	Name string
+	URL  string
+	// Zero if it's never had a post
+	LastPost time.Time
+}
+
+// Feeds among feeds whose newest known post is from before the --since cutoff,
+// longest silent first. Feeds that failed to fetch count by the posts they had
+// on earlier runs.
+func staleFeeds(feeds []*url.URL) ([]*staleFeed, time.Time) {
+	since := time.Now().Add(-STALE_AFTER)
+	if cutoff != nil {
+		since = cutoff.time()
+	}
+
+	stale := []*staleFeed{}
+	for _, f := range feeds {
+		h := state.health(f.String())
+		if h != nil && !h.LastPost.Before(since) {
+			continue
+		}
+		fc := config.feed(f)
+		name := f.Host
+		if fc.Title != "" {
+			name = fc.Title
+		} else if fc.Alias != "" {
+			name = fc.Alias
+		}
+		sf := &staleFeed{Name: name, URL: f.String()}
+		if h != nil {
+			sf.LastPost = h.LastPost
+		}
+		stale = append(stale, sf)
+	}
+	sort.SliceStable(stale, func(i, j int) bool {
+		return stale[i].LastPost.Before(stale[j].LastPost)
+	})
+	return stale, since
+}
+
+func (sf *staleFeed) lastPost() string {
+	if sf.LastPost.IsZero() {
+		return "no posts seen"
+	}
+	return "last post " + sf.LastPost.Format("Jan 2 2006")
+}
+
+func renderStale(f io.Writer, stale []*staleFeed, since time.Time) {
+	if len(stale) == 0 {
+		return
+	}
+	fmt.Fprintf(f, "Stale feeds, no posts since %s\n", since.Format("Jan 2 2006"))
+	for _, sf := range stale {
+		fmt.Fprintf(f, "    %-40v %-24s %s\n", truncate(sf.Name, 40), sf.lastPost(), sf.URL)
+	}
+}
+
+func renderStaleHtml(f io.Writer, stale []*staleFeed, since time.Time) {
+	if len(stale) == 0 {
+		return
+	}
+	fmt.Fprintf(f, "<h4>Stale feeds, no posts since %s</h4>\n", since.Format("Jan 2 2006"))
+	for _, sf := range stale {
+		fmt.Fprintf(f, "<div class=\"stale\"><a href=\"%s\">%s</a> (%s)</div>\n", gohtml.EscapeString(safeUrl(sf.URL, true)), gohtml.EscapeString(sf.Name), sf.lastPost())
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// State kept between runs, in ~/.local/state/picofeed/state.json
+type State struct {
+	mu sync.Mutex
+
+	// Layout of the file, see stateMigrations
+	Version int `json:"version"`
+
+	// Feed url -> time before which it shouldn't be fetched, after a 429/503
+	Backoff map[string]time.Time `json:"backoff"`
+	// Post id -> when it was last fetched and its content, to tell which posts
+	// are new or updated
+	Posts map[string]*seenPost `json:"posts"`
+	// Page url -> the feed autodiscovery found for it, so the page isn't
+	// fetched again every run
+	Discovered map[string]string `json:"discovered"`
+	// Feed url -> how its recent fetches went, to skip feeds that keep failing
+	Health map[string]*feedHealth `json:"health"`
+	// Feed url -> when serve next polls it, so a restart doesn't refetch
+	// every feed at once
+	NextPoll map[string]time.Time `json:"next_poll,omitempty"`
+}
+
+type feedHealth struct {
+	// Failed fetches in a row
+	Failures    int       `json:"failures"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastAttempt time.Time `json:"last_attempt"`
+	LastSuccess time.Time `json:"last_success,omitempty"`
+	// Publish time of the newest post it had
+	LastPost time.Time `json:"last_post,omitempty"`
+}
+
+type seenPost struct {
+	LastSeen time.Time `json:"last_seen"`
+	// sha1 of the post's content
+	Hash string `json:"hash"`
+}
+
+// Forget posts that haven't been fetched in this long
+const SEEN_MAX_AGE = 90 * 24 * time.Hour
+
+// Feeds failing this many runs in a row are skipped, retried after
+// FAILING_RETRY, then twice as long after each further failure up to
+// FAILING_MAX_RETRY
+const FAILING_AFTER = 3
+const FAILING_RETRY = 6 * time.Hour
+const FAILING_MAX_RETRY = 7 * 24 * time.Hour
+
+// Changes to the state file's layout, stateMigrations[i] upgrading a state
+// of version i to i+1. Add one when the layout changes so existing state
+// isn't lost on upgrade.
+var stateMigrations = []func(s map[string]interface{}) error{
+	// Before versioning, the same layout as version 1
+	func(s map[string]interface{}) error { return nil },
+}
+
+// Version of the state file written by this picofeed
+var stateVersion = len(stateMigrations)
+
+var state = newState()
+
+func newState() *State {
+	return &State{
+		Version:    stateVersion,
+		Backoff:    map[string]time.Time{},
+		Posts:      map[string]*seenPost{},
+		Discovered: map[string]string{},
+		Health:     map[string]*feedHealth{},
+		NextPoll:   map[string]time.Time{},
+	}
+}
+
+func loadState() (*State, error) {
+	path, err := statePath("state.json")
+	if err != nil {
+		return nil, err
+	}
+
+	s := newState()
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	contents, err = migrateState(path, contents)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed migrating %q", path)
+	}
+	if err := json.Unmarshal(contents, s); err != nil {
+		return nil, errors.Wrapf(err, "Failed reading %q", path)
+	}
+	s.Version = stateVersion
+	return s, nil
+}
+
+// Upgrade the state file's contents to stateVersion, keeping a copy of the
+// old file as state.json.v<version> in case the upgrade goes wrong
+func migrateState(path string, contents []byte) ([]byte, error) {
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(contents, &raw); err != nil {
+		return nil, err
+	}
+	version := 0
+	if v, ok := raw["version"].(float64); ok {
+		version = int(v)
+	}
+	if version == stateVersion {
+		return contents, nil
+	}
+	if version > stateVersion {
+		return nil, fmt.Errorf("State is version %d, from a newer picofeed than this one (version %d)", version, stateVersion)
+	}
+
+	if err := ioutil.WriteFile(fmt.Sprintf("%s.v%d", path, version), contents, 0644); err != nil {
+		return nil, err
+	}
+	for ; version < stateVersion; version++ {
+		if err := stateMigrations[version](raw); err != nil {
+			return nil, errors.Wrapf(err, "Version %d to %d", version, version+1)
+		}
+		raw["version"] = version + 1
+	}
+	fmt.Fprintf(os.Stderr, "Upgraded state to version %d\n", version)
+	return json.Marshal(raw)
+}
+
+func (s *State) save() error {
+	path, err := statePath("state.json")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	contents, err := json.MarshalIndent(s, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Write then rename so an interrupted save doesn't lose the state
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, contents, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Time before which feedUrl shouldn't be fetched, if it's backing off
+func (s *State) backoff(feedUrl string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	until, ok := s.Backoff[feedUrl]
+	if ok && time.Now().After(until) {
+		delete(s.Backoff, feedUrl)
+		return time.Time{}, false
+	}
+	return until, ok
+}
+
+func (s *State) setBackoff(feedUrl string, until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if until.IsZero() {
+		delete(s.Backoff, feedUrl)
+	} else {
+		s.Backoff[feedUrl] = until
+	}
+}
+
+// When serve last scheduled feedUrl to be polled, zero if it hasn't
+func (s *State) nextPoll(feedUrl string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.NextPoll[feedUrl]
+}
+
+func (s *State) setNextPoll(feedUrl string, next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.NextPoll == nil {
+		s.NextPoll = map[string]time.Time{}
+	}
+	s.NextPoll[feedUrl] = next
+}
+
+// Feed previously discovered for pageUrl
+func (s *State) discovered(pageUrl string) (*url.URL, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	feed, ok := s.Discovered[pageUrl]
+	if !ok {
+		return nil, false
+	}
+	u, err := url.Parse(feed)
+	return u, err == nil
+}
+
+// Remember feedUrl for pageUrl, or forget it if nil
+func (s *State) setDiscovered(pageUrl string, feedUrl *url.URL) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if feedUrl == nil {
+		delete(s.Discovered, pageUrl)
+		return
+	}
+	if s.Discovered == nil {
+		s.Discovered = map[string]string{}
+	}
+	s.Discovered[pageUrl] = feedUrl.String()
+}
+
+// Record how fetching feedUrl went and the posts it had, err is nil if it
+// succeeded
+func (s *State) recordFetch(feedUrl string, posts []*Post, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Health == nil {
+		s.Health = map[string]*feedHealth{}
+	}
+	h, ok := s.Health[feedUrl]
+	if !ok {
+		h = &feedHealth{}
+		s.Health[feedUrl] = h
+	}
+	h.LastAttempt = time.Now()
+	if err != nil {
+		h.Failures++
+		h.LastError = err.Error()
+	} else {
+		h.Failures = 0
+		h.LastError = ""
+		h.LastSuccess = h.LastAttempt
+	}
+	for _, p := range posts {
+		if p.Timestamp.After(h.LastPost) {
+			h.LastPost = *p.Timestamp
+		}
+	}
+}
+
+// How fetching feedUrl has gone, nil if it's never been fetched
+func (s *State) health(feedUrl string) *feedHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.Health[feedUrl]
+	if !ok {
+		return nil
+	}
+	copied := *h
+	return &copied
+}
+
+// When a feed that's failed FAILING_AFTER runs in a row should next be tried,
+// and whether it's failing
+func (h *feedHealth) retryAt() (time.Time, bool) {
+	if h == nil || h.Failures < FAILING_AFTER {
+		return time.Time{}, false
+	}
+	wait := FAILING_RETRY
+	for i := FAILING_AFTER; i < h.Failures && wait < FAILING_MAX_RETRY; i++ {
+		wait *= 2
+	}
+	if wait > FAILING_MAX_RETRY {
+		wait = FAILING_MAX_RETRY
+	}
+	return h.LastAttempt.Add(wait), true
+}
+
+// Record posts as seen, returning those that weren't seen on a previous run.
+// Posts seen before whose content has changed since are marked Updated.
+func (s *State) markSeen(posts []*Post) []*Post {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, seen := range s.Posts {
+		if now.Sub(seen.LastSeen) > SEEN_MAX_AGE {
+			delete(s.Posts, id)
+		}
+	}
+
+	unseen := []*Post{}
+	for _, p := range posts {
+		hash := p.contentHash()
+		seen, ok := s.Posts[p.id()]
+		if !ok {
+			unseen = append(unseen, p)
+			seen = &seenPost{Hash: hash}
+			s.Posts[p.id()] = seen
+		}
+		if seen.Hash != hash {
+			p.Updated = true
+			if p.UpdatedAt == nil {
+				updatedAt := now
+				p.UpdatedAt = &updatedAt
+			}
+			seen.Hash = hash
+		}
+		seen.LastSeen = now
+	}
+	return unseen
+}
+
+// Identifies a post across runs, even if its title or link are edited: its
+// feed and GUID, or a hash of its link if the feed doesn't give GUIDs
+func (p *Post) id() string {
+	if p.GUID != "" {
+		return p.FeedLink + " " + p.GUID
+	}
+	sum := sha1.Sum([]byte(p.Link))
+	return p.FeedLink + " " + hex.EncodeToString(sum[:])
+}
+
+func (p *Post) contentHash() string {
+	sum := sha1.Sum([]byte(p.Content))
+	return hex.EncodeToString(sum[:])
+}
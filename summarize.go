@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	gohtml "html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Models are slow, especially local ones, so only a couple of posts are
+// summarized at once, each given up to SUMMARY_TIMEOUT
+const SUMMARY_CONCURRENCY = 2
+const SUMMARY_TIMEOUT = 2 * time.Minute
+
+// Most of a post's text sent to be summarized, in bytes
+const SUMMARY_MAX_INPUT = 12000
+
+const SUMMARY_PROMPT = "Summarize this blog post in two plain sentences. Reply with only the summary.\n\n"
+
+// Summarize each post with content using the model at --summarize-url,
+// returning link -> summary. Summaries are cached on disk by the post and its
+// content, so each post is only summarized once (and again if it changes).
+func fetchSummaries(ctx context.Context, posts []*Post) map[string]string {
+	// Hosted APIs need a key, stored in the keyring as "summarize". Local
+	// servers with an OpenAI style API often don't, so it's fine to have none.
+	apiKey := ""
+	if !ollamaApi() {
+		apiKey, _ = keyringSecret("summarize")
+	}
+
+	todo := []*Post{}
+	for _, p := range posts {
+		if strings.TrimSpace(p.Content) != "" {
+			todo = append(todo, p)
+		}
+	}
+	if len(todo) > 0 {
+		fmt.Fprintf(os.Stderr, "Summarizing %d posts\n", len(todo))
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, SUMMARY_CONCURRENCY)
+	summaries := map[string]string{}
+	for _, p := range todo {
+		wg.Add(1)
+		go func(p *Post) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				return
+			}
+
+			summary, err := cachedSummary(ctx, p, apiKey)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed summarizing %q: %v\n", p.Link, err)
+				return
+			}
+			if summary == "" {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			summaries[p.Link] = summary
+		}(p)
+	}
+	wg.Wait()
+
+	return summaries
+}
+
+func cachedSummary(ctx context.Context, p *Post, apiKey string) (string, error) {
+	text := postText(p)
+	sum := sha1.Sum([]byte(*summarizeModel + "\n" + p.id() + "\n" + text))
+	path, err := cachePath("summaries", hex.EncodeToString(sum[:])+".txt")
+	if err != nil {
+		return "", err
+	}
+
+	if contents, err := ioutil.ReadFile(path); err == nil {
+		return string(contents), nil
+	}
+	if *offline {
+		return "", nil
+	}
+
+	summary, err := requestSummary(ctx, text, apiKey)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	return summary, ioutil.WriteFile(path, []byte(summary), 0644)
+}
+
+// The post's title and content as plain text, cut to SUMMARY_MAX_INPUT
+func postText(p *Post) string {
+	text := gohtml.UnescapeString(tagRegex.ReplaceAllString(p.Content, " "))
+	text = p.Title + "\n\n" + strings.Join(strings.Fields(text), " ")
+	if len(text) > SUMMARY_MAX_INPUT {
+		text = strings.ToValidUTF8(text[:SUMMARY_MAX_INPUT], "")
+	}
+	return text
+}
+
+// Whether --summarize-url is Ollama's API, rather than an OpenAI style chat
+// completions endpoint
+func ollamaApi() bool {
+	return strings.HasSuffix(strings.TrimSuffix(*summarizeUrl, "/"), "/api/generate")
+}
+
+// Ask the model for a summary of text
+func requestSummary(ctx context.Context, text string, apiKey string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, SUMMARY_TIMEOUT)
+	defer cancel()
+
+	var body interface{}
+	if ollamaApi() {
+		body = map[string]interface{}{"model": *summarizeModel, "prompt": SUMMARY_PROMPT + text, "stream": false}
+	} else {
+		body = map[string]interface{}{
+			"model":    *summarizeModel,
+			"messages": []map[string]string{{"role": "user", "content": SUMMARY_PROMPT + text}},
+		}
+	}
+	contents, _ := json.Marshal(body)
+	req, err := http.NewRequest("POST", *summarizeUrl, bytes.NewReader(contents))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", defaultUserAgent())
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := doRequest(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 200))
+		return "", fmt.Errorf("Unexpected status code: %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	result := struct {
+		// Ollama
+		Response string `json:"response"`
+		// OpenAI
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", errors.Wrapf(err, "Bad response from %s", *summarizeUrl)
+	}
+	summary := result.Response
+	if len(result.Choices) > 0 {
+		summary = result.Choices[0].Message.Content
+	}
+	return strings.Join(strings.Fields(summary), " "), nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+	xhtml "golang.org/x/net/html"
+)
+
+// Image to show next to an item: its media:thumbnail (directly or in a
+// media:group, as YouTube does), item image, first image enclosure, or first
+// image in its content. Relative urls are resolved against the item's link.
+func itemThumbnail(i *gofeed.Item, content string) string {
+	thumbnail := mediaThumbnail(i.Extensions)
+	if thumbnail == "" && i.Image != nil {
+		thumbnail = i.Image.URL
+	}
+	if thumbnail == "" {
+		for _, e := range i.Enclosures {
+			if strings.HasPrefix(e.Type, "image/") {
+				thumbnail = e.URL
+				break
+			}
+		}
+	}
+	if thumbnail == "" {
+		thumbnail = firstImage(content)
+	}
+	if thumbnail == "" {
+		return ""
+	}
+
+	u, err := url.Parse(thumbnail)
+	if err != nil {
+		return ""
+	}
+	if base, err := url.Parse(i.Link); err == nil {
+		u = base.ResolveReference(u)
+	}
+	return u.String()
+}
+
+func mediaThumbnail(extensions ext.Extensions) string {
+	media := extensions["media"]
+	for _, t := range media["thumbnail"] {
+		if t.Attrs["url"] != "" {
+			return t.Attrs["url"]
+		}
+	}
+	for _, group := range media["group"] {
+		for _, t := range group.Children["thumbnail"] {
+			if t.Attrs["url"] != "" {
+				return t.Attrs["url"]
+			}
+		}
+	}
+	// Image media:content, e.g. from photo blogs
+	for _, c := range media["content"] {
+		if c.Attrs["medium"] == "image" || strings.HasPrefix(c.Attrs["type"], "image/") {
+			return c.Attrs["url"]
+		}
+	}
+	return ""
+}
+
+// src of the first <img> in content
+func firstImage(content string) string {
+	z := xhtml.NewTokenizer(strings.NewReader(content))
+	for {
+		tt := z.Next()
+		switch tt {
+		case xhtml.ErrorToken:
+			return ""
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			token := z.Token()
+			if token.Data != "img" {
+				continue
+			}
+			for _, attr := range token.Attr {
+				if attr.Key == "src" && attr.Val != "" {
+					return attr.Val
+				}
+			}
+		}
+	}
+}
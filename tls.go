@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Tls config for serve from --tls-cert and --tls-key, or certificates for
+// domains from Let's Encrypt, nil if none are given. Issued certificates are
+// kept in the state directory and renewed before they expire. The ACME
+// challenge is answered over tls-alpn-01, so serve must be reachable on 443.
+func serveTlsConfig(domains []string, certFile string, keyFile string) (*tls.Config, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("--tls-cert and --tls-key must be given together")
+	}
+	if certFile != "" && len(domains) > 0 {
+		return nil, errors.New("Only one of --tls-domain and --tls-cert can be used")
+	}
+
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+	}
+
+	if len(domains) == 0 {
+		return nil, nil
+	}
+	dir, err := statePath("acme")
+	if err != nil {
+		return nil, err
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(dir),
+		HostPolicy: autocert.HostWhitelist(domains...),
+	}
+	tlsConfig := m.TLSConfig()
+	tlsConfig.MinVersion = tls.VersionTLS12
+	return tlsConfig, nil
+}
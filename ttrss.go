@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/pkg/errors"
+)
+
+// Most headlines the API returns per request, and in total per fetch
+const TTRSS_PAGE_SIZE = 200
+const TTRSS_MAX_HEADLINES = 1000
+
+// Virtual ids meaning all categories for getFeeds, and all articles for
+// getHeadlines
+const TTRSS_ALL_CATEGORIES = -4
+const TTRSS_ALL_ARTICLES = -4
+
+// A Tiny Tiny RSS install, read with its JSON API. Feeds with a ttrss+https (or
+// ttrss+http) url are sources for the unread articles of every subscription:
+//
+//	ttrss+https://me@example.com/tt-rss/
+//
+// with the password in the url's config section or the keyring under
+// me@example.com, rather than the url which ends up in the output.
+type ttrssClient struct {
+	api       string
+	user      string
+	password  string
+	userAgent string
+	session   string
+}
+
+type ttrssResponse struct {
+	Status  int             `json:"status"`
+	Content json.RawMessage `json:"content"`
+}
+
+// Ids are numbers in newer versions and strings in older ones
+type ttrssId string
+
+func (id *ttrssId) UnmarshalJSON(b []byte) error {
+	*id = ttrssId(strings.Trim(string(b), `"`))
+	return nil
+}
+
+type ttrssCategory struct {
+	ID    ttrssId `json:"id"`
+	Title string  `json:"title"`
+}
+
+type ttrssFeed struct {
+	ID         ttrssId `json:"id"`
+	Title      string  `json:"title"`
+	FeedUrl    string  `json:"feed_url"`
+	CategoryID ttrssId `json:"cat_id"`
+}
+
+type ttrssHeadline struct {
+	ID        ttrssId `json:"id"`
+	Title     string  `json:"title"`
+	Link      string  `json:"link"`
+	Updated   int64   `json:"updated"`
+	FeedID    ttrssId `json:"feed_id"`
+	FeedTitle string  `json:"feed_title"`
+	Content   string  `json:"content"`
+	Author    string  `json:"author"`
+}
+
+func isTtrss(u *url.URL) bool {
+	return strings.HasPrefix(u.Scheme, "ttrss+")
+}
+
+func newTtrssClient(u *url.URL, fc *FeedConfig) (*ttrssClient, error) {
+	user := u.User.Username()
+	if user == "" {
+		return nil, errors.New("No TT-RSS user, put it in the url like ttrss+https://user@host/tt-rss/")
+	}
+	password := fc.Password
+	if password == "" {
+		var err error
+		if password, err = keyringSecret(user + "@" + u.Hostname()); err != nil {
+			return nil, errors.Wrapf(err, "No password for %q in its config section", u.String())
+		}
+	}
+	api := *u
+	api.Scheme = strings.TrimPrefix(u.Scheme, "ttrss+")
+	api.User = nil
+	api.Path = strings.TrimSuffix(api.Path, "/") + "/api/"
+	return &ttrssClient{
+		api:       api.String(),
+		user:      user,
+		password:  password,
+		userAgent: fc.userAgent(),
+	}, nil
+}
+
+// Call op with params, decoding the response's content into result
+func (c *ttrssClient) call(ctx context.Context, op string, params map[string]interface{}, result interface{}) error {
+	body := map[string]interface{}{"op": op}
+	for k, v := range params {
+		body[k] = v
+	}
+	if c.session != "" {
+		body["sid"] = c.session
+	}
+	contents, _ := json.Marshal(body)
+
+	req, _ := http.NewRequest("POST", c.api, bytes.NewReader(contents))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	req = req.WithContext(ctx)
+	resp, err := doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Unexpected status code: %s", resp.Status)
+	}
+	contents, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "Failed reading response body")
+	}
+
+	r := &ttrssResponse{}
+	if err := json.Unmarshal(contents, r); err != nil {
+		return errors.Wrapf(err, "%s isn't a TT-RSS API", c.api)
+	}
+	if r.Status != 0 {
+		apiErr := struct {
+			Error string `json:"error"`
+		}{}
+		_ = json.Unmarshal(r.Content, &apiErr)
+		return fmt.Errorf("TT-RSS %s failed: %s", op, apiErr.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	return errors.Wrapf(json.Unmarshal(r.Content, result), "Bad TT-RSS %s response", op)
+}
+
+func (c *ttrssClient) login(ctx context.Context) error {
+	session := struct {
+		ID string `json:"session_id"`
+	}{}
+	params := map[string]interface{}{"user": c.user, "password": c.password}
+	if err := c.call(ctx, "login", params, &session); err != nil {
+		return err
+	}
+	c.session = session.ID
+	return nil
+}
+
+func (c *ttrssClient) logout(ctx context.Context) {
+	_ = c.call(ctx, "logout", nil, nil)
+	c.session = ""
+}
+
+// Unread articles of every subscription, as one feed whose items name the
+// subscription they're from and its category
+func fetchTtrssFeed(ctx context.Context, feedUrl *url.URL, fc *FeedConfig) (*gofeed.Feed, error) {
+	if *offline {
+		return nil, errors.New("TT-RSS feeds aren't cached, and --offline")
+	}
+
+	c, err := newTtrssClient(feedUrl, fc)
+	if err != nil {
+		return nil, err
+	}
+	release, err := limiter.acquire(ctx, feedUrl.Host)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if err := c.login(ctx); err != nil {
+		return nil, err
+	}
+	defer c.logout(ctx)
+
+	categoryList := []*ttrssCategory{}
+	if err := c.call(ctx, "getCategories", nil, &categoryList); err != nil {
+		return nil, err
+	}
+	categories := map[ttrssId]string{}
+	for _, cat := range categoryList {
+		categories[cat.ID] = cat.Title
+	}
+
+	feedList := []*ttrssFeed{}
+	if err := c.call(ctx, "getFeeds", map[string]interface{}{"cat_id": TTRSS_ALL_CATEGORIES}, &feedList); err != nil {
+		return nil, err
+	}
+	feeds := map[ttrssId]*ttrssFeed{}
+	for _, f := range feedList {
+		feeds[f.ID] = f
+	}
+
+	headlines := []*ttrssHeadline{}
+	for len(headlines) < TTRSS_MAX_HEADLINES {
+		page := []*ttrssHeadline{}
+		params := map[string]interface{}{
+			"feed_id":      TTRSS_ALL_ARTICLES,
+			"view_mode":    "unread",
+			"show_content": true,
+			"limit":        TTRSS_PAGE_SIZE,
+			"skip":         len(headlines),
+		}
+		if err := c.call(ctx, "getHeadlines", params, &page); err != nil {
+			return nil, err
+		}
+		headlines = append(headlines, page...)
+		if len(page) < TTRSS_PAGE_SIZE {
+			break
+		}
+	}
+
+	home := strings.TrimPrefix(feedUrl.Scheme, "ttrss+") + "://" + feedUrl.Host + feedUrl.Path
+	feed := &gofeed.Feed{Title: "TT-RSS", Link: home, Items: []*gofeed.Item{}}
+	for _, h := range headlines {
+		t := time.Unix(h.Updated, 0)
+		item := &gofeed.Item{
+			Title:           h.Title,
+			Link:            h.Link,
+			Content:         h.Content,
+			GUID:            string(h.ID),
+			PublishedParsed: &t,
+			Custom:          map[string]string{"feed_title": h.FeedTitle},
+		}
+		if h.Author != "" {
+			item.Author = &gofeed.Person{Name: h.Author}
+		}
+		if f, ok := feeds[h.FeedID]; ok {
+			if f.Title != "" {
+				item.Custom["feed_title"] = f.Title
+			}
+			if cat := categories[f.CategoryID]; cat != "" {
+				item.Categories = []string{cat}
+			}
+		}
+		feed.Items = append(feed.Items, item)
+	}
+	return feed, nil
+}
+
+// Mark posts from TT-RSS sources with mark-read set as read there, so
+// they're not unread in other readers after picofeed has shown them
+func ttrssMarkRead(ctx context.Context, posts []*Post) {
+	articles := map[string][]string{}
+	for _, p := range posts {
+		articles[p.FeedLink] = append(articles[p.FeedLink], p.GUID)
+	}
+	for feed, ids := range articles {
+		u, err := url.Parse(feed)
+		if err != nil || !isTtrss(u) {
+			continue
+		}
+		fc := config.feed(u)
+		if !fc.MarkRead {
+			continue
+		}
+
+		err = func() error {
+			ctx, cancel := context.WithTimeout(ctx, fc.timeout())
+			defer cancel()
+			c, err := newTtrssClient(u, fc)
+			if err != nil {
+				return err
+			}
+			if err := c.login(ctx); err != nil {
+				return err
+			}
+			defer c.logout(ctx)
+			// Mode 0 sets field 2, unread, to false
+			params := map[string]interface{}{"article_ids": strings.Join(ids, ","), "mode": 0, "field": 2}
+			return c.call(ctx, "updateArticle", params, nil)
+		}()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: failed marking %d posts read in %q: %v\n", len(ids), feed, err)
+		}
+	}
+}
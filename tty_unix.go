@@ -0,0 +1,84 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// The controlling terminal in raw mode, so keys are read as they're pressed,
+// even if stdin and stdout are redirected
+type tty struct {
+	*os.File
+	// stty settings to restore on Close
+	saved string
+}
+
+func openTty() (*tty, error) {
+	f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't open the terminal: %v", err)
+	}
+
+	t := &tty{File: f}
+	saved, err := t.stty("-g")
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	t.saved = strings.TrimSpace(saved)
+	if _, err := t.stty("raw", "-echo"); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *tty) Close() error {
+	_, _ = t.stty(t.saved)
+	return t.File.Close()
+}
+
+// Rows and columns, 24x80 if unknown
+func (t *tty) size() (int, int) {
+	out, err := t.stty("size")
+	var rows, cols int
+	if err != nil {
+		return 24, 80
+	}
+	if _, err := fmt.Sscanf(out, "%d %d", &rows, &cols); err != nil || rows == 0 || cols == 0 {
+		return 24, 80
+	}
+	return rows, cols
+}
+
+func (t *tty) stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = t.File
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("stty %s failed: %v", strings.Join(args, " "), err)
+	}
+	return string(out), nil
+}
+
+// Ask a question on the terminal and read a line in answer
+func promptTty(prompt string) (string, error) {
+	f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("Couldn't open the terminal: %v", err)
+	}
+	defer f.Close()
+
+	fmt.Fprint(f, prompt)
+	answer, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(answer, "\r\n"), nil
+}
@@ -0,0 +1,25 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+type tty struct {
+	*os.File
+}
+
+func openTty() (*tty, error) {
+	return nil, errors.New("Interactive mode isn't supported on windows")
+}
+
+func (t *tty) size() (int, int) {
+	return 24, 80
+}
+
+func promptTty(prompt string) (string, error) {
+	return "", errors.New("Interactive mode isn't supported on windows")
+}
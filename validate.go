@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// Items listed per problem before the rest are counted
+const VALIDATE_EXAMPLES = 3
+
+var xmlEncodingRegex = regexp.MustCompile(`^\s*<\?xml[^>]*encoding=["']([^"']+)["']`)
+
+// Characters XML doesn't allow even escaped, which strict parsers reject
+var xmlControlRegex = regexp.MustCompile("[\x00-\x08\x0B\x0C\x0E-\x1F]")
+
+// UTF-8 read as Latin-1 and encoded again, e.g. é showing as Ã©
+var mojibakeRegex = regexp.MustCompile(`Ã[\x{80}-\x{BF}]|â€`)
+
+// Problems found in a feed, with the items each applies to so they can be
+// summarized rather than repeated per item
+type validation struct {
+	problems []string
+	items    map[string][]string
+}
+
+func (v *validation) add(problem string, item string) {
+	if v.items == nil {
+		v.items = map[string][]string{}
+	}
+	if _, ok := v.items[problem]; !ok {
+		v.problems = append(v.problems, problem)
+	}
+	if item != "" {
+		v.items[problem] = append(v.items[problem], item)
+	}
+}
+
+// Check the feed at u for problems that trip up readers, printing a summary
+// and what to fix. Returns how many problems were found.
+func validateFeed(ctx context.Context, f io.Writer, u *url.URL) (int, error) {
+	fc := config.feed(u)
+	contents, err := fetchUrl(ctx, u, fc)
+	if err != nil {
+		return 0, err
+	}
+
+	v := &validation{}
+	encoding := "utf-8"
+	if m := xmlEncodingRegex.FindSubmatch(contents); m != nil {
+		encoding = strings.ToLower(string(m[1]))
+	}
+	if (encoding == "utf-8" || encoding == "utf8") && !utf8.Valid(contents) {
+		v.add("Feed isn't valid UTF-8 but doesn't declare another encoding, set encoding in <?xml ?> or convert it to UTF-8", "")
+	}
+	if xmlControlRegex.Match(contents) {
+		v.add("Feed contains control characters that aren't allowed in XML, strict readers will reject the whole feed", "")
+	}
+
+	feed, err := gofeed.NewParser().ParseString(string(contents))
+	if err == gofeed.ErrFeedTypeNotDetected {
+		if link := extractFeedLink(u, string(contents)); link != nil {
+			return 0, fmt.Errorf("Not a feed, but the page links to %s, validate that instead", link)
+		}
+		return 0, fmt.Errorf("Not an RSS, Atom or JSON feed")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if feed.Title == "" {
+		v.add("Feed has no title, readers will show its url instead", "")
+	}
+	if feed.Link == "" {
+		v.add("Feed has no link to its website", "")
+	}
+	if len(feed.Items) == 0 {
+		v.add("Feed has no items", "")
+	}
+
+	guids := map[string]bool{}
+	links := map[string]bool{}
+	now := time.Now()
+	for n, i := range feed.Items {
+		item := fmt.Sprintf("#%d", n+1)
+		if i.Title != "" {
+			item = fmt.Sprintf("%q", truncate(i.Title, 40))
+		}
+
+		if i.Title == "" {
+			v.add("Items have no title", item)
+		}
+		if mojibakeRegex.MatchString(i.Title + i.Description + i.Content) {
+			v.add("Items look double encoded (e.g. Ã© for é), UTF-8 was probably read as Latin-1 somewhere", item)
+		}
+
+		if i.Link == "" {
+			v.add("Items have no link", item)
+		} else if l, err := url.Parse(i.Link); err != nil {
+			v.add("Items have links that aren't urls", item)
+		} else if !l.IsAbs() {
+			v.add("Items have relative links, which readers resolve differently, use absolute urls", item)
+		} else if links[i.Link] {
+			v.add("Items share a link with an earlier item", item)
+		}
+		links[i.Link] = true
+
+		if i.PublishedParsed == nil && i.UpdatedParsed == nil {
+			if i.Published != "" || i.Updated != "" {
+				v.add("Items have dates that can't be parsed, use RFC 822 for RSS or RFC 3339 for Atom", item)
+			} else {
+				v.add("Items have no date, readers can't sort them or tell when they're new", item)
+			}
+		} else if t := i.PublishedParsed; t != nil && t.After(now.Add(24*time.Hour)) {
+			v.add("Items are dated in the future", item)
+		}
+
+		if i.GUID == "" {
+			v.add("Items have no guid (or Atom id), readers fall back to the link and show edits as new posts", item)
+		} else if guids[i.GUID] {
+			v.add("Items reuse the guid of an earlier item, readers will hide one of them", item)
+		}
+		guids[i.GUID] = true
+	}
+
+	fmt.Fprintf(f, "%s: %s %s feed, %d items\n", u, feed.FeedType, feed.FeedVersion, len(feed.Items))
+	for _, problem := range v.problems {
+		fmt.Fprintf(f, "  - %s", problem)
+		items := v.items[problem]
+		if len(items) > 0 {
+			shown := items
+			if len(shown) > VALIDATE_EXAMPLES {
+				shown = shown[:VALIDATE_EXAMPLES]
+			}
+			fmt.Fprintf(f, ": %s", strings.Join(shown, ", "))
+			if len(items) > len(shown) {
+				fmt.Fprintf(f, " and %d more", len(items)-len(shown))
+			}
+		}
+		fmt.Fprintf(f, "\n")
+	}
+	if len(v.problems) == 0 {
+		fmt.Fprintf(f, "  No problems found\n")
+	}
+	return len(v.problems), nil
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// A compiled --where expression, e.g.
+//
+//	feed.host == "lobste.rs" && age < 48h && title matches "(?i)go"
+//
+// Fields are post properties (see whereFields), compared with == != < <= > >=,
+// matches (a regexp) or contains, and combined with && || ! and parentheses.
+// Literals are "strings", numbers, durations like 48h or 7d, and true/false.
+type whereExpr struct {
+	typ  whereType
+	eval func(p *Post) interface{}
+}
+
+type whereType int
+
+const (
+	whereBool whereType = iota
+	whereString
+	whereNumber
+	whereDuration
+)
+
+func (t whereType) String() string {
+	return [...]string{"bool", "string", "number", "duration"}[t]
+}
+
+// Post properties available to --where
+var whereFields = map[string]*whereExpr{
+	"title":      {whereString, func(p *Post) interface{} { return p.Title }},
+	"link":       {whereString, func(p *Post) interface{} { return p.Link }},
+	"content":    {whereString, func(p *Post) interface{} { return p.Content }},
+	"guid":       {whereString, func(p *Post) interface{} { return p.GUID }},
+	"author":     {whereString, func(p *Post) interface{} { return p.Author }},
+	"categories": {whereString, func(p *Post) interface{} { return strings.Join(p.Categories, ", ") }},
+	"lang":       {whereString, func(p *Post) interface{} { return p.Lang }},
+	"words":      {whereNumber, func(p *Post) interface{} { return float64(p.Words) }},
+	"minutes":    {whereNumber, func(p *Post) interface{} { return float64(p.ReadingMinutes) }},
+	"age":        {whereDuration, func(p *Post) interface{} { return time.Since(*p.Timestamp) }},
+	"updated":    {whereBool, func(p *Post) interface{} { return p.Updated }},
+	"feed":       {whereString, func(p *Post) interface{} { return p.shortFeedName() }},
+	"feed.host":  {whereString, func(p *Post) interface{} { return p.shortFeedLink() }},
+	"feed.url":   {whereString, func(p *Post) interface{} { return p.FeedLink }},
+	"feed.title": {whereString, func(p *Post) interface{} { return p.FeedTitle }},
+	"feed.alias": {whereString, func(p *Post) interface{} { return p.FeedAlias }},
+}
+
+func (e *whereExpr) match(p *Post) bool {
+	return e.eval(p).(bool)
+}
+
+func compileWhere(s string) (*whereExpr, error) {
+	tokens, err := lexWhere(s)
+	if err != nil {
+		return nil, err
+	}
+	parser := &whereParser{tokens: tokens}
+	e, err := parser.or()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(tokens) {
+		return nil, fmt.Errorf("Unexpected %q in --where", tokens[parser.pos])
+	}
+	if e.typ != whereBool {
+		return nil, fmt.Errorf("--where must be true or false, not a %v", e.typ)
+	}
+	return e, nil
+}
+
+func lexWhere(s string) ([]string, error) {
+	tokens := []string{}
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			// Quoted string, with backslash escapes
+			j := i + 1
+			for j < len(s) && rune(s[j]) != c {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("Unterminated string in --where: %s", s[i:])
+			}
+			tokens = append(tokens, s[i:j+1])
+			i = j + 1
+		case strings.ContainsRune("=!<>&|", c):
+			if i+1 < len(s) && contains([]string{"==", "!=", "<=", ">=", "&&", "||"}, s[i:i+2]) {
+				tokens = append(tokens, s[i:i+2])
+				i += 2
+			} else if c == '&' || c == '|' || c == '=' {
+				return nil, fmt.Errorf("Unexpected %q in --where, did you mean %c%c?", c, c, c)
+			} else {
+				tokens = append(tokens, string(c))
+				i++
+			}
+		case c == '(' || c == ')':
+			tokens = append(tokens, string(c))
+			i++
+		case unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '.':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_' || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("Unexpected %q in --where", c)
+		}
+	}
+	return tokens, nil
+}
+
+type whereParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *whereParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *whereParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *whereParser) or() (*whereExpr, error) {
+	return p.binary("||", p.and, func(a bool, b func() bool) bool { return a || b() })
+}
+
+func (p *whereParser) and() (*whereExpr, error) {
+	return p.binary("&&", p.not, func(a bool, b func() bool) bool { return a && b() })
+}
+
+// Left associative chain of op, short circuiting through combine
+func (p *whereParser) binary(op string, operand func() (*whereExpr, error), combine func(bool, func() bool) bool) (*whereExpr, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == op {
+		p.next()
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		if left.typ != whereBool || right.typ != whereBool {
+			return nil, fmt.Errorf("%s needs true or false on both sides in --where", op)
+		}
+		l, r := left, right
+		left = &whereExpr{whereBool, func(post *Post) interface{} {
+			return combine(l.match(post), func() bool { return r.match(post) })
+		}}
+	}
+	return left, nil
+}
+
+func (p *whereParser) not() (*whereExpr, error) {
+	if p.peek() != "!" {
+		return p.comparison()
+	}
+	p.next()
+	e, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	if e.typ != whereBool {
+		return nil, fmt.Errorf("! needs true or false in --where, not a %v", e.typ)
+	}
+	return &whereExpr{whereBool, func(post *Post) interface{} { return !e.match(post) }}, nil
+}
+
+func (p *whereParser) comparison() (*whereExpr, error) {
+	left, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+
+	op := p.peek()
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "contains":
+		p.next()
+		right, err := p.primary()
+		if err != nil {
+			return nil, err
+		}
+		return compare(left, op, right)
+	case "matches":
+		p.next()
+		pattern := p.next()
+		if left.typ != whereString || pattern == "" || (pattern[0] != '"' && pattern[0] != '\'') {
+			return nil, fmt.Errorf("matches needs a string on the left and a quoted regexp on the right in --where")
+		}
+		re, err := regexp.Compile(unquoteWhere(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regexp in --where: %v", err)
+		}
+		return &whereExpr{whereBool, func(post *Post) interface{} { return re.MatchString(left.eval(post).(string)) }}, nil
+	}
+	return left, nil
+}
+
+func compare(left *whereExpr, op string, right *whereExpr) (*whereExpr, error) {
+	if left.typ != right.typ {
+		return nil, fmt.Errorf("Can't compare a %v with a %v in --where", left.typ, right.typ)
+	}
+	if op == "contains" && left.typ != whereString {
+		return nil, fmt.Errorf("contains needs strings in --where")
+	}
+	if left.typ == whereBool && op != "==" && op != "!=" {
+		return nil, fmt.Errorf("%s can't compare true or false in --where", op)
+	}
+
+	return &whereExpr{whereBool, func(post *Post) interface{} {
+		a, b := left.eval(post), right.eval(post)
+		var cmp int
+		switch a := a.(type) {
+		case bool:
+			if a != b.(bool) {
+				cmp = 1
+			}
+		case string:
+			if op == "contains" {
+				return strings.Contains(strings.ToLower(a), strings.ToLower(b.(string)))
+			}
+			cmp = strings.Compare(a, b.(string))
+		case float64:
+			cmp = compareFloats(a, b.(float64))
+		case time.Duration:
+			cmp = compareFloats(float64(a), float64(b.(time.Duration)))
+		}
+
+		switch op {
+		case "==":
+			return cmp == 0
+		case "!=":
+			return cmp != 0
+		case "<":
+			return cmp < 0
+		case "<=":
+			return cmp <= 0
+		case ">":
+			return cmp > 0
+		default:
+			return cmp >= 0
+		}
+	}}, nil
+}
+
+func compareFloats(a float64, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func (p *whereParser) primary() (*whereExpr, error) {
+	t := p.next()
+	switch {
+	case t == "":
+		return nil, fmt.Errorf("Unexpected end of --where")
+	case t == "(":
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("Missing ) in --where")
+		}
+		return e, nil
+	case t == "true" || t == "false":
+		value := t == "true"
+		return &whereExpr{whereBool, func(*Post) interface{} { return value }}, nil
+	case t[0] == '"' || t[0] == '\'':
+		value := unquoteWhere(t)
+		return &whereExpr{whereString, func(*Post) interface{} { return value }}, nil
+	case unicode.IsDigit(rune(t[0])):
+		if n, err := strconv.ParseFloat(t, 64); err == nil {
+			return &whereExpr{whereNumber, func(*Post) interface{} { return n }}, nil
+		}
+		d, err := parseDays(t)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid number or duration %q in --where", t)
+		}
+		return &whereExpr{whereDuration, func(*Post) interface{} { return d }}, nil
+	}
+
+	if field, ok := whereFields[t]; ok {
+		return field, nil
+	}
+	return nil, fmt.Errorf("Unknown field %q in --where", t)
+}
+
+// Backslashes are literal unless escaping the quote, so regexps don't need
+// double escaping
+func unquoteWhere(t string) string {
+	quote := t[:1]
+	return strings.Replace(t[1:len(t)-1], "\\"+quote, quote, -1)
+}
+
+// time.ParseDuration, also taking days (7d) and weeks (2w)
+func parseDays(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(s, suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSuffix(s, suffix), 64)
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(n * float64(unit)), nil
+		}
+	}
+	return time.ParseDuration(s)
+}